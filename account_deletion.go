@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// runAccountDeletion carries out a pending account deletion. Every step can be
+// run again, so a deletion that fails half way is finished by running it
+// again: on the next request to delete the account, or when the server
+// starts.
+func (cfg *apiConfig) runAccountDeletion(ctx context.Context, deletion database.AccountDeletion) error {
+	err := cfg.deleteAccountData(ctx, deletion)
+	if err != nil {
+		if recordErr := cfg.db.RecordAccountDeletionFailure(ctx, deletion.UserID, err.Error()); recordErr != nil {
+			log.Println("runAccountDeletion() unable to record failure", recordErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (cfg *apiConfig) deleteAccountData(ctx context.Context, deletion database.AccountDeletion) error {
+	videos, err := cfg.db.GetVideos(ctx, deletion.UserID)
+	if err != nil {
+		return err
+	}
+	// workspaces the user is alone in go with them
+	workspaces, err := cfg.db.GetUserWorkspaces(ctx, deletion.UserID)
+	if err != nil {
+		return err
+	}
+	for _, workspace := range workspaces {
+		members, err := cfg.db.GetWorkspaceMembers(ctx, workspace.ID)
+		if err != nil {
+			return err
+		}
+		if len(members) > 1 {
+			continue
+		}
+		workspaceVideos, err := cfg.db.GetWorkspaceVideos(ctx, workspace.ID)
+		if err != nil {
+			return err
+		}
+		videos = append(videos, workspaceVideos...)
+	}
+
+	for _, video := range videos {
+		if err := cfg.deleteVideoMedia(ctx, video); err != nil {
+			return fmt.Errorf("deleting media of video %s: %w", video.ID, err)
+		}
+		if err := cfg.db.DeleteVideo(ctx, video.ID); err != nil {
+			return fmt.Errorf("deleting video %s: %w", video.ID, err)
+		}
+	}
+
+	if err := cfg.db.ClearLoginAttempts(ctx, accountThrottleKey(deletion.Email)); err != nil {
+		return err
+	}
+	return cfg.db.FinishAccountDeletion(ctx, deletion.UserID)
+}
+
+// deleteVideoMedia removes the files of the video from the bucket and the
+// assets directory. Files that are already gone are skipped.
+func (cfg *apiConfig) deleteVideoMedia(ctx context.Context, video database.Video) error {
+	keys := []string{}
+	if key, ok := cfg.mediaKey(video.VideoURL); ok {
+		keys = append(keys, key)
+		// audio renditions are stored next to the video, only the first
+		// one is in the database
+		keyBase := strings.TrimSuffix(key, path.Ext(key))
+		for _, format := range audioFormats {
+			keys = append(keys, keyBase+format.extension)
+		}
+	}
+	for _, key := range keys {
+		if err := cfg.uploader.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	assetURLs := []*string{video.ThumbnailURL}
+	cuts, err := cfg.db.GetSceneCuts(ctx, video.ID)
+	if err != nil {
+		return err
+	}
+	for _, cut := range cuts {
+		assetURLs = append(assetURLs, cut.FrameURL)
+	}
+	for _, assetURL := range assetURLs {
+		if err := cfg.removeAsset(assetURL); err != nil {
+			return err
+		}
+	}
+	delete(videoThumbnails, video.ID)
+	return nil
+}
+
+// resumeAccountDeletions finishes the deletions that were cut short by a
+// failure or a restart.
+func (cfg *apiConfig) resumeAccountDeletions(ctx context.Context) {
+	deletions, err := cfg.db.GetAccountDeletions(ctx)
+	if err != nil {
+		log.Println("resumeAccountDeletions() unable to get pending deletions", err)
+		return
+	}
+	for _, deletion := range deletions {
+		if err := cfg.runAccountDeletion(ctx, deletion); err != nil {
+			log.Printf("resumeAccountDeletions() unable to delete account %s: %v", deletion.UserID, err)
+			continue
+		}
+		log.Printf("resumeAccountDeletions() deleted account %s", deletion.UserID)
+	}
+}
+
+// soleAdminWorkspaces returns the names of the workspaces that would be left
+// without an admin, but with other members, if the user was deleted.
+func (cfg *apiConfig) soleAdminWorkspaces(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	workspaces, err := cfg.db.GetUserWorkspaces(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, workspace := range workspaces {
+		if workspace.Role != database.WorkspaceRoleAdmin {
+			continue
+		}
+		members, err := cfg.db.GetWorkspaceMembers(ctx, workspace.ID)
+		if err != nil {
+			return nil, err
+		}
+		otherAdmin := false
+		for _, member := range members {
+			if member.UserID != userID && member.Role == database.WorkspaceRoleAdmin {
+				otherAdmin = true
+			}
+		}
+		if len(members) > 1 && !otherAdmin {
+			names = append(names, workspace.Name)
+		}
+	}
+	return names, nil
+}
@@ -1,6 +1,47 @@
 document.addEventListener('DOMContentLoaded', async () => {
+  // single sign-on logins come back with the tokens in the URL fragment
+  const fragment = new URLSearchParams(window.location.hash.slice(1));
+  if (fragment.get('token')) {
+    localStorage.setItem('token', fragment.get('token'));
+    history.replaceState(null, '', window.location.pathname);
+  }
+  // or with a challenge when the account has two-factor authentication
+  if (fragment.get('two_factor_required')) {
+    history.replaceState(null, '', window.location.pathname);
+    try {
+      const data = await loginTwoFactor(fragment.get('challenge_token'), fragment.get('device_label'));
+      localStorage.setItem('token', data.token);
+    } catch (error) {
+      alert(`Error: ${error.message}`);
+    }
+  }
+  // so do the links in verification and password reset emails
+  if (fragment.get('verify_email')) {
+    history.replaceState(null, '', window.location.pathname);
+    await confirmEmail(fragment.get('verify_email'));
+  }
+  if (fragment.get('reset_password')) {
+    history.replaceState(null, '', window.location.pathname);
+    await resetPassword(fragment.get('reset_password'));
+  }
+  // share links open the video without logging in
+  if (fragment.get('share')) {
+    await viewSharedVideo(fragment.get('share'));
+    return;
+  }
+
   const token = localStorage.getItem('token');
 
+  // workspace invitations are accepted by the logged in invitee
+  if (fragment.get('invitation')) {
+    if (token) {
+      history.replaceState(null, '', window.location.pathname);
+      await acceptInvitation(fragment.get('invitation'));
+    } else {
+      alert('Log in to accept the workspace invitation.');
+    }
+  }
+
   if (token) {
     document.getElementById('auth-section').style.display = 'none';
     document.getElementById('video-section').style.display = 'block';
@@ -61,11 +102,15 @@ async function login() {
       },
       body: JSON.stringify({ email, password }),
     });
-    const data = await res.json();
+    let data = await res.json();
     if (!res.ok) {
       throw new Error(`Failed to login: ${data.error}`);
     }
 
+    if (data.two_factor_required) {
+      data = await loginTwoFactor(data.challenge_token);
+    }
+
     if (data.token) {
       localStorage.setItem('token', data.token);
       document.getElementById('auth-section').style.display = 'none';
@@ -79,6 +124,82 @@ async function login() {
   }
 }
 
+async function loginTwoFactor(challengeToken, deviceLabel = '') {
+  const code = prompt('Enter the code from your authenticator app, or a recovery code');
+  if (!code) {
+    throw new Error('Login cancelled');
+  }
+  const isRecoveryCode = code.includes('-');
+  const res = await fetch('/api/login/2fa', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({
+      challenge_token: challengeToken,
+      code: isRecoveryCode ? '' : code,
+      recovery_code: isRecoveryCode ? code : '',
+      device_label: deviceLabel,
+    }),
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    throw new Error(`Failed to login: ${data.error}`);
+  }
+  return data;
+}
+
+async function confirmEmail(token) {
+  const res = await fetch('/api/email_verification/confirm', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({ token }),
+  });
+  if (!res.ok) {
+    const data = await res.json();
+    alert(`Couldn't verify email: ${data.error}`);
+    return;
+  }
+  alert('Your email address is verified.');
+}
+
+async function resetPassword(token) {
+  const password = prompt('Choose a new password');
+  if (!password) {
+    return;
+  }
+  const res = await fetch('/api/password_reset/confirm', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({ token, password }),
+  });
+  if (!res.ok) {
+    const data = await res.json();
+    alert(`Couldn't reset password: ${data.error}`);
+    return;
+  }
+  alert('Your password was changed, log in with the new one.');
+}
+
+async function acceptInvitation(invitationID) {
+  const res = await fetch(`/api/workspace_invitations/${encodeURIComponent(invitationID)}/accept`, {
+    method: 'POST',
+    headers: {
+      Authorization: `Bearer ${localStorage.getItem('token')}`,
+    },
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    alert(`Couldn't accept invitation: ${data.error}`);
+    return;
+  }
+  alert(`You joined the ${data.name} workspace as ${data.role}.`);
+}
+
 async function signup() {
   const email = document.getElementById('email').value;
   const password = document.getElementById('password').value;
@@ -276,6 +397,35 @@ function viewVideo(video) {
   }
 }
 
+async function viewSharedVideo(shareToken, password) {
+  try {
+    const res = await fetch(`/api/share_links/${encodeURIComponent(shareToken)}`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ password }),
+    });
+    const data = await res.json();
+    if (res.status === 401) {
+      const retry = prompt(`${data.error}. Password:`);
+      if (retry) {
+        await viewSharedVideo(shareToken, retry);
+      }
+      return;
+    }
+    if (!res.ok) {
+      throw new Error(data.error);
+    }
+
+    document.getElementById('auth-section').style.display = 'none';
+    document.getElementById('video-section').style.display = 'block';
+    viewVideo(data.video);
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
 async function deleteVideo() {
   if (!currentVideo) {
     alert('No video selected for deletion.');
@@ -1,12 +1,111 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
-func (cfg apiConfig) ensureAssetsDir() error {
+// mediaURLLifetime is how long the signed URLs handed out with a video work.
+const mediaURLLifetime = time.Hour
+
+func (cfg *apiConfig) ensureAssetsDir() error {
 	if _, err := os.Stat(cfg.assetsRoot); os.IsNotExist(err) {
 		return os.Mkdir(cfg.assetsRoot, 0755)
 	}
 	return nil
 }
+
+// removeAsset deletes the file behind an URL of the assets directory. URLs
+// pointing anywhere else and files that are already gone are skipped.
+func (cfg *apiConfig) removeAsset(assetURL *string) error {
+	if assetURL == nil || !strings.Contains(*assetURL, "/assets/") {
+		return nil
+	}
+	err := os.Remove(filepath.Join(cfg.assetsRoot, path.Base(*assetURL)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// signAssetURL adds a signature to an URL of the assets directory, which
+// handlerAsset only serves with one. URLs pointing anywhere else are kept as
+// they are.
+func (cfg *apiConfig) signAssetURL(assetURL *string, lifetime time.Duration) *string {
+	if assetURL == nil || !strings.Contains(*assetURL, "/assets/") {
+		return assetURL
+	}
+	expiresAt := time.Now().Add(lifetime)
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("signature", auth.SignAssetURL(cfg.jwtSecret, path.Base(*assetURL), expiresAt))
+	signed := *assetURL + "?" + query.Encode()
+	return &signed
+}
+
+// handlerAsset serves a thumbnail or scene frame from a link made by
+// signAssetURL. Nothing in the assets directory is served without one, so a
+// link stops working when it expires even if the video has since been made
+// private or unshared.
+func (cfg *apiConfig) handlerAsset(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil || !filepath.IsLocal(name) {
+		respondWithError(w, http.StatusNotFound, "Asset not found", err)
+		return
+	}
+	expiresAt := time.Unix(expires, 0)
+	err = auth.CheckAssetURL(cfg.jwtSecret, name, r.URL.Query().Get("signature"), expiresAt)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "Asset link is invalid or has expired", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(time.Until(expiresAt).Seconds())))
+	http.ServeFile(w, r, filepath.Join(cfg.assetsRoot, name))
+}
+
+// viewableVideo returns the video with URLs its viewer can load: thumbnails
+// are signed, and the files of videos that aren't public are presigned so
+// access ends with the URL.
+func (cfg *apiConfig) viewableVideo(ctx context.Context, video database.Video) (database.Video, error) {
+	video.ThumbnailURL = cfg.signAssetURL(video.ThumbnailURL, mediaURLLifetime)
+	if video.Visibility == database.VisibilityPublic {
+		return video, nil
+	}
+	var err error
+	video.VideoURL, err = cfg.presignMediaURL(ctx, video.VideoURL, mediaURLLifetime)
+	if err != nil {
+		return database.Video{}, err
+	}
+	video.AudioURL, err = cfg.presignMediaURL(ctx, video.AudioURL, mediaURLLifetime)
+	if err != nil {
+		return database.Video{}, err
+	}
+	return video, nil
+}
+
+func (cfg *apiConfig) viewableVideos(ctx context.Context, videos []database.Video) ([]database.Video, error) {
+	viewable := slices.Clone(videos)
+	for i := range viewable {
+		var err error
+		viewable[i], err = cfg.viewableVideo(ctx, viewable[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return viewable, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// getAsset requests an URL of the assets directory, ignoring its host.
+func (ut *uploadTest) getAsset(t *testing.T, assetURL string) *httptest.ResponseRecorder {
+	t.Helper()
+	parsed, err := url.Parse(assetURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, parsed.RequestURI(), nil)
+	rec := httptest.NewRecorder()
+	ut.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func (ut *uploadTest) getVideo(t *testing.T, videoID, token string) database.Video {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/videos/"+videoID, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	ut.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("getting the video returned %d: %s", rec.Code, rec.Body)
+	}
+	var video database.Video
+	if err := json.NewDecoder(rec.Body).Decode(&video); err != nil {
+		t.Fatal(err)
+	}
+	return video
+}
+
+func TestVideoMediaURLs(t *testing.T) {
+	ut := newUploadTest(t)
+	ut.mux.HandleFunc("GET /api/videos/{videoID}", ut.cfg.handlerVideoGet)
+	ut.mux.HandleFunc("GET /assets/{name}", ut.cfg.handlerAsset)
+	userID, token := ut.createUser(t, "owner@example.com")
+
+	if err := os.WriteFile(filepath.Join(ut.cfg.assetsRoot, "thumb.jpg"), []byte("jpeg"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	video := ut.createVideo(t, userID)
+	thumbnailURL := "http://localhost:8091/assets/thumb.jpg"
+	videoURL := ut.cfg.s3CfDistribution + "/landscape/video.mp4"
+	video.ThumbnailURL = &thumbnailURL
+	video.VideoURL = &videoURL
+	if err := ut.cfg.db.UpdateVideo(context.Background(), video); err != nil {
+		t.Fatal(err)
+	}
+
+	got := ut.getVideo(t, video.ID.String(), token)
+	if got.VideoURL == nil || *got.VideoURL != "https://bucket.example/landscape/video.mp4?expires=1h0m0s" {
+		t.Errorf("got video URL %v for a private video, want a presigned one", got.VideoURL)
+	}
+	if got.ThumbnailURL == nil || !strings.HasPrefix(*got.ThumbnailURL, thumbnailURL+"?") {
+		t.Fatalf("got thumbnail URL %v, want a signed one", got.ThumbnailURL)
+	}
+	rec := ut.getAsset(t, *got.ThumbnailURL)
+	if rec.Code != http.StatusOK || rec.Body.String() != "jpeg" {
+		t.Errorf("the signed thumbnail URL returned %d: %s", rec.Code, rec.Body)
+	}
+
+	signed, _ := url.Parse(*got.ThumbnailURL)
+	query := signed.Query()
+	query.Set("expires", "9999999999")
+	for name, assetURL := range map[string]string{
+		"unsigned":    thumbnailURL,
+		"other file":  strings.Replace(*got.ThumbnailURL, "thumb.jpg", "tubely.db", 1),
+		"tampered":    thumbnailURL + "?" + query.Encode(),
+		"expired":     *ut.cfg.signAssetURL(&thumbnailURL, -time.Minute),
+		"not a video": "http://localhost:8091/assets/tubely.db",
+	} {
+		if rec := ut.getAsset(t, assetURL); rec.Code == http.StatusOK {
+			t.Errorf("%s: got %d for %s", name, rec.Code, assetURL)
+		}
+	}
+
+	video.Visibility = database.VisibilityPublic
+	if err := ut.cfg.db.UpdateVideo(context.Background(), video); err != nil {
+		t.Fatal(err)
+	}
+	got = ut.getVideo(t, video.ID.String(), token)
+	if got.VideoURL == nil || *got.VideoURL != videoURL {
+		t.Errorf("got video URL %v for a public video, want %s", got.VideoURL, videoURL)
+	}
+}
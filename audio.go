@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+type audioFormat struct {
+	extension string
+	mediaType string
+	encoder   string
+	codecArgs []string
+	// artwork is only embedded where the container supports an attached picture
+	artwork bool
+}
+
+var audioFormats = map[string]audioFormat{
+	"m4a": {
+		extension: ".m4a",
+		mediaType: "audio/mp4",
+		encoder:   "aac",
+		codecArgs: []string{"-c:a", "aac", "-b:a", "128k"},
+		artwork:   true,
+	},
+	"opus": {
+		extension: ".opus",
+		mediaType: "audio/ogg",
+		encoder:   "libopus",
+		codecArgs: []string{"-c:a", "libopus", "-b:a", "64k"},
+	},
+}
+
+// parseAudioFormats turns a comma separated list like "m4a,opus" into the
+// renditions to produce. The first entry is the one exposed as audio_url.
+func parseAudioFormats(list string) ([]string, error) {
+	formats := []string{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := audioFormats[name]; !ok {
+			return nil, fmt.Errorf("unsupported audio format %q", name)
+		}
+		formats = append(formats, name)
+	}
+	return formats, nil
+}
+
+// localThumbnailPath finds the thumbnail of a video on disc, if it was stored
+// in the assets directory by handlerUploadThumbnail.
+func (cfg *apiConfig) localThumbnailPath(video database.Video) (string, bool) {
+	if video.ThumbnailURL == nil || !strings.Contains(*video.ThumbnailURL, "/assets/") {
+		return "", false
+	}
+	thumbPath := filepath.Join(cfg.assetsRoot, path.Base(*video.ThumbnailURL))
+	ok, err := exists(thumbPath)
+	if err != nil || !ok {
+		return "", false
+	}
+	return thumbPath, true
+}
+
+// uploadAudioRenditions extracts every configured audio rendition of the
+// processed video and stores it next to the video under keyBase. It returns
+// the URL of the first rendition. When one of them fails, the ones already
+// stored are deleted again, so a video has either all renditions or none.
+func (cfg *apiConfig) uploadAudioRenditions(ctx context.Context, filePath, keyBase string, video database.Video) (string, error) {
+	artworkPath, _ := cfg.localThumbnailPath(video)
+	duration := 0.0
+	if video.Duration != nil {
+		duration = *video.Duration
+	}
+	audioURL := ""
+	stored := []string{}
+	for _, name := range cfg.audioFormats {
+		format := audioFormats[name]
+		audioPath, err := cfg.transcoder.ExtractAudio(ctx, filePath, artworkPath, video.Title, format, duration)
+		if err == nil {
+			err = cfg.putFile(ctx, audioPath, keyBase+format.extension, format.mediaType)
+			os.Remove(audioPath)
+		}
+		if err != nil {
+			for _, key := range stored {
+				if deleteErr := cfg.uploader.Delete(ctx, key); deleteErr != nil {
+					log.Println("uploadAudioRenditions() unable to delete", key, deleteErr)
+				}
+			}
+			return "", err
+		}
+		stored = append(stored, keyBase+format.extension)
+		if audioURL == "" {
+			audioURL = fmt.Sprintf("%s/%s%s", cfg.s3CfDistribution, keyBase, format.extension)
+		}
+	}
+	return audioURL, nil
+}
+
+func (cfg *apiConfig) putFile(ctx context.Context, filePath, key, contentType string) error {
+	fp, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return cfg.uploader.Put(ctx, key, contentType, fp)
+}
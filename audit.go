@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Audit actions, grouped by what they're taken on.
+const (
+	auditLogin             = "auth.login"
+	auditLoginTwoFactor    = "auth.login_2fa"
+	auditLoginOIDC         = "auth.login_oidc"
+	auditRefreshReuse      = "auth.refresh_token_reuse"
+	auditLogout            = "auth.logout"
+	auditSessionRevoke     = "auth.session_revoke"
+	auditSessionsRevokeAll = "auth.sessions_revoke_all"
+	auditAPIKeyCreate      = "auth.api_key_create"
+	auditAPIKeyRevoke      = "auth.api_key_revoke"
+	auditTOTPEnable        = "auth.totp_enable"
+	auditTOTPDisable       = "auth.totp_disable"
+	auditPasswordReset     = "auth.password_reset"
+	auditPasswordChange    = "auth.password_change"
+
+	auditUserCreate    = "user.create"
+	auditEmailChange   = "user.email_change"
+	auditEmailVerify   = "user.email_verify"
+	auditAccountDelete = "user.delete"
+
+	auditVideoCreate     = "video.create"
+	auditVideoUpload     = "video.upload"
+	auditThumbnailUpload = "video.thumbnail_upload"
+	auditVideoDelete     = "video.delete"
+	auditVideoVisibility = "video.visibility_change"
+	auditVideoShare      = "video.share"
+	auditVideoUnshare    = "video.unshare"
+	auditShareLinkCreate = "video.share_link_create"
+	auditShareLinkRevoke = "video.share_link_revoke"
+
+	auditWorkspaceCreate           = "workspace.create"
+	auditWorkspaceMemberRole       = "workspace.member_role_change"
+	auditWorkspaceMemberRemove     = "workspace.member_remove"
+	auditWorkspaceInvite           = "workspace.invite"
+	auditWorkspaceInvitationRevoke = "workspace.invitation_revoke"
+	auditWorkspaceInvitationAccept = "workspace.invitation_accept"
+
+	auditAdminRoleChange  = "admin.role_change"
+	auditAdminReset       = "admin.reset"
+	auditAdminAuditExport = "admin.audit_export"
+	auditPermissionDenied = "admin.permission_denied"
+)
+
+// Audit target types.
+const (
+	auditTargetUser       = "user"
+	auditTargetSession    = "session"
+	auditTargetAPIKey     = "api_key"
+	auditTargetVideo      = "video"
+	auditTargetShareLink  = "share_link"
+	auditTargetWorkspace  = "workspace"
+	auditTargetInvitation = "workspace_invitation"
+	auditTargetDatabase   = "database"
+	auditTargetAuditLog   = "audit_log"
+)
+
+// audit records an event with the client address and user agent of r.
+// actorID is uuid.Nil when the caller isn't known. Failing to record is only
+// logged, it never fails the request.
+func (cfg *apiConfig) audit(r *http.Request, actorID uuid.UUID, action, targetType, targetID, outcome, detail string) {
+	params := database.CreateAuditEventParams{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         clientIP(r),
+		UserAgent:  r.UserAgent(),
+		Outcome:    outcome,
+		Detail:     detail,
+	}
+	if actorID != uuid.Nil {
+		params.ActorID = &actorID
+	}
+	if err := cfg.db.CreateAuditEvent(r.Context(), params); err != nil {
+		log.Printf("audit() unable to record %s: %v", action, err)
+	}
+}
+
+// auditSuccess records an action that worked.
+func (cfg *apiConfig) auditSuccess(r *http.Request, actorID uuid.UUID, action, targetType, targetID string) {
+	cfg.audit(r, actorID, action, targetType, targetID, database.AuditOutcomeSuccess, "")
+}
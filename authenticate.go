@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// authenticate identifies the caller from either a bearer JWT or an API key
+// and writes the error response when it can't. A JWT may do anything its user
+// can, an API key only what its scopes allow.
+func (cfg *apiConfig) authenticate(w http.ResponseWriter, r *http.Request, scope string) (uuid.UUID, bool) {
+	if strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+		return cfg.authenticateAPIKey(w, r, scope)
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, false
+	}
+	userID, err := cfg.keyRing().ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// authenticateOptional is authenticate for endpoints anonymous callers may
+// use too. It returns uuid.Nil when there is no Authorization header.
+func (cfg *apiConfig) authenticateOptional(w http.ResponseWriter, r *http.Request, scope string) (uuid.UUID, bool) {
+	if r.Header.Get("Authorization") == "" {
+		return uuid.Nil, true
+	}
+	return cfg.authenticate(w, r, scope)
+}
+
+func (cfg *apiConfig) authenticateAPIKey(w http.ResponseWriter, r *http.Request, scope string) (uuid.UUID, bool) {
+	key, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find API key", err)
+		return uuid.Nil, false
+	}
+	prefix, err := auth.APIKeyPrefix(key)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate API key", err)
+		return uuid.Nil, false
+	}
+	apiKey, err := cfg.db.GetAPIKeyByPrefix(r.Context(), prefix)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return uuid.Nil, false
+	}
+	if apiKey.ID == uuid.Nil || auth.CheckAPIKeyHash(key, apiKey.KeyHash) != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate API key", nil)
+		return uuid.Nil, false
+	}
+	if apiKey.RevokedAt != nil {
+		respondWithError(w, http.StatusUnauthorized, "API key has been revoked", nil)
+		return uuid.Nil, false
+	}
+	if apiKey.ExpiresAt != nil && apiKey.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, http.StatusUnauthorized, "API key has expired", nil)
+		return uuid.Nil, false
+	}
+	if !apiKey.HasScope(scope) {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("API key is missing the %s scope", scope), nil)
+		return uuid.Nil, false
+	}
+
+	if err := cfg.db.TouchAPIKey(r.Context(), apiKey.ID); err != nil {
+		log.Println("authenticateAPIKey() unable to record key use", err)
+	}
+	return apiKey.UserID, true
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// chapterLine matches description lines like "00:00 Intro" or "1:02:03 - Q&A"
+var chapterLine = regexp.MustCompile(`^\s*(?:(\d{1,2}):)?(\d{1,2}):(\d{2})\s*(?:[-–|:]\s*)?(\S.*?)\s*$`)
+
+// parseChapters extracts "00:00 Intro" style timestamps from a video
+// description. Like the common convention, the list only counts when it has
+// at least two entries and starts at zero, so a stray time in prose is ignored.
+func parseChapters(description string) []database.CreateChapterParams {
+	chapters := []database.CreateChapterParams{}
+	for _, line := range strings.Split(description, "\n") {
+		match := chapterLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		hours, _ := strconv.Atoi(match[1])
+		minutes, _ := strconv.Atoi(match[2])
+		seconds, _ := strconv.Atoi(match[3])
+		if seconds > 59 || (match[1] != "" && minutes > 59) {
+			continue
+		}
+		chapters = append(chapters, database.CreateChapterParams{
+			StartTime: float64(hours*3600 + minutes*60 + seconds),
+			Title:     match[4],
+		})
+	}
+	if len(chapters) < 2 || chapters[0].StartTime != 0 {
+		return nil
+	}
+	return chapters
+}
+
+// validateChapter checks a chapter against the other chapters of its video and
+// against the probed duration, when the video has been uploaded already.
+func validateChapter(chapter database.CreateChapterParams, existing []database.Chapter, video database.Video, ignoreID uuid.UUID) error {
+	if strings.TrimSpace(chapter.Title) == "" {
+		return errors.New("chapter title is required")
+	}
+	if chapter.StartTime < 0 {
+		return errors.New("chapter start time can't be negative")
+	}
+	if video.Duration != nil && chapter.StartTime >= *video.Duration {
+		return fmt.Errorf("chapter starts at %.3fs but the video is only %.3fs long", chapter.StartTime, *video.Duration)
+	}
+	for _, other := range existing {
+		if other.ID != ignoreID && other.StartTime == chapter.StartTime {
+			return fmt.Errorf("a chapter already starts at %.3fs", chapter.StartTime)
+		}
+	}
+	return nil
+}
+
+func escapeFFMetadata(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n")
+	return replacer.Replace(value)
+}
+
+// writeFFMetadata writes the title and chapters of a video into an ffmetadata
+// file that ffmpeg can merge into the container while processing the upload.
+// Chapters starting past the end of the video are dropped.
+func writeFFMetadata(filePath string, video database.Video, chapters []database.Chapter, duration float64) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(";FFMETADATA1\n")
+	sb.WriteString(fmt.Sprintf("title=%s\n", escapeFFMetadata(video.Title)))
+	for i, chapter := range chapters {
+		if chapter.StartTime >= duration {
+			break
+		}
+		end := duration
+		if i+1 < len(chapters) && chapters[i+1].StartTime < duration {
+			end = chapters[i+1].StartTime
+		}
+		sb.WriteString("[CHAPTER]\nTIMEBASE=1/1000\n")
+		sb.WriteString(fmt.Sprintf("START=%d\nEND=%d\n", int64(chapter.StartTime*1000), int64(end*1000)))
+		sb.WriteString(fmt.Sprintf("title=%s\n", escapeFFMetadata(chapter.Title)))
+	}
+	metadataPath := fmt.Sprintf("%s.ffmetadata", filePath)
+	err := os.WriteFile(metadataPath, []byte(sb.String()), 0600)
+	if err != nil {
+		return "", err
+	}
+	return metadataPath, nil
+}
+
+// createChaptersFromDescription stores the chapters found in the description
+// of a video that doesn't have any chapters yet.
+func (cfg *apiConfig) createChaptersFromDescription(ctx context.Context, video database.Video) ([]database.Chapter, error) {
+	chapters, err := cfg.db.GetChapters(ctx, video.ID)
+	if err != nil || len(chapters) > 0 {
+		return chapters, err
+	}
+	for _, params := range parseChapters(video.Description) {
+		if video.Duration != nil && params.StartTime >= *video.Duration {
+			continue
+		}
+		params.VideoID = video.ID
+		chapter, err := cfg.db.CreateChapter(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, chapter)
+	}
+	return chapters, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc/oidctest"
+	"github.com/google/uuid"
+)
+
+// runCommand runs a maintenance command given on the command line instead of
+// starting the server.
+func runCommand(ctx context.Context, db database.Client, args []string) error {
+	switch args[0] {
+	case "rotate-keys":
+		return cmdRotateKeys(ctx, db, args[1:])
+	case "mock-idp":
+		return cmdMockIdP(args[1:])
+	case "set-role":
+		return cmdSetRole(ctx, db, args[1:])
+	case "migrate":
+		return cmdMigrate(ctx, db, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func cmdRotateKeys(ctx context.Context, db database.Client, args []string) error {
+	defaultAlg := os.Getenv("JWT_SIGNING_ALG")
+	if defaultAlg == "" || defaultAlg == auth.AlgHS256 {
+		defaultAlg = auth.AlgEdDSA
+	}
+	defaultOverlap := 15 * time.Minute
+	if value := os.Getenv("ACCESS_TOKEN_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid ACCESS_TOKEN_TTL: %w", err)
+		}
+		defaultOverlap = ttl
+	}
+
+	flags := flag.NewFlagSet("rotate-keys", flag.ContinueOnError)
+	alg := flags.String("alg", defaultAlg, "algorithm of the new key, RS256 or EdDSA")
+	overlap := flags.Duration("overlap", defaultOverlap, "how long the replaced keys keep verifying tokens")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	key, err := rotateJWTKeys(ctx, db, *alg, *overlap)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created %s signing key %s, previous keys retire at %s\n",
+		key.Algorithm, key.ID, time.Now().Add(*overlap).Format(time.RFC3339))
+	fmt.Printf("running servers start signing with it within %s\n", keyRingReloadInterval)
+	return nil
+}
+
+// cmdSetRole changes the role of a user, which is how the first admin is made.
+func cmdSetRole(ctx context.Context, db database.Client, args []string) error {
+	flags := flag.NewFlagSet("set-role", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the user")
+	role := flags.String("role", auth.RoleAdmin, "new role, one of "+strings.Join(auth.Roles, ", "))
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !auth.ValidRole(*role) {
+		return fmt.Errorf("unknown role %q", *role)
+	}
+
+	user, err := db.GetUserByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+	if user.ID == uuid.Nil {
+		return fmt.Errorf("no user with email %q", *email)
+	}
+	if _, err := db.SetUserRole(ctx, user.ID, *role); err != nil {
+		return err
+	}
+	fmt.Printf("%s now has the %s role\n", user.Email, *role)
+	return nil
+}
+
+// cmdMigrate shows or moves the schema version: migrate status, migrate up
+// [-to version] or migrate down [-steps n].
+func cmdMigrate(ctx context.Context, db database.Client, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate status|up|down")
+	}
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	switch args[0] {
+	case "status":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		statuses, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	case "up":
+		to := flags.Int("to", 0, "version to migrate up to, 0 for the latest")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		applied, err := db.MigrateUp(ctx, *to)
+		for _, migration := range applied {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("nothing to apply")
+		}
+		return err
+	case "down":
+		steps := flags.Int("steps", 1, "number of migrations to revert")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *steps < 1 {
+			return fmt.Errorf("-steps must be at least 1")
+		}
+		reverted, err := db.MigrateDown(ctx, *steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Println("nothing to revert")
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown migrate command %q, use status, up or down", args[0])
+	}
+}
+
+// cmdMockIdP runs a local OpenID provider that logs in anyone, for trying out
+// single sign-on during development.
+func cmdMockIdP(args []string) error {
+	flags := flag.NewFlagSet("mock-idp", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:9999", "address to listen on")
+	clientID := flags.String("client-id", "tubely", "client ID to accept")
+	clientSecret := flags.String("client-secret", "tubely-secret", "client secret to accept")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	issuer := "http://" + *addr
+	provider, err := oidctest.New(issuer, *clientID, *clientSecret)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("mock identity provider listening at %s, set OIDC_ISSUER=%s\n", issuer, issuer)
+	return http.ListenAndServe(*addr, provider)
+}
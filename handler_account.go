@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// accountUser authenticates the caller and gets their user. It writes the
+// error response itself and reports whether to go on.
+func (cfg *apiConfig) accountUser(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeAdmin)
+	if !ok {
+		return database.User{}, false
+	}
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.User{}, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "User no longer exists", nil)
+		return database.User{}, false
+	}
+	return *user, true
+}
+
+// checkCurrentPassword makes sure the caller knows the password of the user
+// before a sensitive change, so a stolen access token isn't enough. Wrong
+// passwords count as failed logins and are audited under action.
+func (cfg *apiConfig) checkCurrentPassword(w http.ResponseWriter, r *http.Request, user database.User, password, action string) bool {
+	if !cfg.checkLoginThrottle(w, r, user.Email) {
+		return false
+	}
+	if password == "" {
+		respondWithError(w, http.StatusUnauthorized, "Current password is required", nil)
+		return false
+	}
+	if auth.CheckPasswordHash(password, user.Password) != nil {
+		cfg.recordLoginFailure(r, user.Email)
+		cfg.audit(r, user.ID, action, auditTargetUser, user.ID.String(), database.AuditOutcomeFailure, "wrong current password")
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password", nil)
+		return false
+	}
+	return true
+}
+
+// handlerAccountPasswordUpdate changes the password of the caller and logs
+// out their other sessions. The session of the refresh token in the request,
+// if any, is kept.
+func (cfg *apiConfig) handlerAccountPasswordUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+		RefreshToken    string `json:"refresh_token"`
+	}
+
+	user, ok := cfg.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.NewPassword == "" {
+		respondWithError(w, http.StatusBadRequest, "New password is required", nil)
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, params.NewPassword) {
+		return
+	}
+	if !cfg.checkCurrentPassword(w, r, user, params.CurrentPassword, auditPasswordChange) {
+		return
+	}
+
+	keepSessionID := uuid.Nil
+	if params.RefreshToken != "" {
+		rt, err := cfg.db.GetRefreshToken(r.Context(), params.RefreshToken)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+			return
+		}
+		if rt.UserID == user.ID && rt.RevokedAt == nil {
+			keepSessionID = rt.FamilyID
+		}
+	}
+
+	hashedPassword, err := auth.HashPassword(params.NewPassword, cfg.argon2Params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+	err = cfg.db.UpdateUserPassword(r.Context(), user.ID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+	err = cfg.db.RevokeOtherSessions(r.Context(), user.ID, keepSessionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	cfg.recordLoginSuccess(r.Context(), user.Email)
+	cfg.auditSuccess(r, user.ID, auditPasswordChange, auditTargetUser, user.ID.String())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAccountEmailUpdate changes the email of the caller and sends a link
+// to verify the new address.
+func (cfg *apiConfig) handlerAccountEmailUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	user, ok := cfg.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Email = strings.TrimSpace(params.Email)
+	if err := validateEmail(params.Email); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
+		return
+	}
+	if params.Email == user.Email {
+		respondWithError(w, http.StatusBadRequest, "That's already your email address", nil)
+		return
+	}
+	if !cfg.checkCurrentPassword(w, r, user, params.Password, auditEmailChange) {
+		return
+	}
+
+	existing, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check email", err)
+		return
+	}
+	if existing.ID != uuid.Nil {
+		respondWithError(w, http.StatusConflict, "Email is already in use", nil)
+		return
+	}
+
+	err = cfg.db.UpdateUserEmail(r.Context(), user.ID, params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update email", err)
+		return
+	}
+	cfg.recordLoginSuccess(r.Context(), user.Email)
+	cfg.audit(r, user.ID, auditEmailChange, auditTargetUser, user.ID.String(), database.AuditOutcomeSuccess, "from "+user.Email+" to "+params.Email)
+
+	updated, err := cfg.db.GetUser(r.Context(), user.ID)
+	if err != nil || updated == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	err = cfg.sendVerificationEmail(r.Context(), *updated)
+	if err != nil {
+		log.Println("handlerAccountEmailUpdate() unable to send verification email", err)
+	}
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// handlerAccountDelete deletes the caller with their videos, media files,
+// sessions and credentials. When a previous attempt didn't finish, calling it
+// again picks up where it stopped and needs no password, the first call
+// already cleared it.
+func (cfg *apiConfig) handlerAccountDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	user, ok := cfg.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	deletion, err := cfg.db.GetAccountDeletion(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get account deletion", err)
+		return
+	}
+	if deletion.UserID == uuid.Nil {
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err := decoder.Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+		if !cfg.checkCurrentPassword(w, r, user, params.Password, auditAccountDelete) {
+			return
+		}
+		blocking, err := cfg.soleAdminWorkspaces(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check workspaces", err)
+			return
+		}
+		if len(blocking) > 0 {
+			respondWithError(w, http.StatusConflict, "Make someone else an admin of these workspaces first: "+strings.Join(blocking, ", "), nil)
+			return
+		}
+
+		deletion, err = cfg.db.StartAccountDeletion(r.Context(), user.ID, user.Email)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't start account deletion", err)
+			return
+		}
+		cfg.audit(r, user.ID, auditAccountDelete, auditTargetUser, user.ID.String(), database.AuditOutcomeSuccess, user.Email)
+	}
+
+	// the deletion goes on even if the client hangs up
+	err = cfg.runAccountDeletion(context.WithoutCancel(r.Context()), deletion)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't finish deleting the account, it will be retried", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
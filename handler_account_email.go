@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerEmailVerificationSend emails a new verification link to the user.
+func (cfg *apiConfig) handlerEmailVerificationSend(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeAdmin)
+	if !ok {
+		return
+	}
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		respondWithError(w, http.StatusConflict, "Email is already verified", nil)
+		return
+	}
+
+	err = cfg.sendVerificationEmail(r.Context(), *user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerEmailVerify consumes the token from a verification link. It needs no
+// login, holding the link proves the address.
+func (cfg *apiConfig) handlerEmailVerify(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	token, err := cfg.consumeUserToken(r.Context(), params.Token, purposeVerifyEmail)
+	if errors.Is(err, errInvalidUserToken) {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	// the user may have changed their email since the link was sent
+	verified, err := cfg.db.SetEmailVerified(r.Context(), token.UserID, token.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+	if !verified {
+		respondWithError(w, http.StatusBadRequest, errInvalidUserToken.Error(), nil)
+		return
+	}
+	cfg.auditSuccess(r, token.UserID, auditEmailVerify, auditTargetUser, token.Email)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerPasswordResetRequest emails a reset link. It answers the same
+// whether or not the email has an account, and sends the email in the
+// background so the response time doesn't give it away either.
+func (cfg *apiConfig) handlerPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID != uuid.Nil {
+		cfg.backgroundMail.Add(1)
+		go func() {
+			defer cfg.backgroundMail.Done()
+			if err := cfg.sendPasswordResetEmail(context.Background(), user); err != nil {
+				log.Println("handlerPasswordResetRequest() unable to send reset email", err)
+			}
+		}()
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerPasswordReset sets a new password from a reset link and logs the
+// user out everywhere.
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Password is required", nil)
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, params.Password) {
+		return
+	}
+
+	token, err := cfg.consumeUserToken(r.Context(), params.Token, purposeResetPassword)
+	if errors.Is(err, errInvalidUserToken) {
+		cfg.audit(r, uuid.Nil, auditPasswordReset, auditTargetUser, "", database.AuditOutcomeFailure, err.Error())
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password, cfg.argon2Params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+	err = cfg.db.UpdateUserPassword(r.Context(), token.UserID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	err = cfg.db.RevokeAllSessions(r.Context(), token.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	// the reset link reached the user, so the address is theirs
+	if _, err := cfg.db.SetEmailVerified(r.Context(), token.UserID, token.Email); err != nil {
+		log.Println("handlerPasswordReset() unable to mark email verified", err)
+	}
+	cfg.auditSuccess(r, token.UserID, auditPasswordReset, auditTargetUser, token.Email)
+
+	w.WriteHeader(http.StatusNoContent)
+}
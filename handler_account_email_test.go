@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+// recordingMailer keeps the messages it's given. When release is set, Send
+// waits for it to be closed first.
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+	release  chan struct{}
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	if m.release != nil {
+		<-m.release
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// resetToken returns the token from the last reset link that was sent.
+func (m *recordingMailer) resetToken(t *testing.T) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.messages) == 0 {
+		t.Fatal("no email was sent")
+	}
+	body := m.messages[len(m.messages)-1].Body
+	_, fragment, ok := strings.Cut(body, "/app/#")
+	if !ok {
+		t.Fatalf("no link in %q", body)
+	}
+	fragment, _, _ = strings.Cut(fragment, "\n")
+	values, err := url.ParseQuery(fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values.Get(purposeResetPassword)
+}
+
+func newPasswordResetTest(t *testing.T) (*uploadTest, *recordingMailer) {
+	t.Helper()
+	ut := newUploadTest(t)
+	mail := &recordingMailer{}
+	ut.cfg.mailer = mail
+	ut.cfg.argon2Params = auth.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	t.Cleanup(ut.cfg.backgroundMail.Wait)
+	ut.mux.HandleFunc("POST /api/password_reset", ut.cfg.handlerPasswordResetRequest)
+	ut.mux.HandleFunc("POST /api/password_reset/confirm", ut.cfg.handlerPasswordReset)
+	return ut, mail
+}
+
+func (ut *uploadTest) post(t *testing.T, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	ut.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func (ut *uploadTest) requestPasswordReset(t *testing.T, mail *recordingMailer, email string) string {
+	t.Helper()
+	if rec := ut.post(t, "/api/password_reset", `{"email":"`+email+`"}`); rec.Code != http.StatusAccepted {
+		t.Fatalf("requesting a reset returned %d: %s", rec.Code, rec.Body)
+	}
+	ut.cfg.backgroundMail.Wait()
+	return mail.resetToken(t)
+}
+
+func TestPasswordReset(t *testing.T) {
+	ut, mail := newPasswordResetTest(t)
+	ut.createUser(t, "owner@example.com")
+
+	token := ut.requestPasswordReset(t, mail, "owner@example.com")
+	rec := ut.post(t, "/api/password_reset/confirm", `{"token":"`+token+`","password":"a new password"}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("resetting the password returned %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPasswordResetAfterEmailChange(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(t *testing.T, ut *uploadTest, userID uuid.UUID)
+	}{
+		{
+			name: "email updated",
+			change: func(t *testing.T, ut *uploadTest, userID uuid.UUID) {
+				if err := ut.cfg.db.UpdateUserEmail(context.Background(), userID, "new@example.com"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			// a token that outlived the change, as from before tokens were
+			// deleted with it
+			name: "token left behind",
+			change: func(t *testing.T, ut *uploadTest, userID uuid.UUID) {
+				db, err := sql.Open("sqlite3", filepath.Join(ut.cfg.assetsRoot, "tubely.db"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer db.Close()
+				if _, err := db.Exec(`UPDATE users SET email = 'new@example.com' WHERE id = ?`, userID.String()); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ut, mail := newPasswordResetTest(t)
+			userID, _ := ut.createUser(t, "owner@example.com")
+
+			token := ut.requestPasswordReset(t, mail, "owner@example.com")
+			tc.change(t, ut, userID)
+			rec := ut.post(t, "/api/password_reset/confirm", `{"token":"`+token+`","password":"a new password"}`)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("a link sent to the old address returned %d, want 400", rec.Code)
+			}
+		})
+	}
+}
+
+func TestPasswordResetRequestDoesntWaitForTheEmail(t *testing.T) {
+	ut, mail := newPasswordResetTest(t)
+	mail.release = make(chan struct{})
+	ut.createUser(t, "owner@example.com")
+
+	done := make(chan int, 1)
+	go func() {
+		done <- ut.post(t, "/api/password_reset", `{"email":"owner@example.com"}`).Code
+	}()
+	select {
+	case code := <-done:
+		if code != http.StatusAccepted {
+			t.Errorf("got %d, want 202", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the response waited for the email to be sent")
+	}
+	close(mail.release)
+	ut.cfg.backgroundMail.Wait()
+	mail.resetToken(t)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerAdminUsersList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.authorize(w, r, auth.ScopeAdmin, auth.PermissionListUsers); !ok {
+		return
+	}
+
+	users, err := cfg.db.GetUsers(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+func (cfg *apiConfig) handlerAdminUserRoleUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	admin, ok := cfg.authorize(w, r, auth.ScopeAdmin, auth.PermissionManageRoles)
+	if !ok {
+		return
+	}
+	// so the last admin can't lock everyone out by accident
+	if admin.ID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't change your own role", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !auth.ValidRole(params.Role) {
+		respondWithError(w, http.StatusBadRequest, "Unknown role", nil)
+		return
+	}
+
+	updated, err := cfg.db.SetUserRole(r.Context(), userID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update role", err)
+		return
+	}
+	if !updated {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", nil)
+		return
+	}
+	cfg.audit(r, admin.ID, auditAdminRoleChange, auditTargetUser, userID.String(), database.AuditOutcomeSuccess, params.Role)
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// handlerAdminVideosList lists the videos of every user, or of the one given
+// by the user_id query parameter.
+func (cfg *apiConfig) handlerAdminVideosList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.authorize(w, r, auth.ScopeAdmin, auth.PermissionReadAnyVideo); !ok {
+		return
+	}
+
+	var videos []database.Video
+	var err error
+	if value := r.URL.Query().Get("user_id"); value != "" {
+		userID, parseErr := uuid.Parse(value)
+		if parseErr != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user ID", parseErr)
+			return
+		}
+		videos, err = cfg.db.GetVideos(r.Context(), userID)
+	} else {
+		videos, err = cfg.db.GetAllVideos(r.Context())
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	videos, err = cfg.viewableVideos(r.Context(), videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, videos)
+}
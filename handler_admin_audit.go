@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAuditEventsLimit = 100
+	maxAuditEventsLimit     = 1000
+)
+
+// auditEventFilter reads the filter from the query parameters actor_id,
+// action, target_type, target_id, outcome, since and until, the last two in
+// RFC 3339. It writes the error response itself and reports whether to go on.
+func auditEventFilter(w http.ResponseWriter, r *http.Request) (database.AuditEventFilter, bool) {
+	query := r.URL.Query()
+	filter := database.AuditEventFilter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+		Outcome:    query.Get("outcome"),
+	}
+	if value := query.Get("actor_id"); value != "" {
+		actorID, err := uuid.Parse(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid actor ID", err)
+			return database.AuditEventFilter{}, false
+		}
+		filter.ActorID = &actorID
+	}
+	for name, field := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s must be an RFC 3339 time", name), err)
+			return database.AuditEventFilter{}, false
+		}
+		*field = &t
+	}
+	return filter, true
+}
+
+// handlerAdminAuditEvents lists the newest audit events matching the filter,
+// at most limit of them.
+func (cfg *apiConfig) handlerAdminAuditEvents(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.authorize(w, r, auth.ScopeAdmin, auth.PermissionReadAuditLog); !ok {
+		return
+	}
+	filter, ok := auditEventFilter(w, r)
+	if !ok {
+		return
+	}
+	limit := defaultAuditEventsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxAuditEventsLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxAuditEventsLimit), err)
+			return
+		}
+		limit = n
+	}
+
+	events, err := cfg.db.GetAuditEvents(r.Context(), filter, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve audit events", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, events)
+}
+
+// handlerAdminAuditExport streams every audit event matching the filter as
+// JSON Lines, oldest first. Exports are audited too.
+func (cfg *apiConfig) handlerAdminAuditExport(w http.ResponseWriter, r *http.Request) {
+	admin, ok := cfg.authorize(w, r, auth.ScopeAdmin, auth.PermissionReadAuditLog)
+	if !ok {
+		return
+	}
+	filter, ok := auditEventFilter(w, r)
+	if !ok {
+		return
+	}
+	cfg.audit(r, admin.ID, auditAdminAuditExport, auditTargetAuditLog, "", database.AuditOutcomeSuccess, r.URL.RawQuery)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-events-%s.jsonl"`, time.Now().UTC().Format("20060102T150405Z")))
+	w.WriteHeader(http.StatusOK)
+
+	// json.Encoder ends every value with a newline
+	encoder := json.NewEncoder(w)
+	err := cfg.db.EachAuditEvent(r.Context(), filter, func(event database.AuditEvent) error {
+		return encoder.Encode(event)
+	})
+	if err != nil {
+		// the status is already sent, all that's left is cutting the export short
+		log.Println("handlerAdminAuditExport() unable to export audit events", err)
+	}
+}
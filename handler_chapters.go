@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerChaptersGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, auth.ScopeVideosRead, videoView)
+	if !ok {
+		return
+	}
+
+	chapters, err := cfg.db.GetChapters(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chapters", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chapters)
+}
+
+// authorizedVideo loads the video from the path and makes sure the caller,
+// authenticated for scope, may take action on it. Anonymous callers may view
+// videos that aren't private. It writes the error response itself and reports
+// whether to go on.
+func (cfg *apiConfig) authorizedVideo(w http.ResponseWriter, r *http.Request, scope string, action videoAction) (database.Video, bool) {
+	video, _, ok := cfg.authorizedVideoActor(w, r, scope, action)
+	return video, ok
+}
+
+// authorizedVideoActor is authorizedVideo that also returns the caller, for
+// handlers that audit what they did. The caller is uuid.Nil when anonymous.
+func (cfg *apiConfig) authorizedVideoActor(w http.ResponseWriter, r *http.Request, scope string, action videoAction) (database.Video, uuid.UUID, bool) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, uuid.Nil, false
+	}
+
+	var userID uuid.UUID
+	var ok bool
+	if action == videoView {
+		userID, ok = cfg.authenticateOptional(w, r, scope)
+	} else {
+		userID, ok = cfg.authenticate(w, r, scope)
+	}
+	if !ok {
+		return database.Video{}, uuid.Nil, false
+	}
+
+	video, err := cfg.db.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return database.Video{}, uuid.Nil, false
+	}
+	if !cfg.checkVideoAccess(r.Context(), w, userID, video, action) {
+		return database.Video{}, uuid.Nil, false
+	}
+	return video, userID, true
+}
+
+// videoChapter loads the chapter from the path and makes sure it belongs to video.
+func (cfg *apiConfig) videoChapter(w http.ResponseWriter, r *http.Request, video database.Video) (database.Chapter, bool) {
+	chapterID, err := uuid.Parse(r.PathValue("chapterID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chapter ID", err)
+		return database.Chapter{}, false
+	}
+	chapter, err := cfg.db.GetChapter(r.Context(), chapterID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapter", err)
+		return database.Chapter{}, false
+	}
+	if chapter.ID == uuid.Nil || chapter.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chapter", nil)
+		return database.Chapter{}, false
+	}
+	return chapter, true
+}
+
+func (cfg *apiConfig) handlerChapterCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		StartTime float64 `json:"start_time"`
+		Title     string  `json:"title"`
+	}
+
+	video, ok := cfg.authorizedVideo(w, r, auth.ScopeVideosWrite, videoEdit)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	existing, err := cfg.db.GetChapters(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chapters", err)
+		return
+	}
+	chapterParams := database.CreateChapterParams{
+		VideoID:   video.ID,
+		StartTime: params.StartTime,
+		Title:     params.Title,
+	}
+	err = validateChapter(chapterParams, existing, video, uuid.Nil)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	chapter, err := cfg.db.CreateChapter(r.Context(), chapterParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chapter", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, chapter)
+}
+
+func (cfg *apiConfig) handlerChapterUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		StartTime *float64 `json:"start_time"`
+		Title     *string  `json:"title"`
+	}
+
+	video, ok := cfg.authorizedVideo(w, r, auth.ScopeVideosWrite, videoEdit)
+	if !ok {
+		return
+	}
+	chapter, ok := cfg.videoChapter(w, r, video)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.StartTime != nil {
+		chapter.StartTime = *params.StartTime
+	}
+	if params.Title != nil {
+		chapter.Title = *params.Title
+	}
+
+	existing, err := cfg.db.GetChapters(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chapters", err)
+		return
+	}
+	err = validateChapter(chapter.CreateChapterParams, existing, video, chapter.ID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.db.UpdateChapter(r.Context(), chapter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chapter", err)
+		return
+	}
+	chapter, err = cfg.db.GetChapter(r.Context(), chapter.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapter", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chapter)
+}
+
+func (cfg *apiConfig) handlerChapterDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, auth.ScopeVideosWrite, videoEdit)
+	if !ok {
+		return
+	}
+	chapter, ok := cfg.videoChapter(w, r, video)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.DeleteChapter(r.Context(), chapter.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chapter", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,23 +2,31 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Password string `json:"password"`
 		Email    string `json:"email"`
+		// DeviceLabel is an optional name for the session, like "Work laptop"
+		DeviceLabel string `json:"device_label"`
 	}
 	type response struct {
 		database.User
 		Token        string `json:"token"`
 		RefreshToken string `json:"refresh_token"`
 	}
+	type challengeResponse struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
+	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -28,43 +36,63 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByEmail(params.Email)
+	// blocked logins are refused before any hashing work
+	if !cfg.checkLoginThrottle(w, r, params.Email) {
+		cfg.audit(r, uuid.Nil, auditLogin, auditTargetUser, params.Email, database.AuditOutcomeDenied, "too many failed logins")
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
+	// accounts without a password only log in through single sign-on
+	if user.ID == uuid.Nil || user.Password == "" {
+		cfg.checkDummyPassword(params.Password)
+		cfg.recordLoginFailure(r, params.Email)
+		cfg.audit(r, uuid.Nil, auditLogin, auditTargetUser, params.Email, database.AuditOutcomeFailure, "unknown email or no password")
+		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", nil)
+		return
+	}
 	err = auth.CheckPasswordHash(params.Password, user.Password)
 	if err != nil {
+		cfg.recordLoginFailure(r, params.Email)
+		cfg.audit(r, user.ID, auditLogin, auditTargetUser, params.Email, database.AuditOutcomeFailure, "wrong password")
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
+	cfg.recordLoginSuccess(r.Context(), params.Email)
+	cfg.upgradePasswordHash(r.Context(), user.ID, params.Password, user.Password)
 
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour*24*30,
-	)
+	// with two-factor authentication on, the password only earns a challenge
+	// token for handlerLoginTwoFactor
+	cred, err := cfg.db.GetTOTPCredential(r.Context(), user.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
 		return
 	}
-
-	refreshToken, err := auth.MakeRefreshToken()
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+	if cred.EnabledAt != nil {
+		challengeToken, err := cfg.keyRing().MakeToken(auth.TokenTypeTwoFactor, user.ID, twoFactorChallengeTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create challenge token", err)
+			return
+		}
+		cfg.audit(r, user.ID, auditLogin, auditTargetUser, params.Email, database.AuditOutcomeSuccess, "second factor required")
+		respondWithJSON(w, http.StatusOK, challengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
+		})
 		return
 	}
 
-	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
-		UserID:    user.ID,
-		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
-	})
+	accessToken, refreshToken, err := cfg.startSession(r, user.ID, params.DeviceLabel)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start session", err)
 		return
 	}
+	cfg.auditSuccess(r, user.ID, auditLogin, auditTargetUser, params.Email)
 
 	respondWithJSON(w, http.StatusOK, response{
 		User:         user,
@@ -72,3 +100,32 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		RefreshToken: refreshToken,
 	})
 }
+
+// startSession issues the access token and the first refresh token of a new
+// login.
+func (cfg *apiConfig) startSession(r *http.Request, userID uuid.UUID, deviceLabel string) (accessToken, refreshToken string, err error) {
+	accessToken, err = cfg.keyRing().MakeJWT(userID, cfg.accessTokenTTL)
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't create access JWT: %w", err)
+	}
+
+	refreshToken, err = auth.MakeRefreshToken()
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't create refresh token: %w", err)
+	}
+
+	_, err = cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		UserID:           userID,
+		Token:            refreshToken,
+		ExpiresAt:        time.Now().UTC().Add(refreshTokenLifetime),
+		FamilyID:         uuid.New(),
+		DeviceLabel:      deviceLabel,
+		UserAgent:        r.UserAgent(),
+		IP:               clientIP(r),
+		SessionStartedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't save refresh token: %w", err)
+	}
+	return accessToken, refreshToken, nil
+}
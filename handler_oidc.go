@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/google/uuid"
+)
+
+// oidcLoginLifetime is how long the user has to log in at the provider
+const oidcLoginLifetime = 10 * time.Minute
+
+// oidcStateCookie ties a login to the browser that started it. Without it a
+// callback URL from someone else's login would log the browser into their
+// account.
+const oidcStateCookie = "tubely_oidc_state"
+
+var (
+	errIdentityNoEmail    = errors.New("the identity provider didn't share an email address")
+	errIdentityEmailTaken = errors.New("an account with this email already exists, and the identity provider hasn't verified the address")
+)
+
+// handlerOIDCLogin starts a single sign-on login by sending the browser to
+// the identity provider.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on isn't configured", nil)
+		return
+	}
+
+	if err := cfg.db.DeleteExpiredOIDCLogins(r.Context()); err != nil {
+		log.Println("handlerOIDCLogin() unable to delete expired logins", err)
+	}
+
+	login := database.OIDCLogin{
+		DeviceLabel: r.URL.Query().Get("device_label"),
+		CreatedAt:   time.Now().UTC(),
+		ExpiresAt:   time.Now().UTC().Add(oidcLoginLifetime),
+	}
+	var err error
+	for _, value := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		*value, err = oidc.RandomString()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+			return
+		}
+	}
+
+	authURL, err := cfg.oidc.AuthCodeURL(r.Context(), login.State, login.Nonce, oidc.S256Challenge(login.CodeVerifier))
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't reach the identity provider", err)
+		return
+	}
+	if err := cfg.db.CreateOIDCLogin(r.Context(), login); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+
+	cfg.setOIDCStateCookie(w, stateHash(login.State), int(oidcLoginLifetime.Seconds()))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// stateHash is what the state cookie holds, so the state itself stays
+// between the server and the provider.
+func stateHash(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// setOIDCStateCookie sets the state cookie, or clears it with a negative maxAge.
+func (cfg *apiConfig) setOIDCStateCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.publicURL, "https://"),
+		// Lax still sends it on the top level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// handlerOIDCCallback finishes a single sign-on login and hands the tokens to
+// the web app in the URL fragment, which isn't sent to any server.
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on isn't configured", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		respondWithError(w, http.StatusUnauthorized, fmt.Sprintf("Login failed: %s %s", providerErr, query.Get("error_description")), nil)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	cfg.setOIDCStateCookie(w, "", -1)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(stateHash(query.Get("state")))) != 1 {
+		respondWithError(w, http.StatusBadRequest, "This login wasn't started in this browser, please try again", err)
+		return
+	}
+
+	login, err := cfg.db.TakeOIDCLogin(r.Context(), query.Get("state"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get login", err)
+		return
+	}
+	if login == nil || login.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "Unknown or expired login, please try again", nil)
+		return
+	}
+
+	tokens, err := cfg.oidc.Exchange(r.Context(), query.Get("code"), login.CodeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't complete login with the identity provider", err)
+		return
+	}
+	claims, err := cfg.oidc.VerifyIDToken(r.Context(), tokens.IDToken, login.Nonce)
+	if err != nil {
+		cfg.audit(r, uuid.Nil, auditLoginOIDC, auditTargetUser, "", database.AuditOutcomeFailure, "invalid ID token")
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate ID token", err)
+		return
+	}
+
+	user, err := cfg.userForIdentity(r.Context(), claims)
+	if errors.Is(err, errIdentityNoEmail) || errors.Is(err, errIdentityEmailTaken) {
+		cfg.audit(r, uuid.Nil, auditLoginOIDC, auditTargetUser, claims.Email, database.AuditOutcomeFailure, err.Error())
+		respondWithError(w, http.StatusConflict, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	// the identity provider stands in for the password, not the second
+	// factor, so accounts with TOTP get the same challenge as handlerLogin
+	cred, err := cfg.db.GetTOTPCredential(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if cred.EnabledAt != nil {
+		challengeToken, err := cfg.keyRing().MakeToken(auth.TokenTypeTwoFactor, user.ID, twoFactorChallengeTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create challenge token", err)
+			return
+		}
+		cfg.audit(r, user.ID, auditLoginOIDC, auditTargetUser, user.Email, database.AuditOutcomeSuccess, "second factor required")
+
+		fragment := url.Values{}
+		fragment.Set("two_factor_required", "true")
+		fragment.Set("challenge_token", challengeToken)
+		fragment.Set("device_label", login.DeviceLabel)
+		http.Redirect(w, r, "/app/#"+fragment.Encode(), http.StatusSeeOther)
+		return
+	}
+
+	accessToken, refreshToken, err := cfg.startSession(r, user.ID, login.DeviceLabel)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start session", err)
+		return
+	}
+	cfg.auditSuccess(r, user.ID, auditLoginOIDC, auditTargetUser, user.Email)
+
+	fragment := url.Values{}
+	fragment.Set("token", accessToken)
+	fragment.Set("refresh_token", refreshToken)
+	http.Redirect(w, r, "/app/#"+fragment.Encode(), http.StatusSeeOther)
+}
+
+// userForIdentity finds the user linked to the ID token's subject. The first
+// time a subject logs in it's linked to the account with the same email, if
+// the provider verified that email, or a new account is created for it.
+func (cfg *apiConfig) userForIdentity(ctx context.Context, claims oidc.Claims) (*database.User, error) {
+	identity, err := cfg.db.GetUserIdentity(ctx, cfg.oidc.Issuer, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if identity.ID != uuid.Nil {
+		user, err := cfg.db.GetUser(ctx, identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, fmt.Errorf("user %s of identity %s no longer exists", identity.UserID, identity.ID)
+		}
+		return user, nil
+	}
+
+	if claims.Email == "" {
+		return nil, errIdentityNoEmail
+	}
+	existing, err := cfg.db.GetUserByEmail(ctx, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+	user := &existing
+	if existing.ID == uuid.Nil {
+		// an empty password hash never matches, so the account can only
+		// log in through the identity provider
+		user, err = cfg.db.CreateUser(ctx, database.CreateUserParams{
+			Email:    claims.Email,
+			Password: "",
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if !claims.EmailVerified {
+		return nil, errIdentityEmailTaken
+	}
+
+	if claims.EmailVerified && user.EmailVerifiedAt == nil {
+		if _, err := cfg.db.SetEmailVerified(ctx, user.ID, claims.Email); err != nil {
+			log.Println("userForIdentity() unable to mark email verified", err)
+		}
+	}
+
+	_, err = cfg.db.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		UserID:  user.ID,
+		Issuer:  cfg.oidc.Issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
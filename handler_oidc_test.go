@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc/oidctest"
+)
+
+func oidcMux(cfg *apiConfig) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/oidc/login", cfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/callback", cfg.handlerOIDCCallback)
+	return mux
+}
+
+// startOIDCLogin starts a login at the mock provider, which approves straight
+// away. It returns the callback request the browser is redirected to and
+// the cookies the browser got when the login started.
+func startOIDCLogin(t *testing.T, mux *http.ServeMux) (*http.Request, []*http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: got status %d: %s", rec.Code, rec.Body)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil), rec.Result().Cookies()
+}
+
+// oidcLogin goes through a single sign-on login at the mock provider and
+// returns the fragment the callback hands to the web app.
+func oidcLogin(t *testing.T, cfg *apiConfig) url.Values {
+	t.Helper()
+	mux := oidcMux(cfg)
+	callback, cookies := startOIDCLogin(t, mux)
+	for _, cookie := range cookies {
+		callback.AddCookie(cookie)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, callback)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("callback: got status %d: %s", rec.Code, rec.Body)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragment, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fragment
+}
+
+func newOIDCTest(t *testing.T) *uploadTest {
+	t.Helper()
+	ut := newUploadTest(t)
+	server, provider, err := oidctest.NewServer("tubely", "tubely-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	provider.User.Email = "boots@example.com"
+	ut.cfg.oidc = &oidc.Client{
+		Issuer:       provider.Issuer,
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  "http://tubely.example/api/oidc/callback",
+	}
+	return ut
+}
+
+func TestOIDCCallbackStartsSession(t *testing.T) {
+	ut := newOIDCTest(t)
+	ut.createUser(t, "boots@example.com")
+
+	fragment := oidcLogin(t, ut.cfg)
+	if fragment.Get("token") == "" || fragment.Get("refresh_token") == "" {
+		t.Errorf("got fragment %v, want the session tokens", fragment)
+	}
+}
+
+func TestOIDCCallbackAsksForSecondFactor(t *testing.T) {
+	ut := newOIDCTest(t)
+	userID, _ := ut.createUser(t, "boots@example.com")
+	ctx := context.Background()
+	if err := ut.cfg.db.SetPendingTOTP(ctx, userID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ut.cfg.db.EnableTOTP(ctx, userID, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	fragment := oidcLogin(t, ut.cfg)
+	if fragment.Get("token") != "" || fragment.Get("refresh_token") != "" {
+		t.Fatal("a session was started without the second factor")
+	}
+	if fragment.Get("two_factor_required") != "true" {
+		t.Errorf("got fragment %v, want a two-factor challenge", fragment)
+	}
+	userIDFromToken, err := ut.cfg.keyRing().ValidateToken(fragment.Get("challenge_token"), auth.TokenTypeTwoFactor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userIDFromToken != userID {
+		t.Errorf("challenge is for %s, want %s", userIDFromToken, userID)
+	}
+}
+
+func TestOIDCCallbackFromAnotherBrowser(t *testing.T) {
+	ut := newOIDCTest(t)
+	ut.createUser(t, "boots@example.com")
+	mux := oidcMux(ut.cfg)
+
+	// someone else's callback URL, opened without their state cookie
+	callback, _ := startOIDCLogin(t, mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, callback)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback returned %d, want 400", rec.Code)
+	}
+	if location := rec.Header().Get("Location"); location != "" {
+		t.Errorf("the callback redirected to %s", location)
+	}
+
+	// and with a cookie from a login of their own
+	callback, _ = startOIDCLogin(t, mux)
+	_, cookies := startOIDCLogin(t, mux)
+	for _, cookie := range cookies {
+		callback.AddCookie(cookie)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, callback)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback with another login's cookie returned %d, want 400", rec.Code)
+	}
+}
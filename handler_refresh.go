@@ -1,15 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
+// refreshTokenLifetime is how long a refresh token stays valid after it was
+// issued; every refresh issues a new one, so active sessions keep going.
+const refreshTokenLifetime = time.Hour * 24 * 60
+
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,27 +29,105 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	rt, err := cfg.db.GetRefreshToken(r.Context(), refreshToken)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	if rt.Token == "" {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", nil)
+		return
+	}
+	if rt.RevokedAt != nil {
+		if rt.ReplacedBy != nil {
+			// a rotated token showing up again means it was copied, so
+			// nothing descending from that login can be trusted anymore
+			cfg.revokeRefreshTokenFamily(r.Context(), rt)
+			cfg.audit(r, rt.UserID, auditRefreshReuse, auditTargetSession, rt.FamilyID.String(), database.AuditOutcomeDenied, "rotated refresh token used again")
+			respondWithError(w, http.StatusUnauthorized, "Refresh token reuse detected", fmt.Errorf("reuse of rotated refresh token by user %s", rt.UserID))
+			return
+		}
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has been revoked", nil)
+		return
+	}
+	if time.Now().UTC().After(rt.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has expired", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), rt.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user for refresh token", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", nil)
+		return
+	}
+
+	newRefreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+	familyID := rt.FamilyID
+	if familyID == uuid.Nil {
+		familyID = uuid.New()
+	}
+	_, err = cfg.db.RotateRefreshToken(r.Context(), rt.Token, database.CreateRefreshTokenParams{
+		Token:            newRefreshToken,
+		UserID:           user.ID,
+		ExpiresAt:        time.Now().UTC().Add(refreshTokenLifetime),
+		FamilyID:         familyID,
+		DeviceLabel:      rt.DeviceLabel,
+		UserAgent:        r.UserAgent(),
+		IP:               clientIP(r),
+		SessionStartedAt: rt.SessionStartedAt,
+	})
+	if errors.Is(err, database.ErrRefreshTokenRevoked) {
+		// another request rotated the same token first
+		cfg.revokeRefreshTokenFamily(r.Context(), rt)
+		cfg.audit(r, rt.UserID, auditRefreshReuse, auditTargetSession, rt.FamilyID.String(), database.AuditOutcomeDenied, "refresh token rotated concurrently")
+		respondWithError(w, http.StatusUnauthorized, "Refresh token reuse detected", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour,
-	)
+	accessToken, err := cfg.keyRing().MakeJWT(user.ID, cfg.accessTokenTTL)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
 	})
 }
 
+func (cfg *apiConfig) revokeRefreshTokenFamily(ctx context.Context, rt database.RefreshToken) {
+	if rt.FamilyID == uuid.Nil {
+		// a token from before families gets one when it's rotated, which
+		// may have happened since it was read
+		current, err := cfg.db.GetRefreshToken(ctx, rt.Token)
+		if err == nil && current.Token != "" {
+			rt = current
+		}
+	}
+	var err error
+	if rt.FamilyID == uuid.Nil {
+		err = cfg.db.RevokeRefreshToken(ctx, rt.Token)
+	} else {
+		err = cfg.db.RevokeRefreshTokenFamily(ctx, rt.FamilyID)
+	}
+	if err != nil {
+		log.Println("revokeRefreshTokenFamily() couldn't revoke tokens of user", rt.UserID, err)
+	}
+}
+
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -46,11 +135,19 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(refreshToken)
+	rt, err := cfg.db.GetRefreshToken(r.Context(), refreshToken)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	err = cfg.db.RevokeRefreshToken(r.Context(), refreshToken)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
 		return
 	}
+	if rt.Token != "" {
+		cfg.auditSuccess(r, rt.UserID, auditLogout, auditTargetSession, rt.FamilyID.String())
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
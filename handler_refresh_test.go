@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func (ut *uploadTest) refresh(t *testing.T, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	ut.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRefreshReuseOfLegacyTokenRevokesDescendants(t *testing.T) {
+	ut := newUploadTest(t)
+	ut.mux.HandleFunc("POST /api/refresh", ut.cfg.handlerRefresh)
+	userID, _ := ut.createUser(t, "owner@example.com")
+
+	// tokens issued before families existed have no family_id
+	db, err := sql.Open("sqlite3", filepath.Join(ut.cfg.assetsRoot, "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
+		VALUES ('legacy', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+	`, userID.String(), time.Now().UTC().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := ut.refresh(t, "legacy"); rec.Code != http.StatusOK {
+		t.Fatalf("refresh returned %d: %s", rec.Code, rec.Body)
+	}
+	sessions, err := ut.cfg.db.GetSessions(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("got %d sessions after rotating the legacy token, want 1", len(sessions))
+	}
+
+	if rec := ut.refresh(t, "legacy"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("reusing the legacy token returned %d, want 401", rec.Code)
+	}
+	sessions, err = ut.cfg.db.GetSessions(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("the token that replaced the reused one is still live: %+v", sessions)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerScenesGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, auth.ScopeVideosRead, videoView)
+	if !ok {
+		return
+	}
+
+	cuts, err := cfg.db.GetSceneCuts(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve scene cuts", err)
+		return
+	}
+	for i := range cuts {
+		cuts[i].FrameURL = cfg.signAssetURL(cuts[i].FrameURL, mediaURLLifetime)
+	}
+	respondWithJSON(w, http.StatusOK, cuts)
+}
+
+// videoSceneCut loads the scene cut from the path and makes sure it belongs to video.
+func (cfg *apiConfig) videoSceneCut(w http.ResponseWriter, r *http.Request, video database.Video) (database.SceneCut, bool) {
+	sceneID, err := uuid.Parse(r.PathValue("sceneID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid scene ID", err)
+		return database.SceneCut{}, false
+	}
+	cut, err := cfg.db.GetSceneCut(r.Context(), sceneID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get scene cut", err)
+		return database.SceneCut{}, false
+	}
+	if cut.ID == uuid.Nil || cut.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get scene cut", nil)
+		return database.SceneCut{}, false
+	}
+	return cut, true
+}
+
+func (cfg *apiConfig) handlerSceneUseAsThumbnail(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, auth.ScopeVideosWrite, videoEdit)
+	if !ok {
+		return
+	}
+	cut, ok := cfg.videoSceneCut(w, r, video)
+	if !ok {
+		return
+	}
+	if cut.FrameURL == nil {
+		respondWithError(w, http.StatusBadRequest, "Scene cut has no candidate frame", nil)
+		return
+	}
+
+	video.ThumbnailURL = cut.FrameURL
+	err := cfg.db.UpdateVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to write thumbnail to database", err)
+		return
+	}
+	video, err = cfg.viewableVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerScenePromoteToChapter(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title string `json:"title"`
+	}
+
+	video, ok := cfg.authorizedVideo(w, r, auth.ScopeVideosWrite, videoEdit)
+	if !ok {
+		return
+	}
+	cut, ok := cfg.videoSceneCut(w, r, video)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	existing, err := cfg.db.GetChapters(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chapters", err)
+		return
+	}
+	chapterParams := database.CreateChapterParams{
+		VideoID:   video.ID,
+		StartTime: cut.Time,
+		Title:     params.Title,
+	}
+	err = validateChapter(chapterParams, existing, video, uuid.Nil)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	chapter, err := cfg.db.CreateChapter(r.Context(), chapterParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chapter", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, chapter)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultShareLinkLifetime = 7 * 24 * time.Hour
+	maxShareLinkLifetime     = 90 * 24 * time.Hour
+	// shareLinkURLLifetime caps how long the presigned media URLs handed out
+	// for a link stay playable, so they can't outlive a revocation by much
+	shareLinkURLLifetime = time.Hour
+)
+
+// shareLinkResponse is a share link as its video's managers see it. The token
+// isn't stored, it's signed again from the ID and expiry.
+type shareLinkResponse struct {
+	database.ShareLink
+	HasPassword bool   `json:"has_password"`
+	Token       string `json:"token"`
+	URL         string `json:"url"`
+}
+
+func (cfg *apiConfig) shareLinkResponse(link database.ShareLink) shareLinkResponse {
+	token := auth.SignShareLinkToken(cfg.jwtSecret, link.ID, link.ExpiresAt)
+	return shareLinkResponse{
+		ShareLink:   link,
+		HasPassword: link.HasPassword(),
+		Token:       token,
+		URL:         cfg.appLink("share", token),
+	}
+}
+
+func (cfg *apiConfig) handlerShareLinkCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+		Password  string     `json:"password"`
+		MaxViews  *int       `json:"max_views"`
+	}
+
+	video, actorID, ok := cfg.authorizedVideoActor(w, r, auth.ScopeVideosWrite, videoManage)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	expiresAt := time.Now().Add(defaultShareLinkLifetime)
+	if params.ExpiresAt != nil {
+		expiresAt = *params.ExpiresAt
+	}
+	if expiresAt.Before(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "Share link expiry is in the past", nil)
+		return
+	}
+	if expiresAt.After(time.Now().Add(maxShareLinkLifetime)) {
+		respondWithError(w, http.StatusBadRequest, "Share links can last at most 90 days", nil)
+		return
+	}
+	if params.MaxViews != nil && *params.MaxViews < 1 {
+		respondWithError(w, http.StatusBadRequest, "max_views must be at least 1", nil)
+		return
+	}
+
+	passwordHash := ""
+	if params.Password != "" {
+		passwordHash, err = auth.HashPassword(params.Password, cfg.argon2Params)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+			return
+		}
+	}
+
+	link, err := cfg.db.CreateShareLink(r.Context(), database.CreateShareLinkParams{
+		VideoID:      video.ID,
+		ExpiresAt:    expiresAt,
+		PasswordHash: passwordHash,
+		MaxViews:     params.MaxViews,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share link", err)
+		return
+	}
+	cfg.audit(r, actorID, auditShareLinkCreate, auditTargetShareLink, link.ID.String(), database.AuditOutcomeSuccess, "video "+video.ID.String())
+	respondWithJSON(w, http.StatusCreated, cfg.shareLinkResponse(link))
+}
+
+func (cfg *apiConfig) handlerShareLinksList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, auth.ScopeVideosRead, videoManage)
+	if !ok {
+		return
+	}
+
+	links, err := cfg.db.GetShareLinks(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve share links", err)
+		return
+	}
+	response := make([]shareLinkResponse, len(links))
+	for i, link := range links {
+		response[i] = cfg.shareLinkResponse(link)
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerShareLinkRevoke(w http.ResponseWriter, r *http.Request) {
+	video, actorID, ok := cfg.authorizedVideoActor(w, r, auth.ScopeVideosWrite, videoManage)
+	if !ok {
+		return
+	}
+	linkID, err := uuid.Parse(r.PathValue("linkID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid share link ID", err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokeShareLink(r.Context(), video.ID, linkID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke share link", err)
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "Share link not found", nil)
+		return
+	}
+	cfg.audit(r, actorID, auditShareLinkRevoke, auditTargetShareLink, linkID.String(), database.AuditOutcomeSuccess, "video "+video.ID.String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerShareLinkResolve is the public end of a share link. Every successful
+// call uses up a view and returns media URLs that only play for a while.
+func (cfg *apiConfig) handlerShareLinkResolve(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+	type sharedVideo struct {
+		ID           uuid.UUID `json:"id"`
+		Title        string    `json:"title"`
+		Description  string    `json:"description"`
+		Duration     *float64  `json:"duration"`
+		ThumbnailURL *string   `json:"thumbnail_url"`
+		VideoURL     *string   `json:"video_url"`
+		AudioURL     *string   `json:"audio_url"`
+	}
+	type response struct {
+		Video     sharedVideo `json:"video"`
+		ExpiresAt time.Time   `json:"expires_at"`
+		ViewsLeft *int        `json:"views_left"`
+	}
+
+	linkID, err := auth.ParseShareLinkToken(cfg.jwtSecret, r.PathValue("token"), time.Now())
+	if errors.Is(err, auth.ErrShareLinkExpired) {
+		respondWithError(w, http.StatusGone, "This share link has expired", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Share link not found", err)
+		return
+	}
+
+	params := parameters{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+
+	link, err := cfg.db.GetShareLink(r.Context(), linkID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+	if link.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Share link not found", nil)
+		return
+	}
+	if link.RevokedAt != nil || !link.ExpiresAt.After(time.Now()) {
+		respondWithError(w, http.StatusGone, "This share link is no longer valid", nil)
+		return
+	}
+
+	if link.HasPassword() {
+		throttleKey := "share_link:" + link.ID.String()
+		if !cfg.checkThrottle(r.Context(), w, "Too many wrong passwords, try again later", throttleKey) {
+			return
+		}
+		if params.Password == "" {
+			respondWithError(w, http.StatusUnauthorized, "This share link needs a password", nil)
+			return
+		}
+		if auth.CheckPasswordHash(params.Password, link.PasswordHash) != nil {
+			cfg.recordThrottledFailure(r.Context(), throttleKey, cfg.loginThrottle.account)
+			respondWithError(w, http.StatusUnauthorized, "Incorrect password", nil)
+			return
+		}
+	}
+
+	video, err := cfg.db.GetVideo(r.Context(), link.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusGone, "The shared video was deleted", nil)
+		return
+	}
+
+	counted, err := cfg.db.CountShareLinkView(r.Context(), link.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count view", err)
+		return
+	}
+	if !counted {
+		respondWithError(w, http.StatusGone, "This share link has no views left", nil)
+		return
+	}
+
+	urlLifetime := min(shareLinkURLLifetime, time.Until(link.ExpiresAt))
+	videoURL, err := cfg.presignMediaURL(r.Context(), video.VideoURL, urlLifetime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+	audioURL, err := cfg.presignMediaURL(r.Context(), video.AudioURL, urlLifetime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign audio URL", err)
+		return
+	}
+
+	var viewsLeft *int
+	if link.MaxViews != nil {
+		left := *link.MaxViews - link.Views - 1
+		viewsLeft = &left
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Video: sharedVideo{
+			ID:           video.ID,
+			Title:        video.Title,
+			Description:  video.Description,
+			Duration:     video.Duration,
+			ThumbnailURL: cfg.signAssetURL(video.ThumbnailURL, urlLifetime),
+			VideoURL:     videoURL,
+			AudioURL:     audioURL,
+		},
+		ExpiresAt: link.ExpiresAt,
+		ViewsLeft: viewsLeft,
+	})
+}
+
+// presignMediaURL turns the distribution URL of an uploaded file into a
+// presigned S3 URL that expires. URLs of files elsewhere are kept as they are.
+//
+// The presigned URL shows the object key, and S3_CF_DISTRO serves the same
+// key. Expiry only cuts off playback when the distribution doesn't serve the
+// bucket publicly, otherwise anyone who saw a presigned URL can keep loading
+// the file from the distribution.
+func (cfg *apiConfig) presignMediaURL(ctx context.Context, mediaURL *string, lifetime time.Duration) (*string, error) {
+	if mediaURL == nil {
+		return nil, nil
+	}
+	key, ok := cfg.mediaKey(mediaURL)
+	if !ok {
+		return mediaURL, nil
+	}
+	presigned, err := cfg.uploader.PresignGet(ctx, key, lifetime)
+	if err != nil {
+		return nil, err
+	}
+	return &presigned, nil
+}
+
+// mediaKey is the bucket key of a file served through the distribution. It
+// reports false for URLs of files stored elsewhere.
+func (cfg *apiConfig) mediaKey(mediaURL *string) (string, bool) {
+	if mediaURL == nil {
+		return "", false
+	}
+	return strings.CutPrefix(*mediaURL, cfg.s3CfDistribution+"/")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestShareLinkResolveSignsMediaURLs(t *testing.T) {
+	ut := newUploadTest(t)
+	ut.mux.HandleFunc("POST /api/share_links/{token}", ut.cfg.handlerShareLinkResolve)
+	ut.mux.HandleFunc("GET /assets/{name}", ut.cfg.handlerAsset)
+	userID, _ := ut.createUser(t, "owner@example.com")
+
+	if err := os.WriteFile(filepath.Join(ut.cfg.assetsRoot, "thumb.jpg"), []byte("jpeg"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	video := ut.createVideo(t, userID)
+	thumbnailURL := "http://localhost:8091/assets/thumb.jpg"
+	videoURL := ut.cfg.s3CfDistribution + "/landscape/video.mp4"
+	video.ThumbnailURL = &thumbnailURL
+	video.VideoURL = &videoURL
+	if err := ut.cfg.db.UpdateVideo(context.Background(), video); err != nil {
+		t.Fatal(err)
+	}
+	link, err := ut.cfg.db.CreateShareLink(context.Background(), database.CreateShareLinkParams{
+		VideoID:   video.ID,
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := auth.SignShareLinkToken(ut.cfg.jwtSecret, link.ID, link.ExpiresAt)
+	req := httptest.NewRequest(http.MethodPost, "/api/share_links/"+token, nil)
+	rec := httptest.NewRecorder()
+	ut.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("resolving the link returned %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Video struct {
+			ThumbnailURL *string `json:"thumbnail_url"`
+			VideoURL     *string `json:"video_url"`
+		} `json:"video"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Video.VideoURL == nil || *resp.Video.VideoURL != "https://bucket.example/landscape/video.mp4?expires=1h0m0s" {
+		t.Errorf("got video URL %v, want a presigned one", resp.Video.VideoURL)
+	}
+	if resp.Video.ThumbnailURL == nil || *resp.Video.ThumbnailURL == thumbnailURL {
+		t.Fatalf("got thumbnail URL %v, want a signed one", resp.Video.ThumbnailURL)
+	}
+	if rec := ut.getAsset(t, *resp.Video.ThumbnailURL); rec.Code != http.StatusOK {
+		t.Errorf("the signed thumbnail URL returned %d: %s", rec.Code, rec.Body)
+	}
+}
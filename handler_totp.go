@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer = "Tubely"
+	// twoFactorChallengeTTL is how long the user has to enter a code after
+	// their password was accepted
+	twoFactorChallengeTTL = 5 * time.Minute
+	// after totpMaxFailures wrong codes in a row, codes aren't checked at all
+	// for totpLockout, so guessing all million codes takes years
+	totpMaxFailures = 5
+	totpLockout     = 15 * time.Minute
+)
+
+// handlerTOTPEnroll creates a pending authenticator secret. It isn't enforced
+// until it's confirmed with a code from the authenticator.
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeAdmin)
+	if !ok {
+		return
+	}
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	cred, err := cfg.db.GetTOTPCredential(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if cred.EnabledAt != nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create secret", err)
+		return
+	}
+	err = cfg.db.SetPendingTOTP(r.Context(), userID, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// handlerTOTPConfirm enables two-factor login once the user proves their
+// authenticator works, and hands out the recovery codes.
+func (cfg *apiConfig) handlerTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeAdmin)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	cred, err := cfg.db.GetTOTPCredential(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if cred.UserID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Start two-factor enrollment first", nil)
+		return
+	}
+	if cred.EnabledAt != nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(cred.Secret, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Incorrect code, check the clock of your device", nil)
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	err = cfg.db.EnableTOTP(r.Context(), userID, step, hashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+	cfg.auditSuccess(r, userID, auditTOTPEnable, auditTargetUser, userID.String())
+
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: codes,
+	})
+}
+
+// handlerTOTPDisable turns two-factor login off. It takes a current code so a
+// stolen access token isn't enough.
+func (cfg *apiConfig) handlerTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeAdmin)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	cred, err := cfg.db.GetTOTPCredential(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if cred.EnabledAt == nil {
+		respondWithError(w, http.StatusNotFound, "Two-factor authentication isn't enabled", nil)
+		return
+	}
+	if !cfg.verifySecondFactor(r.Context(), w, cred, params.Code, params.RecoveryCode) {
+		cfg.audit(r, userID, auditTOTPDisable, auditTargetUser, userID.String(), database.AuditOutcomeFailure, "second factor not accepted")
+		return
+	}
+
+	err = cfg.db.DeleteTOTP(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+	cfg.auditSuccess(r, userID, auditTOTPDisable, auditTargetUser, userID.String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerLoginTwoFactor is the second step of logging in to an account with
+// two-factor authentication, trading the challenge token from handlerLogin
+// and a code for the access and refresh tokens.
+func (cfg *apiConfig) handlerLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+		DeviceLabel    string `json:"device_label"`
+	}
+	type response struct {
+		database.User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, err := cfg.keyRing().ValidateToken(params.ChallengeToken, auth.TokenTypeTwoFactor)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Login expired, please log in again", err)
+		return
+	}
+	cred, err := cfg.db.GetTOTPCredential(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if cred.EnabledAt == nil {
+		respondWithError(w, http.StatusUnauthorized, "Login expired, please log in again", nil)
+		return
+	}
+	if !cfg.verifySecondFactor(r.Context(), w, cred, params.Code, params.RecoveryCode) {
+		cfg.audit(r, userID, auditLoginTwoFactor, auditTargetUser, userID.String(), database.AuditOutcomeFailure, "second factor not accepted")
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	accessToken, refreshToken, err := cfg.startSession(r, user.ID, params.DeviceLabel)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start session", err)
+		return
+	}
+	cfg.auditSuccess(r, user.ID, auditLoginTwoFactor, auditTargetUser, user.ID.String())
+
+	respondWithJSON(w, http.StatusOK, response{
+		User:         *user,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	})
+}
+
+// verifySecondFactor checks a TOTP code, or an unused recovery code which is
+// then used up. It writes the error response itself and reports whether to
+// go on.
+func (cfg *apiConfig) verifySecondFactor(ctx context.Context, w http.ResponseWriter, cred database.TOTPCredential, code, recoveryCode string) bool {
+	now := time.Now()
+	if cred.LockedUntil != nil && cred.LockedUntil.After(now) {
+		w.Header().Set("Retry-After", fmt.Sprint(int(cred.LockedUntil.Sub(now).Seconds())+1))
+		respondWithError(w, http.StatusTooManyRequests, "Too many incorrect codes, try again later", nil)
+		return false
+	}
+
+	if recoveryCode != "" {
+		used, err := cfg.db.UseRecoveryCode(ctx, cred.UserID, auth.HashRecoveryCode(recoveryCode))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check recovery code", err)
+			return false
+		}
+		if used {
+			return true
+		}
+	} else if step, ok := auth.ValidateTOTP(cred.Secret, code, now); ok {
+		// a code is only good once, even within its period
+		fresh, err := cfg.db.RecordTOTPSuccess(ctx, cred.UserID, step)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+			return false
+		}
+		if fresh {
+			return true
+		}
+	}
+
+	if err := cfg.db.RecordTOTPFailure(ctx, cred.UserID, totpMaxFailures, now.Add(totpLockout)); err != nil {
+		log.Println("verifySecondFactor() unable to record failure", err)
+	}
+	respondWithError(w, http.StatusUnauthorized, "Incorrect code", nil)
+	return false
+}
@@ -74,15 +74,8 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosWrite)
+	if !ok {
 		return
 	}
 
@@ -122,14 +115,17 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	dbVideo, err := cfg.db.GetVideo(videoID)
+	dbVideo, err := cfg.db.GetVideo(r.Context(), videoID)
 	if err != nil {
 		log.Println("handlerUploadThumbnail() unable to find video in database", err)
 		respondWithError(w, http.StatusNotFound, "error getting video", err)
 		return
 	}
-	if dbVideo.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "not allowed", nil)
+	if dbVideo.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "error getting video", nil)
+		return
+	}
+	if !cfg.checkVideoAccess(r.Context(), w, userID, dbVideo, videoEdit) {
 		return
 	}
 	fileExtension, err := mime.ExtensionsByType(fileMime)
@@ -148,11 +144,12 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	}
 	thumbnailURL := fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, fName)
 	dbVideo.ThumbnailURL = &thumbnailURL
-	err = cfg.db.UpdateVideo(dbVideo)
+	err = cfg.db.UpdateVideo(r.Context(), dbVideo)
 	if err != nil {
 		log.Println("handlerUploadThumbnail() unable to add thumbnail to database", err)
 		respondWithError(w, http.StatusInternalServerError, "Unable to write thumbnail to database", err)
 		return
 	}
+	cfg.auditSuccess(r, userID, auditThumbnailUpload, auditTargetVideo, videoID.String())
 	respondWithJSON(w, http.StatusOK, struct{}{})
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -9,7 +10,6 @@ import (
 	"os"
 	"slices"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/google/uuid"
 )
@@ -25,14 +25,8 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
 		return
 	}
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosWrite)
+	if !ok {
 		return
 	}
 	log.Println("handlerUploadVideo() uploading thumbnail for video", videoID, "by user", userID)
@@ -42,12 +36,19 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "error parsing multipart form", err)
 		return
 	}
-	dbVideo, err := cfg.db.GetVideo(videoID)
+	dbVideo, err := cfg.db.GetVideo(r.Context(), videoID)
 	if err != nil {
 		log.Println("handlerUploadVideo() unable to find video in database", err)
 		respondWithError(w, http.StatusNotFound, "error getting video", err)
 		return
 	}
+	if dbVideo.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "error getting video", nil)
+		return
+	}
+	if !cfg.checkVideoAccess(r.Context(), w, userID, dbVideo, videoEdit) {
+		return
+	}
 	formFile, formFileHeader, err := r.FormFile("video")
 	if err != nil {
 		log.Println("handlerUploadVideo() error getting video", err)
@@ -80,18 +81,65 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "unable to copy into temp file", err)
 		return
 	}
-	aspect, err := getVideoAspectRatio(tmpFile.Name())
+	sourcePath := tmpFile.Name()
+	diagnosis, err := cfg.diagnoseUpload(r.Context(), sourcePath)
+	if err != nil {
+		log.Println("handlerUploadVideo() integrity check failed to run", err)
+		respondWithError(w, mediaErrorStatus(err), "Unable to check video integrity", err)
+		return
+	}
+	if diagnosis != nil {
+		log.Println("handlerUploadVideo() damaged upload:", diagnosis.Problem, diagnosis.Detail)
+		repairedPath, ok := cfg.repairUpload(r.Context(), sourcePath, diagnosis)
+		if !ok {
+			respondWithJSON(w, diagnosisStatus(diagnosis), struct {
+				Error     string           `json:"error"`
+				Diagnosis *uploadDiagnosis `json:"diagnosis"`
+			}{
+				Error:     "The uploaded video is damaged",
+				Diagnosis: diagnosis,
+			})
+			return
+		}
+		defer os.Remove(repairedPath)
+		sourcePath = repairedPath
+	}
+	probe, err := cfg.prober.Probe(r.Context(), sourcePath)
 	if err != nil {
 		log.Println("handlerUploadVideo() failed to get video aspect ratio", err)
-		respondWithError(w, http.StatusInternalServerError, "unable to get aspect ratio from temp file", err)
+		respondWithError(w, mediaErrorStatus(err), "unable to get aspect ratio from temp file", err)
 		return
 	}
+	aspect := probe.aspectRatio()
+	duration, err := probe.duration()
+	if err != nil {
+		log.Println("handlerUploadVideo() failed to get video duration", err)
+		respondWithError(w, http.StatusInternalServerError, "unable to get duration from temp file", err)
+		return
+	}
+	dbVideo.Duration = &duration
+	chapters, err := cfg.createChaptersFromDescription(r.Context(), dbVideo)
+	if err != nil {
+		log.Println("handlerUploadVideo() unable to load chapters", err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to load chapters", err)
+		return
+	}
+	metadataPath := ""
+	if len(chapters) > 0 {
+		metadataPath, err = writeFFMetadata(tmpFile.Name(), dbVideo, chapters, duration)
+		if err != nil {
+			log.Println("handlerUploadVideo() unable to write chapter metadata", err)
+			respondWithError(w, http.StatusInternalServerError, "Unable to write chapter metadata", err)
+			return
+		}
+		defer os.Remove(metadataPath)
+	}
 	// reset to start
 	tmpFile.Seek(0, io.SeekStart)
-	processedFileName, err := processVideoForFastStart(tmpFile.Name())
+	processedFileName, err := cfg.transcoder.FastStart(r.Context(), sourcePath, metadataPath, duration)
 	if err != nil {
 		log.Println("handlerUploadVideo() unable to process temp video", err)
-		respondWithError(w, http.StatusInternalServerError, "Unable to process video", err)
+		respondWithError(w, mediaErrorStatus(err), "Unable to process video", err)
 		return
 	}
 	err = tmpFile.Close()
@@ -106,9 +154,15 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	defer tmpFile.Close()
-	defer os.Remove(processedFileName)
-	destName := fmt.Sprintf("%s/%s", aspect, getThumbName(".mp4"))
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{Bucket: &cfg.s3Bucket, Key: &destName, Body: tmpFile, ContentType: &fileMime})
+	analyzing := false
+	defer func() {
+		if !analyzing {
+			os.Remove(processedFileName)
+		}
+	}()
+	keyBase := fmt.Sprintf("%s/%s", aspect, getThumbName(""))
+	destName := keyBase + ".mp4"
+	err = cfg.uploader.Put(r.Context(), destName, fileMime, tmpFile)
 	if err != nil {
 		log.Println("handlerUploadVideo() Unable to upload file", err)
 		respondWithError(w, http.StatusInternalServerError, "Unable to push to s3", err)
@@ -116,12 +170,43 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	}
 	newURL := fmt.Sprintf("%s/%s", cfg.s3CfDistribution, destName)
 	dbVideo.VideoURL = &newURL
-	err = cfg.db.UpdateVideo(dbVideo)
+	dbVideo.AudioURL = nil
+	if len(cfg.audioFormats) > 0 && probe.hasAudio() {
+		audioURL, err := cfg.uploadAudioRenditions(r.Context(), processedFileName, keyBase, dbVideo)
+		if err != nil {
+			// renditions are optional and the video is already in the
+			// bucket, so the upload goes on without audio_url
+			log.Println("handlerUploadVideo() unable to create audio rendition", err)
+		} else {
+			dbVideo.AudioURL = &audioURL
+		}
+	}
+	err = cfg.db.UpdateVideo(r.Context(), dbVideo)
 	if err != nil {
 		log.Println("handlerUploadVideo() Error updating video record", err)
 		respondWithError(w, http.StatusInternalServerError, "Unable to write video to database", err)
 		return
 	}
 	log.Println("handlerUploadVideo() Video uploaded successfully!")
-	respondWithJSON(w, http.StatusOK, struct{}{})
+	cfg.auditSuccess(r, userID, auditVideoUpload, auditTargetVideo, videoID.String())
+
+	// scene detection decodes the whole file, so it runs after the response
+	// and the suggestions show up once it's done. It owns the processed file
+	// from here on
+	analyzing = true
+	cfg.sceneAnalysis.Add(1)
+	go func() {
+		defer cfg.sceneAnalysis.Done()
+		defer os.Remove(processedFileName)
+		_, err := cfg.analyzeScenes(context.Background(), dbVideo, processedFileName)
+		if err != nil {
+			// suggestions are optional, the upload succeeded without them
+			log.Println("handlerUploadVideo() scene analysis failed", err)
+		}
+	}()
+	respondWithJSON(w, http.StatusOK, struct {
+		Repair *uploadDiagnosis `json:"repair,omitempty"`
+	}{
+		Repair: diagnosis,
+	})
 }
@@ -0,0 +1,692 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mp4"
+	"github.com/google/uuid"
+)
+
+// memoryUploader is an Uploader keeping the files in memory.
+type memoryUploader struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func newMemoryUploader() *memoryUploader {
+	return &memoryUploader{objects: map[string][]byte{}, types: map[string]string{}}
+}
+
+func (u *memoryUploader) Put(ctx context.Context, key, contentType string, body io.Reader) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.objects[key] = data
+	u.types[key] = contentType
+	return nil
+}
+
+func (u *memoryUploader) Delete(ctx context.Context, key string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.objects, key)
+	delete(u.types, key)
+	return nil
+}
+
+func (u *memoryUploader) PresignGet(ctx context.Context, key string, lifetime time.Duration) (string, error) {
+	return "https://bucket.example/" + key + "?expires=" + lifetime.String(), nil
+}
+
+func (u *memoryUploader) keys() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	keys := []string{}
+	for key := range u.objects {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// failingAudio is the fake toolchain with audio extraction broken.
+type failingAudio struct {
+	*fakeToolchain
+}
+
+func (f failingAudio) ExtractAudio(ctx context.Context, filePath, artworkPath, title string, format audioFormat, duration float64) (string, error) {
+	return "", errors.New("no audio encoder")
+}
+
+// failingOpus is the fake toolchain without an opus encoder, so the m4a
+// rendition is stored before the opus one fails.
+type failingOpus struct {
+	*fakeToolchain
+}
+
+func (f failingOpus) ExtractAudio(ctx context.Context, filePath, artworkPath, title string, format audioFormat, duration float64) (string, error) {
+	if format.encoder == "libopus" {
+		return "", errors.New("no opus encoder")
+	}
+	return f.fakeToolchain.ExtractAudio(ctx, filePath, artworkPath, title, format, duration)
+}
+
+// failingFrames is the fake toolchain failing to extract the second frame.
+type failingFrames struct {
+	*fakeToolchain
+}
+
+func (f failingFrames) ExtractFrame(ctx context.Context, filePath string, at float64) ([]byte, error) {
+	if at < 5 {
+		return f.fakeToolchain.ExtractFrame(ctx, filePath, at)
+	}
+	return nil, errors.New("seek failed")
+}
+
+// remuxingToolchain is the fake toolchain standing in for an ffmpeg that
+// decodes files the pure Go parser rejects, and remuxes them into testMP4.
+type remuxingToolchain struct {
+	*fakeToolchain
+}
+
+func (f remuxingToolchain) Repair(ctx context.Context, filePath string, reencode bool, duration float64) (string, error) {
+	outputPath := filePath + ".repaired"
+	return outputPath, os.WriteFile(outputPath, testMP4(), 0600)
+}
+
+type uploadTest struct {
+	cfg      *apiConfig
+	uploader *memoryUploader
+	mux      *http.ServeMux
+}
+
+// newUploadTest sets up the server with an SQLite database in a temporary
+// directory, the fake media toolchain and an in-memory bucket.
+func newUploadTest(t *testing.T) *uploadTest {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.NewClient(filepath.Join(dir, "tubely.db"), 5*time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	uploader := newMemoryUploader()
+	fake := newFakeToolchain()
+	cfg := &apiConfig{
+		db:               db,
+		jwtSecret:        "test-secret",
+		platform:         "dev",
+		assetsRoot:       dir,
+		s3Bucket:         "tubely-test",
+		s3CfDistribution: "https://cdn.example",
+		uploader:         uploader,
+		audioFormats:     []string{"m4a", "opus"},
+		prober:           fake,
+		transcoder:       fake,
+		accessTokenTTL:   time.Hour,
+		jwtSigningAlg:    auth.AlgHS256,
+	}
+	if err := cfg.initKeyRing(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cfg.sceneAnalysis.Wait)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	return &uploadTest{cfg: cfg, uploader: uploader, mux: mux}
+}
+
+// createUser returns a user and an access token for them.
+func (ut *uploadTest) createUser(t *testing.T, email string) (uuid.UUID, string) {
+	t.Helper()
+	user, err := ut.cfg.db.CreateUser(context.Background(), database.CreateUserParams{Email: email, Password: "unused"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := ut.cfg.keyRing().MakeJWT(user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID, token
+}
+
+func (ut *uploadTest) createVideo(t *testing.T, userID uuid.UUID) database.Video {
+	t.Helper()
+	video, err := ut.cfg.db.CreateVideo(context.Background(), database.CreateVideoParams{
+		Title:      "Boots",
+		UserID:     userID,
+		Visibility: database.VisibilityPrivate,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return video
+}
+
+// frames returns the scene frames saved in the assets directory.
+func (ut *uploadTest) frames(t *testing.T) []string {
+	t.Helper()
+	frames, err := filepath.Glob(filepath.Join(ut.cfg.assetsRoot, "*.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frames
+}
+
+func (ut *uploadTest) upload(t *testing.T, videoID uuid.UUID, token, contentType string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="video"; filename="boots.mp4"`)
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+videoID.String(), body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	ut.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// testMP4 builds a small but well formed MP4 with one 1920x1080 video track,
+// the moov box after the media data.
+func testMP4() []byte {
+	return testMP4Rotated([4]int32{0x10000, 0, 0, 0x10000})
+}
+
+// testMP4Rotated is testMP4 with the a, b, c and d entries of the track
+// matrix set, which is how players are told to rotate the frames.
+func testMP4Rotated(matrix [4]int32) []byte {
+	fullBox := func(size int) []byte { return make([]byte, size) }
+
+	mvhd := fullBox(100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 10000)
+
+	tkhd := fullBox(84)
+	binary.BigEndian.PutUint32(tkhd[12:], 1)
+	// the matrix, then 1920x1080 in 16.16 fixed point
+	binary.BigEndian.PutUint32(tkhd[40:], uint32(matrix[0]))
+	binary.BigEndian.PutUint32(tkhd[44:], uint32(matrix[1]))
+	binary.BigEndian.PutUint32(tkhd[52:], uint32(matrix[2]))
+	binary.BigEndian.PutUint32(tkhd[56:], uint32(matrix[3]))
+	binary.BigEndian.PutUint32(tkhd[72:], 0x40000000)
+	binary.BigEndian.PutUint32(tkhd[76:], 1920<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], 1080<<16)
+
+	mdhd := fullBox(24)
+	binary.BigEndian.PutUint32(mdhd[12:], 1000)
+	binary.BigEndian.PutUint32(mdhd[16:], 10000)
+
+	hdlr := fullBox(25)
+	copy(hdlr[8:], "vide")
+
+	stsd := fullBox(24)
+	binary.BigEndian.PutUint32(stsd[4:], 1)
+	binary.BigEndian.PutUint32(stsd[8:], 16)
+	copy(stsd[12:], "avc1")
+
+	ftyp := (&mp4.Box{Type: "ftyp", Data: []byte("isom\x00\x00\x02\x00isomiso2avc1mp41")}).Encode()
+	mdat := (&mp4.Box{Type: "mdat", Data: bytes.Repeat([]byte{0xab}, 64)}).Encode()
+
+	stco := fullBox(12)
+	binary.BigEndian.PutUint32(stco[4:], 1)
+	binary.BigEndian.PutUint32(stco[8:], uint32(len(ftyp)+8))
+
+	leaf := func(boxType string, data []byte) *mp4.Box { return &mp4.Box{Type: boxType, Data: data} }
+	parent := func(boxType string, children ...*mp4.Box) *mp4.Box {
+		return &mp4.Box{Type: boxType, Children: children}
+	}
+	moov := parent("moov",
+		leaf("mvhd", mvhd),
+		parent("trak",
+			leaf("tkhd", tkhd),
+			parent("mdia",
+				leaf("mdhd", mdhd),
+				leaf("hdlr", hdlr),
+				parent("minf",
+					parent("stbl", leaf("stsd", stsd), leaf("stco", stco)),
+				),
+			),
+		),
+	)
+
+	file := append([]byte{}, ftyp...)
+	file = append(file, mdat...)
+	return append(file, moov.Encode()...)
+}
+
+func TestUploadVideo(t *testing.T) {
+	ut := newUploadTest(t)
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+
+	rec := ut.upload(t, video.ID, token, "video/mp4", testMP4())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+
+	got, err := ut.cfg.db.GetVideo(context.Background(), video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.VideoURL == nil || !strings.HasPrefix(*got.VideoURL, "https://cdn.example/landscape/") || !strings.HasSuffix(*got.VideoURL, ".mp4") {
+		t.Fatalf("video_url = %v, want a landscape .mp4 on the distribution", got.VideoURL)
+	}
+	if got.Duration == nil || *got.Duration != 10 {
+		t.Errorf("duration = %v, want 10", got.Duration)
+	}
+	key := strings.TrimPrefix(*got.VideoURL, "https://cdn.example/")
+	keyBase := strings.TrimSuffix(key, ".mp4")
+	if got.AudioURL == nil || *got.AudioURL != "https://cdn.example/"+keyBase+".m4a" {
+		t.Errorf("audio_url = %v, want the m4a rendition", got.AudioURL)
+	}
+
+	wantTypes := map[string]string{
+		key:               "video/mp4",
+		keyBase + ".m4a":  "audio/mp4",
+		keyBase + ".opus": "audio/ogg",
+	}
+	for wantKey, wantType := range wantTypes {
+		if ut.uploader.types[wantKey] != wantType {
+			t.Errorf("object %s has type %q, want %q", wantKey, ut.uploader.types[wantKey], wantType)
+		}
+	}
+	if !bytes.Equal(ut.uploader.objects[key], testMP4()) {
+		t.Error("the fake toolchain should store the upload unchanged")
+	}
+
+	ut.cfg.sceneAnalysis.Wait()
+	cuts, err := ut.cfg.db.GetSceneCuts(context.Background(), video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cuts) != 2 {
+		t.Errorf("got %d scene cuts, want the 2 of the fake toolchain", len(cuts))
+	}
+}
+
+func TestUploadVideoRejectsOtherMediaTypes(t *testing.T) {
+	ut := newUploadTest(t)
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+
+	rec := ut.upload(t, video.ID, token, "video/quicktime", testMP4())
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("upload returned %d, want 400", rec.Code)
+	}
+	if len(ut.uploader.keys()) != 0 {
+		t.Errorf("nothing should be stored, got %v", ut.uploader.keys())
+	}
+}
+
+func TestUploadVideoOfAnotherUser(t *testing.T) {
+	ut := newUploadTest(t)
+	ownerID, _ := ut.createUser(t, "owner@example.com")
+	_, token := ut.createUser(t, "other@example.com")
+	video := ut.createVideo(t, ownerID)
+
+	rec := ut.upload(t, video.ID, token, "video/mp4", testMP4())
+	if rec.Code != http.StatusNotFound && rec.Code != http.StatusForbidden {
+		t.Fatalf("upload returned %d, want 403 or 404", rec.Code)
+	}
+	if len(ut.uploader.keys()) != 0 {
+		t.Errorf("nothing should be stored, got %v", ut.uploader.keys())
+	}
+}
+
+func TestUploadVideoWithoutToken(t *testing.T) {
+	ut := newUploadTest(t)
+	userID, _ := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+
+	rec := ut.upload(t, video.ID, "not-a-jwt", "video/mp4", testMP4())
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("upload returned %d, want 401", rec.Code)
+	}
+}
+
+func TestUploadVideoDamaged(t *testing.T) {
+	ut := newUploadTest(t)
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+
+	movie := testMP4()
+	for name, data := range map[string][]byte{
+		"not an mp4":         []byte("definitely not a video"),
+		"shorter than a box": {0, 0, 0},
+		"empty":              {},
+		"bad first box":      {0, 0, 0, 4, 'f', 't', 'y', 'p', 0, 0},
+		"no moov":            (&mp4.Box{Type: "mdat", Data: []byte("frames")}).Encode(),
+		"cut off in moov":    movie[:len(movie)-20],
+		"garbage after moov": append(append([]byte{}, movie...), "stray bytes"...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := ut.upload(t, video.ID, token, "video/mp4", data)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("upload returned %d, want 400: %s", rec.Code, rec.Body)
+			}
+			var body struct {
+				Diagnosis *uploadDiagnosis `json:"diagnosis"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if body.Diagnosis == nil {
+				t.Fatalf("response has no diagnosis: %s", rec.Body)
+			}
+		})
+	}
+	if len(ut.uploader.keys()) != 0 {
+		t.Errorf("nothing should be stored, got %v", ut.uploader.keys())
+	}
+}
+
+func TestUploadVideoTruncatedMediaData(t *testing.T) {
+	ut := newUploadTest(t)
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+
+	// with the moov box first, cutting the end off leaves the media data short
+	movie := testMP4()
+	var fastStart bytes.Buffer
+	if err := mp4.FastStart(bytes.NewReader(movie), int64(len(movie)), &fastStart); err != nil {
+		t.Fatal(err)
+	}
+	data := fastStart.Bytes()[:fastStart.Len()-10]
+
+	rec := ut.upload(t, video.ID, token, "video/mp4", data)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("upload returned %d, want 422: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Diagnosis *uploadDiagnosis `json:"diagnosis"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body.Diagnosis == nil || body.Diagnosis.Problem != problemTruncatedMdat {
+		t.Fatalf("diagnosis = %+v, want %s", body.Diagnosis, problemTruncatedMdat)
+	}
+}
+
+func TestUploadVideoRepairsUndecodableFrames(t *testing.T) {
+	ut := newUploadTest(t)
+	fake := newFakeToolchain()
+	fake.DecodeErrors = []string{"[h264 @ 0x1] error while decoding MB 3 7"}
+	ut.cfg.transcoder = fake
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+
+	rec := ut.upload(t, video.ID, token, "video/mp4", testMP4())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Repair *uploadDiagnosis `json:"repair"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Repair == nil || !body.Repair.Repaired || body.Repair.Problem != problemUndecodableFrames {
+		t.Fatalf("repair = %+v, want repaired undecodable frames", body.Repair)
+	}
+}
+
+func TestUploadVideoWithoutAudioRenditions(t *testing.T) {
+	ut := newUploadTest(t)
+	ut.cfg.transcoder = failingAudio{newFakeToolchain()}
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+
+	rec := ut.upload(t, video.ID, token, "video/mp4", testMP4())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+	got, err := ut.cfg.db.GetVideo(context.Background(), video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.VideoURL == nil {
+		t.Fatal("video_url should be set when only the renditions fail")
+	}
+	if got.AudioURL != nil {
+		t.Errorf("audio_url = %q, want none", *got.AudioURL)
+	}
+	if len(ut.uploader.keys()) != 1 {
+		t.Errorf("want only the video stored, got %v", ut.uploader.keys())
+	}
+}
+
+func TestUploadVideoWithOneFailingAudioRendition(t *testing.T) {
+	ut := newUploadTest(t)
+	ut.cfg.transcoder = failingOpus{newFakeToolchain()}
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+
+	rec := ut.upload(t, video.ID, token, "video/mp4", testMP4())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+	if keys := ut.uploader.keys(); len(keys) != 1 || !strings.HasSuffix(keys[0], ".mp4") {
+		t.Errorf("want only the video stored, got %v", keys)
+	}
+}
+
+func TestDeleteVideoRemovesMedia(t *testing.T) {
+	ut := newUploadTest(t)
+	ut.mux.HandleFunc("DELETE /api/videos/{videoID}", ut.cfg.handlerVideoMetaDelete)
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+	if rec := ut.upload(t, video.ID, token, "video/mp4", testMP4()); rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/videos/"+video.ID.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	ut.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete returned %d: %s", rec.Code, rec.Body)
+	}
+	if keys := ut.uploader.keys(); len(keys) != 0 {
+		t.Errorf("the video and its renditions should be deleted from the bucket, got %v", keys)
+	}
+}
+
+func TestUploadVideoAgainReplacesSceneCuts(t *testing.T) {
+	ut := newUploadTest(t)
+	ctx := context.Background()
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+	if rec := ut.upload(t, video.ID, token, "video/mp4", testMP4()); rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+	ut.cfg.sceneAnalysis.Wait()
+	old, err := ut.cfg.db.GetSceneCuts(ctx, video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err = ut.cfg.db.GetVideo(ctx, video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video.ThumbnailURL = old[0].FrameURL
+	if err := ut.cfg.db.UpdateVideo(ctx, video); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := ut.upload(t, video.ID, token, "video/mp4", testMP4()); rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+	ut.cfg.sceneAnalysis.Wait()
+	cuts, err := ut.cfg.db.GetSceneCuts(ctx, video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cuts) != 2 || cuts[0].ID == old[0].ID {
+		t.Fatalf("got cuts %+v, want the 2 new ones", cuts)
+	}
+	// the new frames and the old one used as the thumbnail
+	if frames := ut.frames(t); len(frames) != 3 {
+		t.Errorf("got frames %v, want 3", frames)
+	}
+}
+
+func TestUploadVideoKeepsSceneCutsWhenAnalysisFails(t *testing.T) {
+	ut := newUploadTest(t)
+	ctx := context.Background()
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+	if rec := ut.upload(t, video.ID, token, "video/mp4", testMP4()); rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+	ut.cfg.sceneAnalysis.Wait()
+	old, err := ut.cfg.db.GetSceneCuts(ctx, video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ut.cfg.transcoder = failingFrames{newFakeToolchain()}
+	if rec := ut.upload(t, video.ID, token, "video/mp4", testMP4()); rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+	ut.cfg.sceneAnalysis.Wait()
+	cuts, err := ut.cfg.db.GetSceneCuts(ctx, video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cuts) != len(old) || cuts[0].ID != old[0].ID {
+		t.Errorf("got cuts %+v, want the old ones kept", cuts)
+	}
+	if frames := ut.frames(t); len(frames) != len(old) {
+		t.Errorf("got frames %v, want only the %d old ones", frames, len(old))
+	}
+}
+
+func TestUploadVideoWithMP4Toolchain(t *testing.T) {
+	ut := newUploadTest(t)
+	ut.cfg.useMP4Toolchain()
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+
+	rec := ut.upload(t, video.ID, token, "video/mp4", testMP4())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+	keys := ut.uploader.keys()
+	if len(keys) != 1 || !strings.HasPrefix(keys[0], "landscape/") {
+		t.Fatalf("want only the landscape video stored, got %v", keys)
+	}
+	boxes, err := mp4.ScanBoxes(bytes.NewReader(ut.uploader.objects[keys[0]]), int64(len(ut.uploader.objects[keys[0]])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []string{}
+	for _, box := range boxes {
+		types = append(types, box.Type)
+	}
+	if strings.Join(types, " ") != "ftyp moov mdat" {
+		t.Errorf("got top level boxes %v, want moov moved in front of mdat", types)
+	}
+}
+
+func TestInitMediaToolchainRefusesFake(t *testing.T) {
+	cfg := &apiConfig{}
+	if err := cfg.initMediaToolchain("fake", 1, time.Minute); err == nil {
+		t.Error("the fake toolchain is only for tests")
+	}
+}
+
+func TestUploadVideoTrailingBytes(t *testing.T) {
+	ut := newUploadTest(t)
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+
+	// some encoders pad the end of the file with a few zero bytes
+	rec := ut.upload(t, video.ID, token, "video/mp4", append(testMP4(), 0, 0, 0, 0))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Repair *uploadDiagnosis `json:"repair"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Repair == nil || !body.Repair.Repaired || body.Repair.Problem != problemTrailingBytes {
+		t.Fatalf("repair = %+v, want repaired trailing bytes", body.Repair)
+	}
+	keys := ut.uploader.keys()
+	for _, key := range keys {
+		if strings.HasSuffix(key, ".mp4") && !bytes.Equal(ut.uploader.objects[key], testMP4()) {
+			t.Error("the padding should be cut off")
+		}
+	}
+}
+
+func TestUploadVideoRemuxesWhatFFmpegDecodes(t *testing.T) {
+	ut := newUploadTest(t)
+	ut.cfg.transcoder = remuxingToolchain{newFakeToolchain()}
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+
+	rec := ut.upload(t, video.ID, token, "video/mp4", []byte{0, 0, 0, 4, 'f', 't', 'y', 'p', 0, 0})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Repair *uploadDiagnosis `json:"repair"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Repair == nil || !body.Repair.Repaired || body.Repair.Problem != problemInvalidContainer {
+		t.Fatalf("repair = %+v, want a remuxed container", body.Repair)
+	}
+}
+
+func TestUploadVideoRejectsWhatFFmpegCantDecode(t *testing.T) {
+	ut := newUploadTest(t)
+	fake := newFakeToolchain()
+	fake.DecodeErrors = []string{"moov atom not found"}
+	ut.cfg.transcoder = remuxingToolchain{fake}
+	userID, token := ut.createUser(t, "owner@example.com")
+	video := ut.createVideo(t, userID)
+
+	rec := ut.upload(t, video.ID, token, "video/mp4", []byte("definitely not a video"))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("upload returned %d, want 400: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Diagnosis *uploadDiagnosis `json:"diagnosis"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body.Diagnosis == nil || body.Diagnosis.Repairable || len(body.Diagnosis.Messages) != 1 {
+		t.Fatalf("diagnosis = %+v, want ffmpeg's complaint and no repair", body.Diagnosis)
+	}
+}
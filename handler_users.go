@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
@@ -27,13 +28,21 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	if err := validateEmail(params.Email); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, params.Password) {
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password, cfg.argon2Params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
 		return
 	}
 
-	user, err := cfg.db.CreateUser(database.CreateUserParams{
+	user, err := cfg.db.CreateUser(r.Context(), database.CreateUserParams{
 		Email:    params.Email,
 		Password: hashedPassword,
 	})
@@ -41,6 +50,12 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
 		return
 	}
+	cfg.auditSuccess(r, user.ID, auditUserCreate, auditTargetUser, user.Email)
+
+	err = cfg.sendVerificationEmail(r.Context(), *user)
+	if err != nil {
+		log.Println("handlerUsersCreate() unable to send verification email", err)
+	}
 
 	respondWithJSON(w, http.StatusCreated, user)
 }
@@ -1,15 +1,14 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
-	"os/exec"
+	"strconv"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/runner"
 	"github.com/google/uuid"
 )
 
@@ -89,46 +88,48 @@ type ffprobeJSON struct {
 		BitsPerSample  int    `json:"bits_per_sample,omitempty"`
 		InitialPadding int    `json:"initial_padding,omitempty"`
 	} `json:"streams"`
+	Format struct {
+		Filename   string `json:"filename"`
+		NbStreams  int    `json:"nb_streams"`
+		FormatName string `json:"format_name"`
+		StartTime  string `json:"start_time"`
+		Duration   string `json:"duration"`
+		Size       string `json:"size"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
 }
 
-func processVideoForFastStart(filePath string) (string, error) {
-	var stdBuffer, errBuffer bytes.Buffer
-	outputPath := fmt.Sprintf("%s.processing", filePath)
-	outCMD := exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputPath)
-	outCMD.Stdout = &stdBuffer
-	outCMD.Stderr = &errBuffer
-	err := outCMD.Run()
-	if err != nil {
-		log.Println("processVideoForFastStart() error updating mov attom of file", filePath, "error", errBuffer.String())
-		return "", err
+// mediaErrorStatus picks the response code for a failed ffmpeg or ffprobe run.
+func mediaErrorStatus(err error) int {
+	if runner.IsTimeout(err) {
+		return http.StatusGatewayTimeout
 	}
-	return outputPath, nil
+	return http.StatusInternalServerError
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	var stdBuffer, errBuffer bytes.Buffer
-	outCMD := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-	outCMD.Stdout = &stdBuffer
-	outCMD.Stderr = &errBuffer
-	err := outCMD.Run()
-	if err != nil {
-		log.Println("getVideoAspectRatio() command failed", errBuffer.String())
-		return "", err
-	}
-	var ffprobeOut ffprobeJSON
-	err = json.Unmarshal(stdBuffer.Bytes(), &ffprobeOut)
-	if err != nil {
-		return "", err
+func (p ffprobeJSON) hasAudio() bool {
+	for _, stream := range p.Streams {
+		if stream.CodecType == "audio" {
+			return true
+		}
 	}
+	return false
+}
+
+func (p ffprobeJSON) duration() (float64, error) {
+	return strconv.ParseFloat(p.Format.Duration, 64)
+}
+
+func (p ffprobeJSON) aspectRatio() string {
 	//aspectW, aspectH := calculateAspectRatio(ffprobeOut.Streams[0].Width, ffprobeOut.Streams[0].Height)
-	ratio := ffprobeOut.Streams[0].DisplayAspectRatio
+	ratio := p.Streams[0].DisplayAspectRatio
 	if ratio == "16:9" {
-		return "landscape", nil
+		return "landscape"
 	}
 	if ratio == "9:16" {
-		return "portrait", nil
+		return "portrait"
 	}
-	return "other", nil
+	return "other"
 }
 
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
@@ -136,31 +137,48 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosWrite)
+	if !ok {
 		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
 	params.UserID = userID
+	if params.Visibility == "" {
+		params.Visibility = database.VisibilityPrivate
+	}
+	if !database.ValidVisibility(params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be private, unlisted or public", nil)
+		return
+	}
+	if params.WorkspaceID != nil {
+		role, err := cfg.db.GetWorkspaceRole(r.Context(), *params.WorkspaceID, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check workspace membership", err)
+			return
+		}
+		if !workspaceRoleAllows(role, videoEdit) {
+			respondWithError(w, http.StatusForbidden, "Only workspace editors can add videos to it", nil)
+			return
+		}
+	}
 
-	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	video, err := cfg.db.CreateVideo(r.Context(), params.CreateVideoParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
+	cfg.auditSuccess(r, userID, auditVideoCreate, auditTargetVideo, video.ID.String())
+	_, err = cfg.createChaptersFromDescription(r.Context(), video)
+	if err != nil {
+		log.Println("handlerVideoMetaCreate() unable to create chapters from description", err)
+	}
 
 	respondWithJSON(w, http.StatusCreated, video)
 }
@@ -173,68 +191,129 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosWrite)
+	if !ok {
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.db.GetVideo(r.Context(), videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	if !cfg.checkVideoAccess(r.Context(), w, userID, video, videoDelete) {
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	err = cfg.deleteVideoMedia(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video files", err)
+		return
+	}
+	err = cfg.db.DeleteVideo(r.Context(), videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	cfg.auditSuccess(r, userID, auditVideoDelete, auditTargetVideo, videoID.String())
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+	video, ok := cfg.authorizedVideo(w, r, auth.ScopeVideosRead, videoView)
+	if !ok {
 		return
 	}
-
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.viewableVideo(r.Context(), video)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
 		return
 	}
 	respondWithJSON(w, http.StatusOK, video)
 }
 
+// handlerVideosRetrieve lists the personal videos of the caller, or the videos
+// of a workspace they're a member of when ?workspace_id= is given.
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	if workspaceIDString := r.URL.Query().Get("workspace_id"); workspaceIDString != "" {
+		workspaceID, err := uuid.Parse(workspaceIDString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid workspace ID", err)
+			return
+		}
+		if _, ok := cfg.workspaceMember(r.Context(), w, workspaceID, userID, database.WorkspaceRoleViewer); !ok {
+			return
+		}
+		videos, err := cfg.db.GetWorkspaceVideos(r.Context(), workspaceID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+			return
+		}
+		videos, err = cfg.viewableVideos(r.Context(), videos)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, videos)
+		return
+	}
+
+	videos, err := cfg.db.GetVideos(r.Context(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	videos, err = cfg.viewableVideos(r.Context(), videos)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
 		return
 	}
+	respondWithJSON(w, http.StatusOK, videos)
+}
 
-	videos, err := cfg.db.GetVideos(userID)
+// handlerVideosShared lists the videos other users shared with the caller.
+func (cfg *apiConfig) handlerVideosShared(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	videos, err := cfg.db.GetSharedVideos(r.Context(), userID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
+	for i := range videos {
+		videos[i].Video, err = cfg.viewableVideo(r.Context(), videos[i].Video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+// handlerVideosPublic lists the public videos of every user, it needs no login.
+func (cfg *apiConfig) handlerVideosPublic(w http.ResponseWriter, r *http.Request) {
+	videos, err := cfg.db.GetPublicVideos(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	videos, err = cfg.viewableVideos(r.Context(), videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
 	respondWithJSON(w, http.StatusOK, videos)
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoVisibilityUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Visibility string `json:"visibility"`
+	}
+
+	video, actorID, ok := cfg.authorizedVideoActor(w, r, auth.ScopeVideosWrite, videoManage)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !database.ValidVisibility(params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be private, unlisted or public", nil)
+		return
+	}
+
+	video.Visibility = params.Visibility
+	err = cfg.db.UpdateVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.audit(r, actorID, auditVideoVisibility, auditTargetVideo, video.ID.String(), database.AuditOutcomeSuccess, params.Visibility)
+	video, err = cfg.viewableVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerVideoSharesList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, auth.ScopeVideosRead, videoManage)
+	if !ok {
+		return
+	}
+
+	shares, err := cfg.db.GetVideoShares(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve shares", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, shares)
+}
+
+// handlerVideoShareSet shares the video with the user with the given email,
+// or changes what they may do with it.
+func (cfg *apiConfig) handlerVideoShareSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email      string `json:"email"`
+		Permission string `json:"permission"`
+	}
+
+	video, actorID, ok := cfg.authorizedVideoActor(w, r, auth.ScopeVideosWrite, videoManage)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !database.ValidSharePermission(params.Permission) {
+		respondWithError(w, http.StatusBadRequest, "Permission must be view or edit", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No user with that email", nil)
+		return
+	}
+	if user.ID == video.UserID {
+		respondWithError(w, http.StatusBadRequest, "The owner already has access", nil)
+		return
+	}
+
+	err = cfg.db.SetVideoShare(r.Context(), video.ID, user.ID, params.Permission)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't share video", err)
+		return
+	}
+	cfg.audit(r, actorID, auditVideoShare, auditTargetVideo, video.ID.String(), database.AuditOutcomeSuccess,
+		params.Permission+" for "+params.Email)
+
+	shares, err := cfg.db.GetVideoShares(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve shares", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, shares)
+}
+
+func (cfg *apiConfig) handlerVideoShareDelete(w http.ResponseWriter, r *http.Request) {
+	video, actorID, ok := cfg.authorizedVideoActor(w, r, auth.ScopeVideosWrite, videoManage)
+	if !ok {
+		return
+	}
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	deleted, err := cfg.db.DeleteVideoShare(r.Context(), video.ID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't stop sharing video", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "The video isn't shared with this user", nil)
+		return
+	}
+	cfg.audit(r, actorID, auditVideoUnshare, auditTargetVideo, video.ID.String(), database.AuditOutcomeSuccess, "user "+userID.String())
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const workspaceInvitationLifetime = 7 * 24 * time.Hour
+
+// workspaceRoleRank orders the workspace roles, each can do what the ones
+// below it can.
+var workspaceRoleRank = map[string]int{
+	database.WorkspaceRoleViewer: 1,
+	database.WorkspaceRoleEditor: 2,
+	database.WorkspaceRoleAdmin:  3,
+}
+
+// workspaceMember makes sure the user has at least minRole in the workspace
+// and returns their role. Workspaces look missing to non-members. It writes
+// the error response itself and reports whether to go on.
+func (cfg *apiConfig) workspaceMember(ctx context.Context, w http.ResponseWriter, workspaceID, userID uuid.UUID, minRole string) (string, bool) {
+	role, err := cfg.db.GetWorkspaceRole(ctx, workspaceID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check workspace membership", err)
+		return "", false
+	}
+	if role == "" {
+		respondWithError(w, http.StatusNotFound, "Workspace not found", nil)
+		return "", false
+	}
+	if workspaceRoleRank[role] < workspaceRoleRank[minRole] {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Workspace %ss can't do this", role), nil)
+		return "", false
+	}
+	return role, true
+}
+
+// authorizedWorkspace authenticates the caller for scope and makes sure they
+// have at least minRole in the workspace from the path.
+func (cfg *apiConfig) authorizedWorkspace(w http.ResponseWriter, r *http.Request, scope, minRole string) (uuid.UUID, uuid.UUID, bool) {
+	workspaceID, err := uuid.Parse(r.PathValue("workspaceID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid workspace ID", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+	userID, ok := cfg.authenticate(w, r, scope)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+	if _, ok := cfg.workspaceMember(r.Context(), w, workspaceID, userID, minRole); !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+	return workspaceID, userID, true
+}
+
+func (cfg *apiConfig) handlerWorkspaceCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeAdmin)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Workspace name is required", nil)
+		return
+	}
+
+	workspace, err := cfg.db.CreateWorkspace(r.Context(), params.Name, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create workspace", err)
+		return
+	}
+	cfg.auditSuccess(r, userID, auditWorkspaceCreate, auditTargetWorkspace, workspace.ID.String())
+	respondWithJSON(w, http.StatusCreated, database.UserWorkspace{
+		Workspace: workspace,
+		Role:      database.WorkspaceRoleAdmin,
+	})
+}
+
+// handlerWorkspacesList lists the workspaces of the caller with their role in
+// each.
+func (cfg *apiConfig) handlerWorkspacesList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	workspaces, err := cfg.db.GetUserWorkspaces(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve workspaces", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, workspaces)
+}
+
+func (cfg *apiConfig) handlerWorkspaceMembersList(w http.ResponseWriter, r *http.Request) {
+	workspaceID, _, ok := cfg.authorizedWorkspace(w, r, auth.ScopeVideosRead, database.WorkspaceRoleViewer)
+	if !ok {
+		return
+	}
+
+	members, err := cfg.db.GetWorkspaceMembers(r.Context(), workspaceID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve members", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+func (cfg *apiConfig) handlerWorkspaceMemberUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	workspaceID, userID, ok := cfg.authorizedWorkspace(w, r, auth.ScopeAdmin, database.WorkspaceRoleAdmin)
+	if !ok {
+		return
+	}
+	memberID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !database.ValidWorkspaceRole(params.Role) {
+		respondWithError(w, http.StatusBadRequest, "Role must be viewer, editor or admin", nil)
+		return
+	}
+
+	updated, err := cfg.db.SetWorkspaceMemberRole(r.Context(), workspaceID, memberID, params.Role)
+	if errors.Is(err, database.ErrLastWorkspaceAdmin) {
+		respondWithError(w, http.StatusConflict, "The workspace needs at least one admin", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update member", err)
+		return
+	}
+	if !updated {
+		respondWithError(w, http.StatusNotFound, "The user isn't a member of this workspace", nil)
+		return
+	}
+	cfg.audit(r, userID, auditWorkspaceMemberRole, auditTargetWorkspace, workspaceID.String(), database.AuditOutcomeSuccess,
+		fmt.Sprintf("user %s to %s", memberID, params.Role))
+
+	members, err := cfg.db.GetWorkspaceMembers(r.Context(), workspaceID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve members", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+// handlerWorkspaceMemberRemove removes a member. Admins can remove anyone,
+// other members can only leave. The videos of the member stay in the
+// workspace.
+func (cfg *apiConfig) handlerWorkspaceMemberRemove(w http.ResponseWriter, r *http.Request) {
+	workspaceID, userID, ok := cfg.authorizedWorkspace(w, r, auth.ScopeAdmin, database.WorkspaceRoleViewer)
+	if !ok {
+		return
+	}
+	memberID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	if memberID != userID {
+		if _, ok := cfg.workspaceMember(r.Context(), w, workspaceID, userID, database.WorkspaceRoleAdmin); !ok {
+			return
+		}
+	}
+
+	removed, err := cfg.db.RemoveWorkspaceMember(r.Context(), workspaceID, memberID)
+	if errors.Is(err, database.ErrLastWorkspaceAdmin) {
+		respondWithError(w, http.StatusConflict, "The workspace needs at least one admin", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove member", err)
+		return
+	}
+	if !removed {
+		respondWithError(w, http.StatusNotFound, "The user isn't a member of this workspace", nil)
+		return
+	}
+	cfg.audit(r, userID, auditWorkspaceMemberRemove, auditTargetWorkspace, workspaceID.String(), database.AuditOutcomeSuccess,
+		"user "+memberID.String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerWorkspaceInvitationCreate emails an invitation to join the
+// workspace. The address doesn't need an account yet.
+func (cfg *apiConfig) handlerWorkspaceInvitationCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	workspaceID, userID, ok := cfg.authorizedWorkspace(w, r, auth.ScopeAdmin, database.WorkspaceRoleAdmin)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Email = strings.TrimSpace(params.Email)
+	if params.Email == "" {
+		respondWithError(w, http.StatusBadRequest, "Email is required", nil)
+		return
+	}
+	if params.Role == "" {
+		params.Role = database.WorkspaceRoleViewer
+	}
+	if !database.ValidWorkspaceRole(params.Role) {
+		respondWithError(w, http.StatusBadRequest, "Role must be viewer, editor or admin", nil)
+		return
+	}
+
+	invitation, err := cfg.db.CreateWorkspaceInvitation(r.Context(), database.CreateWorkspaceInvitationParams{
+		WorkspaceID: workspaceID,
+		Email:       params.Email,
+		Role:        params.Role,
+		InvitedBy:   userID,
+		ExpiresAt:   time.Now().Add(workspaceInvitationLifetime),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create invitation", err)
+		return
+	}
+	cfg.audit(r, userID, auditWorkspaceInvite, auditTargetInvitation, invitation.ID.String(), database.AuditOutcomeSuccess,
+		fmt.Sprintf("%s as %s to workspace %s", invitation.Email, invitation.Role, workspaceID))
+
+	err = cfg.mailer.Send(r.Context(), mailer.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("You're invited to the %s workspace on Tubely", invitation.WorkspaceName),
+		Body: fmt.Sprintf("You've been invited to join the %s workspace on Tubely as %s.\n\n"+
+			"Open this link to accept, signing up with this email address if you haven't yet:\n\n%s\n\n"+
+			"The invitation expires in %s.\n",
+			invitation.WorkspaceName, invitation.Role, cfg.appLink("invitation", invitation.ID.String()), workspaceInvitationLifetime),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send invitation email", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, invitation)
+}
+
+func (cfg *apiConfig) handlerWorkspaceInvitationsList(w http.ResponseWriter, r *http.Request) {
+	workspaceID, _, ok := cfg.authorizedWorkspace(w, r, auth.ScopeVideosRead, database.WorkspaceRoleAdmin)
+	if !ok {
+		return
+	}
+
+	invitations, err := cfg.db.GetWorkspaceInvitations(r.Context(), workspaceID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve invitations", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, invitations)
+}
+
+func (cfg *apiConfig) handlerWorkspaceInvitationRevoke(w http.ResponseWriter, r *http.Request) {
+	workspaceID, userID, ok := cfg.authorizedWorkspace(w, r, auth.ScopeAdmin, database.WorkspaceRoleAdmin)
+	if !ok {
+		return
+	}
+	invitationID, err := uuid.Parse(r.PathValue("invitationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid invitation ID", err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokeWorkspaceInvitation(r.Context(), workspaceID, invitationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke invitation", err)
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "Invitation not found", nil)
+		return
+	}
+	cfg.auditSuccess(r, userID, auditWorkspaceInvitationRevoke, auditTargetInvitation, invitationID.String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerMyWorkspaceInvitations lists the open invitations sent to the email
+// of the caller.
+func (cfg *apiConfig) handlerMyWorkspaceInvitations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	invitations, err := cfg.db.GetInvitationsForEmail(r.Context(), user.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve invitations", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, invitations)
+}
+
+// handlerWorkspaceInvitationAccept joins the workspace of an invitation sent
+// to the caller. Their email has to be verified, or anyone could sign up with
+// the invited address and take the seat.
+func (cfg *apiConfig) handlerWorkspaceInvitationAccept(w http.ResponseWriter, r *http.Request) {
+	invitationID, err := uuid.Parse(r.PathValue("invitationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid invitation ID", err)
+		return
+	}
+	userID, ok := cfg.authenticate(w, r, auth.ScopeAdmin)
+	if !ok {
+		return
+	}
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	invitation, err := cfg.db.GetWorkspaceInvitation(r.Context(), invitationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get invitation", err)
+		return
+	}
+	// invitations for someone else look the same as missing ones
+	if invitation.ID == uuid.Nil || invitation.Email != user.Email {
+		respondWithError(w, http.StatusNotFound, "Invitation not found", nil)
+		return
+	}
+	if user.EmailVerifiedAt == nil {
+		respondWithError(w, http.StatusForbidden, "Verify your email address before accepting invitations", nil)
+		return
+	}
+	if invitation.RevokedAt != nil || invitation.AcceptedAt != nil || !invitation.ExpiresAt.After(time.Now()) {
+		respondWithError(w, http.StatusGone, "This invitation is no longer valid", nil)
+		return
+	}
+
+	accepted, err := cfg.db.AcceptWorkspaceInvitation(r.Context(), invitation, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't accept invitation", err)
+		return
+	}
+	if !accepted {
+		respondWithError(w, http.StatusGone, "This invitation is no longer valid", nil)
+		return
+	}
+	cfg.audit(r, userID, auditWorkspaceInvitationAccept, auditTargetInvitation, invitation.ID.String(), database.AuditOutcomeSuccess,
+		"workspace "+invitation.WorkspaceID.String())
+
+	workspace, err := cfg.db.GetWorkspace(r.Context(), invitation.WorkspaceID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get workspace", err)
+		return
+	}
+	role, err := cfg.db.GetWorkspaceRole(r.Context(), invitation.WorkspaceID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get workspace role", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, database.UserWorkspace{
+		Workspace: workspace,
+		Role:      role,
+	})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mp4"
+)
+
+const (
+	problemMissingMoov       = "missing_moov"
+	problemTruncatedMdat     = "truncated_mdat"
+	problemUndecodableFrames = "undecodable_frames"
+	problemInvalidContainer  = "invalid_container"
+	problemTrailingBytes     = "trailing_bytes"
+)
+
+// maxDiagnosisMessages caps how many decoder messages are sent back to the user
+const maxDiagnosisMessages = 20
+
+// uploadDiagnosis explains what is wrong with an uploaded file.
+type uploadDiagnosis struct {
+	Problem    string   `json:"problem"`
+	Detail     string   `json:"detail"`
+	Messages   []string `json:"messages,omitempty"`
+	Repairable bool     `json:"repairable"`
+	Repaired   bool     `json:"repaired"`
+}
+
+// diagnoseUpload checks the container structure of an upload and then decodes
+// it end to end. It returns nil when the file is healthy. Decoding is skipped
+// when the toolchain can't decode, leaving only the structural check.
+func (cfg *apiConfig) diagnoseUpload(ctx context.Context, filePath string) (*uploadDiagnosis, error) {
+	fp, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	stat, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := mp4.Probe(fp, stat.Size())
+	if err != nil {
+		diag := diagnoseStructure(fp, stat.Size(), err)
+		if diag.Repairable {
+			return diag, nil
+		}
+		// the pure Go parser is stricter than players are. When ffmpeg
+		// decodes the file anyway only the container is off, and remuxing
+		// it gives a file both can read
+		messages, err := cfg.transcoder.Decode(ctx, filePath, 0)
+		if errors.Is(err, errNeedsFFmpeg) {
+			return diag, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		diag.Messages = limitMessages(messages)
+		diag.Repairable = len(messages) == 0
+		return diag, nil
+	}
+
+	messages, err := cfg.transcoder.Decode(ctx, filePath, info.Duration)
+	if errors.Is(err, errNeedsFFmpeg) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	return &uploadDiagnosis{
+		Problem:    problemUndecodableFrames,
+		Detail:     "some frames of the video can't be decoded",
+		Messages:   limitMessages(messages),
+		Repairable: true,
+	}, nil
+}
+
+func limitMessages(messages []string) []string {
+	if len(messages) > maxDiagnosisMessages {
+		return messages[:maxDiagnosisMessages]
+	}
+	return messages
+}
+
+// diagnoseStructure classifies why mp4.Probe couldn't read a file.
+func diagnoseStructure(fp *os.File, size int64, probeErr error) *uploadDiagnosis {
+	if errors.Is(probeErr, mp4.ErrNoMoov) {
+		return &uploadDiagnosis{
+			Problem: problemMissingMoov,
+			Detail:  "the file has no movie header, it may not be an MP4 file",
+		}
+	}
+	if !errors.Is(probeErr, mp4.ErrTruncated) {
+		return &uploadDiagnosis{
+			Problem: problemInvalidContainer,
+			Detail:  probeErr.Error(),
+		}
+	}
+
+	// only a top level box running past the end of the file is an upload
+	// that was cut off. Fewer bytes than a box header after the last box are
+	// padding some encoders add, they're cut off again. Files too short for
+	// a single box header and damage inside a box are just broken
+	boxes, err := mp4.ScanBoxes(fp, size)
+	if errors.Is(err, mp4.ErrTruncated) && len(boxes) > 0 && boxes[len(boxes)-1].End() <= size {
+		return &uploadDiagnosis{
+			Problem:    problemTrailingBytes,
+			Detail:     fmt.Sprintf("the file has %d stray bytes after the last box", size-boxes[len(boxes)-1].End()),
+			Repairable: true,
+		}
+	}
+	if !errors.Is(err, mp4.ErrTruncated) || len(boxes) == 0 || boxes[len(boxes)-1].End() <= size {
+		return &uploadDiagnosis{
+			Problem: problemInvalidContainer,
+			Detail:  probeErr.Error(),
+		}
+	}
+	hasMoov := false
+	for _, box := range boxes {
+		if box.Type == "moov" && box.End() <= size {
+			hasMoov = true
+		}
+	}
+	last := boxes[len(boxes)-1]
+	missing := last.End() - size
+	switch {
+	case last.Type == "mdat" && hasMoov:
+		return &uploadDiagnosis{
+			Problem:    problemTruncatedMdat,
+			Detail:     fmt.Sprintf("the upload ended %d bytes before the end of the media data", missing),
+			Repairable: true,
+		}
+	case last.Type == "mdat" || last.Type == "moov":
+		return &uploadDiagnosis{
+			Problem: problemMissingMoov,
+			Detail:  fmt.Sprintf("the upload was cut off %d bytes early, before the movie header was complete", missing),
+		}
+	default:
+		return &uploadDiagnosis{
+			Problem: problemInvalidContainer,
+			Detail:  probeErr.Error(),
+		}
+	}
+}
+
+// diagnosisStatus is the response code for an upload that couldn't be
+// repaired. A file that isn't a readable MP4 at all is a bad request, one
+// that is but can't be played is unprocessable.
+func diagnosisStatus(diag *uploadDiagnosis) int {
+	if diag.Problem == problemInvalidContainer || diag.Problem == problemMissingMoov {
+		return http.StatusBadRequest
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// repairUpload tries to fix a damaged upload and checks the result again. It
+// returns the path of the repaired copy, or false when the file can't be saved.
+func (cfg *apiConfig) repairUpload(ctx context.Context, filePath string, diag *uploadDiagnosis) (string, bool) {
+	if !diag.Repairable {
+		return "", false
+	}
+	var repairedPath string
+	var err error
+	if diag.Problem == problemTrailingBytes {
+		repairedPath, err = trimTrailingBytes(filePath)
+	} else {
+		repairedPath, err = cfg.transcoder.Repair(ctx, filePath, diag.Problem == problemUndecodableFrames, 0)
+	}
+	if err != nil {
+		log.Println("repairUpload() repair failed", err)
+		diag.Repairable = false
+		return "", false
+	}
+	again, err := cfg.diagnoseUpload(ctx, repairedPath)
+	if err != nil || again != nil {
+		log.Println("repairUpload() repaired file is still damaged", err)
+		os.Remove(repairedPath)
+		diag.Repairable = false
+		return "", false
+	}
+	diag.Repaired = true
+	return repairedPath, true
+}
+
+// trimTrailingBytes writes a copy of the file that ends with its last
+// complete top level box.
+func trimTrailingBytes(filePath string) (string, error) {
+	in, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return "", err
+	}
+	boxes, _ := mp4.ScanBoxes(in, stat.Size())
+	if len(boxes) == 0 || boxes[len(boxes)-1].End() > stat.Size() {
+		return "", errors.New("the file doesn't end with a complete box")
+	}
+
+	outputPath := fmt.Sprintf("%s.repaired", filePath)
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, io.NewSectionReader(in, 0, boxes[len(boxes)-1].End()))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return "", err
+	}
+	return outputPath, nil
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Scopes limit what an API key may do. Bearer JWTs carry every scope.
+const (
+	ScopeVideosRead  = "videos:read"
+	ScopeVideosWrite = "videos:write"
+	ScopeAdmin       = "admin"
+)
+
+// Scopes lists every scope an API key can be granted.
+var Scopes = []string{ScopeVideosRead, ScopeVideosWrite, ScopeAdmin}
+
+const apiKeyTag = "tubely"
+
+var ErrMalformedAPIKey = errors.New("malformed api key")
+
+// MakeAPIKey creates a key of the form "tubely_<prefix>_<secret>". The prefix
+// is stored in the clear to find the key, only a hash of the whole key is kept.
+func MakeAPIKey() (key, prefix string, err error) {
+	prefixBytes := make([]byte, 4)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	prefix = hex.EncodeToString(prefixBytes)
+	return fmt.Sprintf("%s_%s_%s", apiKeyTag, prefix, hex.EncodeToString(secret)), prefix, nil
+}
+
+// APIKeyPrefix returns the lookup prefix of a key made by MakeAPIKey.
+func APIKeyPrefix(key string) (string, error) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyTag || parts[1] == "" || parts[2] == "" {
+		return "", ErrMalformedAPIKey
+	}
+	return parts[1], nil
+}
+
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func CheckAPIKeyHash(key, hash string) error {
+	if subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(hash)) != 1 {
+		return errors.New("api key doesn't match")
+	}
+	return nil
+}
+
+// ValidScope reports whether scope is one of Scopes.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SignAssetURL signs a link to the named file of the assets directory that
+// works until expiresAt.
+func SignAssetURL(secret, name string, expiresAt time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "tubely-asset\n%s\n%d", name, expiresAt.Unix())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CheckAssetURL checks a signature made by SignAssetURL and that it hasn't
+// expired.
+func CheckAssetURL(secret, name, signature string, expiresAt time.Time) error {
+	if !hmac.Equal([]byte(signature), []byte(SignAssetURL(secret, name, expiresAt))) {
+		return errors.New("asset signature doesn't match")
+	}
+	if expiresAt.Before(time.Now()) {
+		return errors.New("asset link has expired")
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Signing algorithms for access tokens. HS256 uses the shared JWT_SECRET, the
+// others use a SigningKey whose public half is published as a JWK.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const rsaKeyBits = 2048
+
+// SigningKey is an asymmetric key identified by the kid header of the tokens
+// it signs.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+}
+
+// GenerateSigningKey creates a new key for the RS256 or EdDSA algorithm.
+func GenerateSigningKey(algorithm string) (SigningKey, error) {
+	kid := make([]byte, 8)
+	if _, err := rand.Read(kid); err != nil {
+		return SigningKey{}, err
+	}
+	key := SigningKey{ID: hex.EncodeToString(kid), Algorithm: algorithm}
+
+	var err error
+	switch algorithm {
+	case AlgRS256:
+		key.Private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgEdDSA:
+		_, key.Private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return key, nil
+}
+
+// MarshalPrivateKey encodes the private key as a PKCS #8 PEM block.
+func (k SigningKey) MarshalPrivateKey() (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParseSigningKey is the inverse of MarshalPrivateKey.
+func ParseSigningKey(id, algorithm, privateKeyPEM string) (SigningKey, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return SigningKey{}, fmt.Errorf("key %s: no PEM data", id)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("key %s: %w", id, err)
+	}
+
+	key := SigningKey{ID: id, Algorithm: algorithm}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != AlgRS256 {
+			return SigningKey{}, fmt.Errorf("key %s: RSA key can't be used for %s", id, algorithm)
+		}
+		key.Private = private
+	case ed25519.PrivateKey:
+		if algorithm != AlgEdDSA {
+			return SigningKey{}, fmt.Errorf("key %s: Ed25519 key can't be used for %s", id, algorithm)
+		}
+		key.Private = private
+	default:
+		return SigningKey{}, fmt.Errorf("key %s: unsupported key type %T", id, parsed)
+	}
+	return key, nil
+}
+
+func (k SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgRS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+// JWK is the public half of a SigningKey in RFC 7517 form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k SigningKey) JWK() JWK {
+	jwk := JWK{KeyID: k.ID, Algorithm: k.Algorithm, Use: "sig"}
+	switch public := k.Private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+// KeyRing signs access tokens with one key and accepts tokens from any of its
+// verification keys, so old tokens stay valid while keys are rotated. Tokens
+// without a kid are HS256 tokens signed with the shared secret, which are only
+// accepted while the ring signs with it too: anyone holding the secret could
+// otherwise keep minting tokens that no key in the JWKS vouches for.
+type KeyRing struct {
+	secret  string
+	signer  *SigningKey
+	byID    map[string]SigningKey
+	ordered []SigningKey
+}
+
+// NewKeyRing builds a key ring that verifies with keys and signs with the key
+// whose ID is signingKID, or with secret over HS256 when signingKID is empty.
+func NewKeyRing(secret string, keys []SigningKey, signingKID string) (*KeyRing, error) {
+	kr := &KeyRing{
+		secret:  secret,
+		byID:    make(map[string]SigningKey, len(keys)),
+		ordered: keys,
+	}
+	for _, key := range keys {
+		kr.byID[key.ID] = key
+	}
+	if signingKID != "" {
+		key, ok := kr.byID[signingKID]
+		if !ok {
+			return nil, fmt.Errorf("signing key %s isn't in the key ring", signingKID)
+		}
+		kr.signer = &key
+	}
+	return kr, nil
+}
+
+// SigningAlgorithm is the algorithm new tokens are signed with.
+func (kr *KeyRing) SigningAlgorithm() string {
+	if kr.signer == nil {
+		return AlgHS256
+	}
+	return kr.signer.Algorithm
+}
+
+func (kr *KeyRing) MakeJWT(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	return kr.MakeToken(TokenTypeAccess, userID, expiresIn)
+}
+
+func (kr *KeyRing) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	return kr.ValidateToken(tokenString, TokenTypeAccess)
+}
+
+// MakeToken signs a token of any type for the user.
+func (kr *KeyRing) MakeToken(tokenType TokenType, userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Issuer:    string(tokenType),
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		Subject:   userID.String(),
+	}
+	if kr.signer == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(kr.secret))
+	}
+	token := jwt.NewWithClaims(kr.signer.method(), claims)
+	token.Header["kid"] = kr.signer.ID
+	return token.SignedString(kr.signer.Private)
+}
+
+// ValidateToken verifies a token made by MakeToken and checks its type.
+func (kr *KeyRing) ValidateToken(tokenString string, tokenType TokenType) (uuid.UUID, error) {
+	claimsStruct := jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(
+		tokenString,
+		&claimsStruct,
+		kr.verificationKey,
+		jwt.WithValidMethods([]string{AlgHS256, AlgRS256, AlgEdDSA}),
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return tokenUser(token, tokenType)
+}
+
+// verificationKey picks the key named by the kid header, and makes sure the
+// token's alg is the one that key was made for.
+func (kr *KeyRing) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if token.Method.Alg() != AlgHS256 || kr.secret == "" {
+			return nil, errors.New("token has no key ID")
+		}
+		if kr.signer != nil {
+			return nil, fmt.Errorf("%s tokens signed with the shared secret aren't accepted once %s signs", AlgHS256, kr.signer.Algorithm)
+		}
+		return []byte(kr.secret), nil
+	}
+	key, ok := kr.byID[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("key %s can't verify %s tokens", kid, token.Method.Alg())
+	}
+	return key.Private.Public(), nil
+}
+
+// JWKS lists the public keys other services need to verify access tokens.
+func (kr *KeyRing) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(kr.ordered))}
+	for _, key := range kr.ordered {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	return jwks
+}
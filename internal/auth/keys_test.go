@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestKeyRingSharedSecret(t *testing.T) {
+	const secret = "shared-secret"
+	userID := uuid.New()
+	hs256, err := NewKeyRing(secret, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretToken, err := hs256.MakeJWT(userID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algorithm := range []string{AlgRS256, AlgEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := GenerateSigningKey(algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// keys in the ring but still signing with the secret, as
+			// after rolling JWT_SIGNING_ALG back to HS256
+			verifying, err := NewKeyRing(secret, []SigningKey{key}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := verifying.ValidateJWT(secretToken); err != nil || got != userID {
+				t.Errorf("HS256 ring rejected a shared secret token: %v", err)
+			}
+
+			signing, err := NewKeyRing(secret, []SigningKey{key}, key.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := signing.ValidateJWT(secretToken); err == nil {
+				t.Errorf("%s ring accepted a token signed with the shared secret", algorithm)
+			}
+			token, err := signing.MakeJWT(userID, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := signing.ValidateJWT(token); err != nil || got != userID {
+				t.Errorf("%s ring rejected its own token: %v", algorithm, err)
+			}
+			if got, err := verifying.ValidateJWT(token); err != nil || got != userID {
+				t.Errorf("ring with the key rejected its token: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Argon2Params are the cost parameters of new argon2id hashes. They're stored
+// in every hash, so they can be raised without breaking existing ones.
+type Argon2Params struct {
+	// Memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var (
+	ErrMalformedPasswordHash = errors.New("malformed password hash")
+	ErrPasswordMismatch      = errors.New("password doesn't match the hash")
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// HashPassword hashes the password with argon2id into the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func HashPassword(password string, params Argon2Params) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// CheckPasswordHash compares the password with an argon2id hash, or with a
+// bcrypt hash from before argon2id was used.
+func CheckPasswordHash(password, hash string) error {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	}
+	params, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether the hash should be replaced by one made with
+// params, because it's a bcrypt hash or its argon2id parameters changed.
+func NeedsRehash(hash string, params Argon2Params) bool {
+	current, salt, _, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	current.SaltLength = uint32(len(salt))
+	return current != params
+}
+
+func decodeArgon2Hash(hash string) (Argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2Params{}, nil, nil, ErrMalformedPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return Argon2Params{}, nil, nil, ErrMalformedPasswordHash
+	}
+	if version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	var params Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, ErrMalformedPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, ErrMalformedPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2Params{}, nil, nil, ErrMalformedPasswordHash
+	}
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// MaxPasswordLength bounds how much a client can make the server hash.
+const MaxPasswordLength = 1024
+
+// PasswordPolicy decides which new passwords are acceptable. Existing
+// passwords aren't checked again.
+type PasswordPolicy struct {
+	MinLength int
+	// breached holds passwords known from data breaches, attackers try
+	// those first
+	breached map[string]struct{}
+}
+
+// LoadPasswordPolicy reads the breached passwords, one per line, from
+// breachedPath. An empty path skips the breached password check.
+func LoadPasswordPolicy(minLength int, breachedPath string) (PasswordPolicy, error) {
+	policy := PasswordPolicy{MinLength: minLength, breached: map[string]struct{}{}}
+	if breachedPath == "" {
+		return policy, nil
+	}
+	file, err := os.Open(breachedPath)
+	if err != nil {
+		return PasswordPolicy{}, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+			policy.breached[line] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return PasswordPolicy{}, err
+	}
+	return policy, nil
+}
+
+// Check returns an error that can be shown to the user when the password
+// isn't acceptable.
+func (p PasswordPolicy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes long", MaxPasswordLength)
+	}
+	if _, ok := p.breached[password]; ok {
+		return errors.New("this password appeared in a data breach, choose another one")
+	}
+	return nil
+}
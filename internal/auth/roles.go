@@ -0,0 +1,57 @@
+package auth
+
+// Roles say what a user may do beyond their own videos.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists every role, from least to most privileged.
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// Permissions are granted to roles. Owners may always read and edit their own
+// videos, these are only needed for other people's.
+const (
+	PermissionReadAnyVideo   = "videos:read_any"
+	PermissionEditAnyVideo   = "videos:edit_any"
+	PermissionDeleteAnyVideo = "videos:delete_any"
+	PermissionListUsers      = "users:list"
+	PermissionManageRoles    = "users:manage_roles"
+	PermissionResetDatabase  = "database:reset"
+	PermissionReadAuditLog   = "audit:read"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser: {},
+	// moderators can look at and take down anyone's videos
+	RoleModerator: {
+		PermissionReadAnyVideo,
+		PermissionDeleteAnyVideo,
+	},
+	RoleAdmin: {
+		PermissionReadAnyVideo,
+		PermissionEditAnyVideo,
+		PermissionDeleteAnyVideo,
+		PermissionListUsers,
+		PermissionManageRoles,
+		PermissionResetDatabase,
+		PermissionReadAuditLog,
+	},
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RoleHasPermission reports whether role grants permission. Unknown roles
+// have no permissions.
+func RoleHasPermission(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrMalformedUserToken = errors.New("malformed token")
+
+// UserTokenClaims are what an emailed token vouches for. Only the ID travels
+// in the token, the rest is looked up and the signature checked against it.
+type UserTokenClaims struct {
+	ID        uuid.UUID
+	Purpose   string
+	UserID    uuid.UUID
+	Email     string
+	ExpiresAt time.Time
+}
+
+// SignUserToken makes the token for an emailed link, "<id>.<signature>".
+func SignUserToken(secret string, claims UserTokenClaims) string {
+	return claims.ID.String() + "." + userTokenSignature(secret, claims)
+}
+
+// UserTokenID returns the ID a token claims to be, before it's checked.
+func UserTokenID(token string) (uuid.UUID, error) {
+	id, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return uuid.Nil, ErrMalformedUserToken
+	}
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, ErrMalformedUserToken
+	}
+	return parsed, nil
+}
+
+// CheckUserToken checks that token was signed for claims.
+func CheckUserToken(secret, token string, claims UserTokenClaims) error {
+	_, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrMalformedUserToken
+	}
+	expected := userTokenSignature(secret, claims)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("token signature doesn't match")
+	}
+	return nil
+}
+
+func userTokenSignature(secret string, claims UserTokenClaims) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "tubely-user-token\n%s\n%s\n%s\n%s\n%d",
+		claims.ID, claims.Purpose, claims.UserID, claims.Email, claims.ExpiresAt.Unix())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
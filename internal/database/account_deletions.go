@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AccountDeletion is a pending request to delete a user and everything they
+// own. It's kept until the last step is done, so a deletion that was cut
+// short can be picked up again.
+type AccountDeletion struct {
+	UserID      uuid.UUID `json:"user_id"`
+	Email       string    `json:"email"`
+	RequestedAt time.Time `json:"requested_at"`
+	Attempts    int       `json:"attempts"`
+	LastError   *string   `json:"last_error"`
+}
+
+// StartAccountDeletion records the deletion and locks the user out right
+// away: their password is cleared and their sessions and API keys revoked.
+// Starting a deletion that's already pending returns the pending one.
+func (c Client) StartAccountDeletion(ctx context.Context, userID uuid.UUID, email string) (AccountDeletion, error) {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return AccountDeletion{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO account_deletions (user_id, email, requested_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT(user_id) DO NOTHING
+	`, userID.String(), email, now)
+	if err != nil {
+		return AccountDeletion{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET password = '', updated_at = ? WHERE id = ?`, now, userID.String()); err != nil {
+		return AccountDeletion{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE refresh_tokens SET revoked_at = ?, updated_at = ? WHERE user_id = ? AND revoked_at IS NULL
+	`, now, now, userID.String()); err != nil {
+		return AccountDeletion{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE api_keys SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL
+	`, now, userID.String()); err != nil {
+		return AccountDeletion{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return AccountDeletion{}, err
+	}
+	return c.GetAccountDeletion(ctx, userID)
+}
+
+const accountDeletionColumns = `user_id, email, requested_at, attempts, last_error`
+
+func scanAccountDeletion(row rowScanner) (AccountDeletion, error) {
+	var deletion AccountDeletion
+	var userID string
+	err := row.Scan(&userID, &deletion.Email, &deletion.RequestedAt, &deletion.Attempts, &deletion.LastError)
+	if err != nil {
+		return AccountDeletion{}, err
+	}
+	if deletion.UserID, err = uuid.Parse(userID); err != nil {
+		return AccountDeletion{}, err
+	}
+	return deletion, nil
+}
+
+// GetAccountDeletion returns a zero AccountDeletion when the user's account
+// isn't being deleted.
+func (c Client) GetAccountDeletion(ctx context.Context, userID uuid.UUID) (AccountDeletion, error) {
+	row := c.db.QueryRowContext(ctx, `SELECT `+accountDeletionColumns+` FROM account_deletions WHERE user_id = ?`, userID.String())
+	deletion, err := scanAccountDeletion(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AccountDeletion{}, nil
+	}
+	return deletion, err
+}
+
+// GetAccountDeletions returns every pending deletion, oldest first.
+func (c Client) GetAccountDeletions(ctx context.Context) ([]AccountDeletion, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT `+accountDeletionColumns+` FROM account_deletions ORDER BY requested_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []AccountDeletion{}
+	for rows.Next() {
+		deletion, err := scanAccountDeletion(rows)
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, deletion)
+	}
+	return deletions, rows.Err()
+}
+
+func (c Client) RecordAccountDeletionFailure(ctx context.Context, userID uuid.UUID, message string) error {
+	_, err := c.db.ExecContext(ctx, `
+	UPDATE account_deletions SET attempts = attempts + 1, last_error = ? WHERE user_id = ?
+	`, message, userID.String())
+	return err
+}
+
+// FinishAccountDeletion deletes the user with their credentials, sessions and
+// memberships, and the workspaces nobody else is left in. Their own videos
+// have to be deleted first; the ones they uploaded to shared workspaces stay
+// there.
+func (c Client) FinishAccountDeletion(ctx context.Context, userID uuid.UUID) error {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id := userID.String()
+	statements := []string{
+		`DELETE FROM api_keys WHERE user_id = ?`,
+		`DELETE FROM refresh_tokens WHERE user_id = ?`,
+		`DELETE FROM user_identities WHERE user_id = ?`,
+		`DELETE FROM totp_credentials WHERE user_id = ?`,
+		`DELETE FROM recovery_codes WHERE user_id = ?`,
+		`DELETE FROM user_tokens WHERE user_id = ?`,
+		`DELETE FROM video_shares WHERE user_id = ?`,
+		`DELETE FROM workspace_invitations WHERE invited_by = ?`,
+		`DELETE FROM workspace_members WHERE user_id = ?`,
+		`DELETE FROM users WHERE id = ?`,
+		`DELETE FROM account_deletions WHERE user_id = ?`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, id); err != nil {
+			return err
+		}
+	}
+
+	// workspaces can only end up without members when their last one leaves
+	// this way, and their videos were deleted with the user's
+	emptyWorkspaces := `
+	SELECT id FROM workspaces
+	WHERE id NOT IN (SELECT workspace_id FROM workspace_members)
+	AND id NOT IN (SELECT workspace_id FROM videos WHERE workspace_id IS NOT NULL)
+	`
+	if _, err := tx.ExecContext(ctx, `DELETE FROM workspace_invitations WHERE workspace_id IN (`+emptyWorkspaces+`)`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM workspaces WHERE id IN (`+emptyWorkspaces+`)`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audit event outcomes.
+const (
+	AuditOutcomeSuccess = "success"
+	// AuditOutcomeFailure is a request that failed, like a wrong password
+	AuditOutcomeFailure = "failure"
+	// AuditOutcomeDenied is a request the actor wasn't allowed to make
+	AuditOutcomeDenied = "denied"
+)
+
+// AuditEvent records who did what to what, and whether it worked. Events are
+// only ever added, there's no way to change or delete them.
+type AuditEvent struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateAuditEventParams
+}
+
+type CreateAuditEventParams struct {
+	// ActorID is nil for anonymous requests
+	ActorID *uuid.UUID `json:"actor_id"`
+	Action  string     `json:"action"`
+	// TargetType and TargetID name what the action was taken on, like a
+	// "video" and its ID, or a "user" and their email
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	Outcome    string `json:"outcome"`
+	// Detail says why a request failed or was denied
+	Detail string `json:"detail"`
+}
+
+func (c Client) CreateAuditEvent(ctx context.Context, params CreateAuditEventParams) error {
+	query := `
+	INSERT INTO audit_events (
+		id,
+		created_at,
+		actor_id,
+		action,
+		target_type,
+		target_id,
+		ip,
+		user_agent,
+		outcome,
+		detail
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	var actorID *string
+	if params.ActorID != nil {
+		id := params.ActorID.String()
+		actorID = &id
+	}
+	_, err := c.db.ExecContext(ctx, query, uuid.New().String(), time.Now().UTC(), actorID, params.Action,
+		params.TargetType, params.TargetID, params.IP, params.UserAgent, params.Outcome, params.Detail)
+	return err
+}
+
+// AuditEventFilter selects audit events. Zero fields match everything.
+type AuditEventFilter struct {
+	ActorID    *uuid.UUID
+	Action     string
+	TargetType string
+	TargetID   string
+	Outcome    string
+	Since      *time.Time
+	Until      *time.Time
+}
+
+func (f AuditEventFilter) where() (string, []any) {
+	conditions := []string{}
+	args := []any{}
+	add := func(condition string, arg any) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+	if f.ActorID != nil {
+		add("actor_id = ?", f.ActorID.String())
+	}
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type = ?", f.TargetType)
+	}
+	if f.TargetID != "" {
+		add("target_id = ?", f.TargetID)
+	}
+	if f.Outcome != "" {
+		add("outcome = ?", f.Outcome)
+	}
+	if f.Since != nil {
+		add("created_at >= ?", f.Since.UTC())
+	}
+	if f.Until != nil {
+		add("created_at < ?", f.Until.UTC())
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+const auditEventColumns = `id, created_at, actor_id, action, target_type, target_id, ip, user_agent, outcome, detail`
+
+func scanAuditEvent(row rowScanner) (AuditEvent, error) {
+	var event AuditEvent
+	var id string
+	var actorID *string
+	err := row.Scan(&id, &event.CreatedAt, &actorID, &event.Action, &event.TargetType, &event.TargetID,
+		&event.IP, &event.UserAgent, &event.Outcome, &event.Detail)
+	if err != nil {
+		return AuditEvent{}, err
+	}
+	if event.ID, err = uuid.Parse(id); err != nil {
+		return AuditEvent{}, err
+	}
+	if actorID != nil {
+		actor, err := uuid.Parse(*actorID)
+		if err != nil {
+			return AuditEvent{}, err
+		}
+		event.ActorID = &actor
+	}
+	return event, nil
+}
+
+// GetAuditEvents returns at most limit events matching the filter, newest
+// first.
+func (c Client) GetAuditEvents(ctx context.Context, filter AuditEventFilter, limit int) ([]AuditEvent, error) {
+	where, args := filter.where()
+	rows, err := c.db.QueryContext(ctx, `SELECT `+auditEventColumns+` FROM audit_events `+where+`
+	ORDER BY created_at DESC, id DESC
+	LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// EachAuditEvent calls fn with every event matching the filter, oldest first,
+// without loading them all at once. It stops at the first error fn returns.
+func (c Client) EachAuditEvent(ctx context.Context, filter AuditEventFilter, fn func(AuditEvent) error) error {
+	where, args := filter.where()
+	rows, err := c.db.QueryContext(ctx, `SELECT `+auditEventColumns+` FROM audit_events `+where+`
+	ORDER BY created_at, id
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Chapter struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreateChapterParams
+}
+
+type CreateChapterParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// StartTime is the offset of the chapter from the start of the video in seconds
+	StartTime float64 `json:"start_time"`
+	Title     string  `json:"title"`
+}
+
+func (c Client) GetChapters(ctx context.Context, videoID uuid.UUID) ([]Chapter, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		start_time,
+		title
+	FROM chapters
+	WHERE video_id = ?
+	ORDER BY start_time ASC
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chapters := []Chapter{}
+	for rows.Next() {
+		var chapter Chapter
+		if err := rows.Scan(
+			&chapter.ID,
+			&chapter.CreatedAt,
+			&chapter.UpdatedAt,
+			&chapter.VideoID,
+			&chapter.StartTime,
+			&chapter.Title,
+		); err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, chapter)
+	}
+
+	return chapters, nil
+}
+
+func (c Client) CreateChapter(ctx context.Context, params CreateChapterParams) (Chapter, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO chapters (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		start_time,
+		title
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.ExecContext(ctx, query, id, params.VideoID, params.StartTime, params.Title)
+	if err != nil {
+		return Chapter{}, err
+	}
+
+	return c.GetChapter(ctx, id)
+}
+
+func (c Client) GetChapter(ctx context.Context, id uuid.UUID) (Chapter, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		start_time,
+		title
+	FROM chapters
+	WHERE id = ?
+	`
+
+	var chapter Chapter
+	err := c.db.QueryRowContext(ctx, query, id).Scan(
+		&chapter.ID,
+		&chapter.CreatedAt,
+		&chapter.UpdatedAt,
+		&chapter.VideoID,
+		&chapter.StartTime,
+		&chapter.Title)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Chapter{}, nil
+		}
+		return Chapter{}, err
+	}
+
+	return chapter, nil
+}
+
+func (c Client) UpdateChapter(ctx context.Context, chapter Chapter) error {
+	query := `
+	UPDATE chapters
+	SET
+		start_time = ?,
+		title = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, chapter.StartTime, chapter.Title, chapter.ID)
+	return err
+}
+
+func (c Client) DeleteChapter(ctx context.Context, id uuid.UUID) error {
+	query := `
+	DELETE FROM chapters
+	WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, id)
+	return err
+}
+
+func (c Client) DeleteChapters(ctx context.Context, videoID uuid.UUID) error {
+	query := `
+	DELETE FROM chapters
+	WHERE video_id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, videoID)
+	return err
+}
@@ -0,0 +1,954 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newPostgresClient connects to the Postgres database DB_URL names, in a
+// schema of its own that's dropped when the test ends, so the tables already
+// in the database aren't touched. The test is skipped when DB_URL isn't a
+// Postgres URL.
+func newPostgresClient(t *testing.T) Client {
+	t.Helper()
+	dbURL := os.Getenv("DB_URL")
+	if !strings.HasPrefix(dbURL, "postgres://") && !strings.HasPrefix(dbURL, "postgresql://") {
+		t.Skip("set DB_URL to a postgres:// URL to run against Postgres")
+	}
+	admin, err := sql.Open(string(dialectPostgres), dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "tubely_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	c, err := NewClient(u.String(), 5*time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.db.db.Close()
+		admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		admin.Close()
+	})
+	return c
+}
+
+// forEachDialect runs test with a migrated, empty database of each dialect.
+func forEachDialect(t *testing.T, test func(t *testing.T, c Client)) {
+	t.Helper()
+	for name, newClient := range map[string]func(*testing.T) Client{
+		"sqlite":   newSQLiteClient,
+		"postgres": newPostgresClient,
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := newClient(t)
+			if _, err := c.MigrateUp(context.Background(), 0); err != nil {
+				t.Fatal(err)
+			}
+			test(t, c)
+		})
+	}
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkReported(t *testing.T, what string, got bool, err error, want bool) {
+	t.Helper()
+	check(t, err)
+	if got != want {
+		t.Errorf("%s reported %t, want %t", what, got, want)
+	}
+}
+
+func createTestUser(t *testing.T, c Client, email string) *User {
+	t.Helper()
+	user, err := c.CreateUser(context.Background(), CreateUserParams{Email: email, Password: "hash"})
+	check(t, err)
+	return user
+}
+
+func createTestVideo(t *testing.T, c Client, userID uuid.UUID, workspaceID *uuid.UUID) Video {
+	t.Helper()
+	video, err := c.CreateVideo(context.Background(), CreateVideoParams{
+		Title:       "Boots",
+		Description: "A bear",
+		UserID:      userID,
+		WorkspaceID: workspaceID,
+	})
+	check(t, err)
+	return video
+}
+
+func createTestRefreshToken(t *testing.T, c Client, userID, familyID uuid.UUID) RefreshToken {
+	t.Helper()
+	token, err := c.CreateRefreshToken(context.Background(), CreateRefreshTokenParams{
+		Token:            uuid.NewString(),
+		UserID:           userID,
+		ExpiresAt:        time.Now().UTC().Add(time.Hour),
+		FamilyID:         familyID,
+		DeviceLabel:      "laptop",
+		UserAgent:        "curl/8.0",
+		IP:               "192.0.2.1",
+		SessionStartedAt: time.Now().UTC(),
+	})
+	check(t, err)
+	return token
+}
+
+func TestRebind(t *testing.T) {
+	for _, tc := range []struct {
+		dialect dialect
+		query   string
+		want    string
+	}{
+		{dialectSQLite, `SELECT * FROM users WHERE id = ? AND email = ?`, `SELECT * FROM users WHERE id = ? AND email = ?`},
+		{dialectPostgres, `SELECT * FROM users WHERE id = ? AND email = ?`, `SELECT * FROM users WHERE id = $1 AND email = $2`},
+		{dialectPostgres, `UPDATE users SET password = '' WHERE id = ?`, `UPDATE users SET password = '' WHERE id = $1`},
+		{dialectPostgres, `SELECT '?', title FROM videos WHERE id = ?`, `SELECT '?', title FROM videos WHERE id = $1`},
+		{dialectPostgres, `SELECT 'it''s?' FROM videos WHERE id = ?`, `SELECT 'it''s?' FROM videos WHERE id = $1`},
+		{dialectPostgres, `SELECT * FROM videos`, `SELECT * FROM videos`},
+	} {
+		if got := tc.dialect.rebind(tc.query); got != tc.want {
+			t.Errorf("%s rebind(%q) = %q, want %q", tc.dialect, tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestMigrationsConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		statuses, err := c.MigrationStatus(ctx)
+		check(t, err)
+		for _, status := range statuses {
+			if status.AppliedAt == nil {
+				t.Errorf("migration %d_%s wasn't applied", status.Version, status.Name)
+			}
+		}
+
+		reverted, err := c.MigrateDown(ctx, len(statuses))
+		check(t, err)
+		if len(reverted) != len(statuses) {
+			t.Errorf("reverted %d migrations, want %d", len(reverted), len(statuses))
+		}
+		applied, err := c.MigrateUp(ctx, 0)
+		check(t, err)
+		if len(applied) != len(statuses) {
+			t.Errorf("applied %d migrations, want %d", len(applied), len(statuses))
+		}
+	})
+}
+
+func TestUsersConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		user := createTestUser(t, c, "boots@example.com")
+		if user.Email != "boots@example.com" || user.Password != "hash" || user.Role != "user" || user.EmailVerifiedAt != nil {
+			t.Errorf("created %+v", user)
+		}
+		createTestUser(t, c, "lane@example.com")
+
+		users, err := c.GetUsers(ctx)
+		check(t, err)
+		if len(users) != 2 {
+			t.Errorf("got %d users, want 2", len(users))
+		}
+		byEmail, err := c.GetUserByEmail(ctx, "boots@example.com")
+		check(t, err)
+		if byEmail.ID != user.ID {
+			t.Errorf("got user %s by email, want %s", byEmail.ID, user.ID)
+		}
+		missing, err := c.GetUserByEmail(ctx, "nobody@example.com")
+		check(t, err)
+		if missing.ID != uuid.Nil {
+			t.Errorf("got user %s for an unknown email", missing.ID)
+		}
+		if missingUser, err := c.GetUser(ctx, uuid.New()); err != nil || missingUser != nil {
+			t.Errorf("got %+v, %v for an unknown ID", missingUser, err)
+		}
+
+		verified, err := c.SetEmailVerified(ctx, user.ID, "old@example.com")
+		checkReported(t, "verifying another address", verified, err, false)
+		verified, err = c.SetEmailVerified(ctx, user.ID, "boots@example.com")
+		checkReported(t, "verifying the address", verified, err, true)
+		check(t, c.UpdateUserPassword(ctx, user.ID, "new-hash"))
+		user, err = c.GetUser(ctx, user.ID)
+		check(t, err)
+		if user.Password != "new-hash" || user.EmailVerifiedAt == nil {
+			t.Errorf("got %+v after updating the password", user)
+		}
+
+		check(t, c.UpdateUserEmail(ctx, user.ID, "boots@example.org"))
+		changed, err := c.SetUserRole(ctx, user.ID, "admin")
+		checkReported(t, "setting the role", changed, err, true)
+		changed, err = c.SetUserRole(ctx, uuid.New(), "admin")
+		checkReported(t, "setting the role of an unknown user", changed, err, false)
+		user, err = c.GetUser(ctx, user.ID)
+		check(t, err)
+		if user.Email != "boots@example.org" || user.EmailVerifiedAt != nil || user.Role != "admin" {
+			t.Errorf("got %+v after changing email and role", user)
+		}
+
+		check(t, c.DeleteUser(ctx, user.ID))
+		if deleted, err := c.GetUser(ctx, user.ID); err != nil || deleted != nil {
+			t.Errorf("got %+v, %v after deleting the user", deleted, err)
+		}
+	})
+}
+
+func TestRefreshTokensConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		user := createTestUser(t, c, "boots@example.com")
+		family := uuid.New()
+		token := createTestRefreshToken(t, c, user.ID, family)
+		if token.UserID != user.ID || token.FamilyID != family || token.DeviceLabel != "laptop" || token.RevokedAt != nil {
+			t.Errorf("created %+v", token)
+		}
+		owner, err := c.GetUserByRefreshToken(ctx, token.Token)
+		check(t, err)
+		if owner == nil || owner.ID != user.ID {
+			t.Errorf("got owner %+v, want %s", owner, user.ID)
+		}
+		if missing, err := c.GetRefreshToken(ctx, "unknown"); err != nil || missing.Token != "" {
+			t.Errorf("got %+v, %v for an unknown token", missing, err)
+		}
+
+		params := CreateRefreshTokenParams{
+			Token:            uuid.NewString(),
+			UserID:           user.ID,
+			ExpiresAt:        time.Now().UTC().Add(time.Hour),
+			FamilyID:         family,
+			SessionStartedAt: token.SessionStartedAt,
+		}
+		rotated, err := c.RotateRefreshToken(ctx, token.Token, params)
+		check(t, err)
+		if rotated.Token != params.Token || rotated.FamilyID != family {
+			t.Errorf("rotated to %+v", rotated)
+		}
+		old, err := c.GetRefreshToken(ctx, token.Token)
+		check(t, err)
+		if old.RevokedAt == nil || old.ReplacedBy == nil || *old.ReplacedBy != params.Token {
+			t.Errorf("got %+v after rotating it", old)
+		}
+		params.Token = uuid.NewString()
+		if _, err := c.RotateRefreshToken(ctx, token.Token, params); !errors.Is(err, ErrRefreshTokenRevoked) {
+			t.Errorf("rotating a rotated token returned %v, want %v", err, ErrRefreshTokenRevoked)
+		}
+
+		other := uuid.New()
+		createTestRefreshToken(t, c, user.ID, other)
+		sessions, err := c.GetSessions(ctx, user.ID)
+		check(t, err)
+		if len(sessions) != 2 {
+			t.Fatalf("got %d sessions, want 2", len(sessions))
+		}
+		check(t, c.RevokeOtherSessions(ctx, user.ID, family))
+		sessions, err = c.GetSessions(ctx, user.ID)
+		check(t, err)
+		if len(sessions) != 1 || sessions[0].ID != family {
+			t.Errorf("got sessions %+v, want only %s", sessions, family)
+		}
+		revoked, err := c.RevokeSession(ctx, user.ID, other)
+		checkReported(t, "revoking a revoked session", revoked, err, false)
+		revoked, err = c.RevokeSession(ctx, user.ID, family)
+		checkReported(t, "revoking the session", revoked, err, true)
+
+		third := createTestRefreshToken(t, c, user.ID, uuid.New())
+		check(t, c.RevokeRefreshTokenFamily(ctx, third.FamilyID))
+		fourth := createTestRefreshToken(t, c, user.ID, uuid.New())
+		check(t, c.RevokeRefreshToken(ctx, fourth.Token))
+		createTestRefreshToken(t, c, user.ID, uuid.New())
+		check(t, c.RevokeAllSessions(ctx, user.ID))
+		sessions, err = c.GetSessions(ctx, user.ID)
+		check(t, err)
+		if len(sessions) != 0 {
+			t.Errorf("got %d sessions after revoking them all", len(sessions))
+		}
+
+		check(t, c.DeleteRefreshToken(ctx, fourth.Token))
+		if deleted, err := c.GetRefreshToken(ctx, fourth.Token); err != nil || deleted.Token != "" {
+			t.Errorf("got %+v, %v after deleting the token", deleted, err)
+		}
+	})
+}
+
+func TestRotateLegacyRefreshTokenConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		user := createTestUser(t, c, "boots@example.com")
+		// tokens issued before families existed have no family_id
+		_, err := c.db.ExecContext(ctx, `
+			INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
+			VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+		`, "legacy", user.ID.String(), time.Now().UTC().Add(time.Hour))
+		check(t, err)
+
+		family := uuid.New()
+		_, err = c.RotateRefreshToken(ctx, "legacy", CreateRefreshTokenParams{
+			Token:     "rotated",
+			UserID:    user.ID,
+			ExpiresAt: time.Now().UTC().Add(time.Hour),
+			FamilyID:  family,
+		})
+		check(t, err)
+		legacy, err := c.GetRefreshToken(ctx, "legacy")
+		check(t, err)
+		if legacy.FamilyID != family {
+			t.Errorf("the rotated legacy token has family %s, want %s", legacy.FamilyID, family)
+		}
+	})
+}
+
+func TestAPIKeysConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		user := createTestUser(t, c, "boots@example.com")
+		expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+		key, err := c.CreateAPIKey(ctx, CreateAPIKeyParams{
+			UserID:    user.ID,
+			Name:      "ci",
+			Prefix:    "tk_abc",
+			KeyHash:   "hash",
+			Scopes:    []string{"videos:read", "videos:write"},
+			ExpiresAt: &expiresAt,
+		})
+		check(t, err)
+		if key.UserID != user.ID || len(key.Scopes) != 2 || key.ExpiresAt == nil || !key.ExpiresAt.Equal(expiresAt) {
+			t.Errorf("created %+v", key)
+		}
+		byPrefix, err := c.GetAPIKeyByPrefix(ctx, "tk_abc")
+		check(t, err)
+		if byPrefix.ID != key.ID {
+			t.Errorf("got key %s by prefix, want %s", byPrefix.ID, key.ID)
+		}
+		keys, err := c.GetAPIKeys(ctx, user.ID)
+		check(t, err)
+		if len(keys) != 1 {
+			t.Errorf("got %d keys, want 1", len(keys))
+		}
+
+		check(t, c.TouchAPIKey(ctx, key.ID))
+		revoked, err := c.RevokeAPIKey(ctx, uuid.New(), key.ID)
+		checkReported(t, "revoking the key of another user", revoked, err, false)
+		revoked, err = c.RevokeAPIKey(ctx, user.ID, key.ID)
+		checkReported(t, "revoking the key", revoked, err, true)
+		key, err = c.GetAPIKey(ctx, key.ID)
+		check(t, err)
+		if key.LastUsedAt == nil || key.RevokedAt == nil {
+			t.Errorf("got %+v after using and revoking it", key)
+		}
+	})
+}
+
+func TestJWTKeysConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		first, err := c.RotateJWTKey(ctx, CreateJWTKeyParams{ID: "first", Algorithm: "EdDSA", PrivateKey: "pem"}, time.Now())
+		check(t, err)
+		retireAt := time.Now().UTC().Add(time.Hour)
+		_, err = c.RotateJWTKey(ctx, CreateJWTKeyParams{ID: "second", Algorithm: "EdDSA", PrivateKey: "pem"}, retireAt)
+		check(t, err)
+
+		keys, err := c.GetJWTKeys(ctx)
+		check(t, err)
+		if len(keys) != 2 || keys[0].ID != "second" || keys[0].RetiredAt != nil {
+			t.Fatalf("got keys %+v, want the active second key first", keys)
+		}
+		if keys[1].RetiredAt == nil || !keys[1].RetiredAt.Equal(retireAt) {
+			t.Errorf("the first key retires at %v, want %v", keys[1].RetiredAt, retireAt)
+		}
+
+		check(t, c.DeleteJWTKey(ctx, first.ID))
+		keys, err = c.GetJWTKeys(ctx)
+		check(t, err)
+		if len(keys) != 1 {
+			t.Errorf("got %d keys after deleting one, want 1", len(keys))
+		}
+	})
+}
+
+func TestOIDCConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		now := time.Now().UTC()
+		for _, login := range []OIDCLogin{
+			{State: "pending", Nonce: "n", CodeVerifier: "v", DeviceLabel: "phone", CreatedAt: now, ExpiresAt: now.Add(time.Minute)},
+			{State: "expired", Nonce: "n", CodeVerifier: "v", CreatedAt: now, ExpiresAt: now.Add(-time.Minute)},
+		} {
+			check(t, c.CreateOIDCLogin(ctx, login))
+		}
+		check(t, c.DeleteExpiredOIDCLogins(ctx))
+		if login, err := c.TakeOIDCLogin(ctx, "expired"); err != nil || login != nil {
+			t.Errorf("got %+v, %v for an expired login", login, err)
+		}
+		login, err := c.TakeOIDCLogin(ctx, "pending")
+		check(t, err)
+		if login == nil || login.DeviceLabel != "phone" {
+			t.Fatalf("took %+v", login)
+		}
+		if again, err := c.TakeOIDCLogin(ctx, "pending"); err != nil || again != nil {
+			t.Errorf("took %+v, %v a second time", again, err)
+		}
+
+		user := createTestUser(t, c, "boots@example.com")
+		identity, err := c.CreateUserIdentity(ctx, CreateUserIdentityParams{
+			UserID:  user.ID,
+			Issuer:  "https://idp.example",
+			Subject: "boots",
+			Email:   "boots@example.com",
+		})
+		check(t, err)
+		if identity.UserID != user.ID || identity.ID == uuid.Nil {
+			t.Errorf("created %+v", identity)
+		}
+		missing, err := c.GetUserIdentity(ctx, "https://idp.example", "lane")
+		check(t, err)
+		if missing.ID != uuid.Nil {
+			t.Errorf("got identity %+v for an unknown subject", missing)
+		}
+	})
+}
+
+func TestTOTPConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		user := createTestUser(t, c, "boots@example.com")
+		check(t, c.SetPendingTOTP(ctx, user.ID, "secret"))
+		cred, err := c.GetTOTPCredential(ctx, user.ID)
+		check(t, err)
+		if cred.UserID != user.ID || cred.Secret != "secret" || cred.EnabledAt != nil {
+			t.Errorf("got pending %+v", cred)
+		}
+
+		// steps are 64 bit, Postgres stores them as BIGINT
+		step := int64(1) << 40
+		check(t, c.EnableTOTP(ctx, user.ID, step, []string{"code-one", "code-two"}))
+		ok, err := c.RecordTOTPSuccess(ctx, user.ID, step)
+		checkReported(t, "reusing the step", ok, err, false)
+		ok, err = c.RecordTOTPSuccess(ctx, user.ID, step+1)
+		checkReported(t, "the next step", ok, err, true)
+
+		lockedUntil := time.Now().UTC().Add(time.Minute)
+		check(t, c.RecordTOTPFailure(ctx, user.ID, 2, lockedUntil))
+		check(t, c.RecordTOTPFailure(ctx, user.ID, 2, lockedUntil))
+		cred, err = c.GetTOTPCredential(ctx, user.ID)
+		check(t, err)
+		if cred.EnabledAt == nil || cred.LastStep != step+1 || cred.LockedUntil == nil {
+			t.Errorf("got %+v after two failures", cred)
+		}
+
+		used, err := c.UseRecoveryCode(ctx, user.ID, "code-one")
+		checkReported(t, "using the recovery code", used, err, true)
+		used, err = c.UseRecoveryCode(ctx, user.ID, "code-one")
+		checkReported(t, "using the recovery code again", used, err, false)
+		cred, err = c.GetTOTPCredential(ctx, user.ID)
+		check(t, err)
+		if cred.LockedUntil != nil {
+			t.Error("a recovery code should lift the lock")
+		}
+
+		check(t, c.DeleteTOTP(ctx, user.ID))
+		cred, err = c.GetTOTPCredential(ctx, user.ID)
+		check(t, err)
+		if cred.UserID != uuid.Nil {
+			t.Errorf("got %+v after deleting it", cred)
+		}
+		used, err = c.UseRecoveryCode(ctx, user.ID, "code-two")
+		checkReported(t, "using a deleted recovery code", used, err, false)
+	})
+}
+
+func TestUserTokensConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		user := createTestUser(t, c, "boots@example.com")
+		params := CreateUserTokenParams{
+			UserID:    user.ID,
+			Purpose:   "verify_email",
+			Email:     "boots@example.com",
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+		first, err := c.CreateUserToken(ctx, params)
+		check(t, err)
+		second, err := c.CreateUserToken(ctx, params)
+		check(t, err)
+		if first.UserID != user.ID || !first.ExpiresAt.Equal(params.ExpiresAt.Truncate(time.Second)) || first.UsedAt != nil {
+			t.Errorf("created %+v", first)
+		}
+
+		consumed, err := c.ConsumeUserToken(ctx, first)
+		checkReported(t, "consuming the token", consumed, err, true)
+		consumed, err = c.ConsumeUserToken(ctx, first)
+		checkReported(t, "consuming the token again", consumed, err, false)
+		second, err = c.GetUserToken(ctx, second.ID)
+		check(t, err)
+		if second.UsedAt == nil {
+			t.Error("the other token for the same purpose should be used up too")
+		}
+
+		params.Purpose = "reset_password"
+		reset, err := c.CreateUserToken(ctx, params)
+		check(t, err)
+		check(t, c.UpdateUserEmail(ctx, user.ID, "boots@example.org"))
+		if reset, err := c.GetUserToken(ctx, reset.ID); err != nil || reset.ID != uuid.Nil {
+			t.Errorf("got %+v, %v for a token sent to the old address", reset, err)
+		}
+		if first, err := c.GetUserToken(ctx, first.ID); err != nil || first.ID == uuid.Nil {
+			t.Errorf("got %+v, %v for a used token after changing the email", first, err)
+		}
+	})
+}
+
+func TestLoginAttemptsConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		now := time.Now().UTC()
+		for want := 1; want <= 2; want++ {
+			failures, err := c.RecordLoginFailure(ctx, "boots@example.com", now, now.Add(-time.Hour))
+			check(t, err)
+			if failures != want {
+				t.Errorf("got %d failures, want %d", failures, want)
+			}
+		}
+		failures, err := c.RecordLoginFailure(ctx, "boots@example.com", now.Add(time.Second), now.Add(time.Millisecond))
+		check(t, err)
+		if failures != 1 {
+			t.Errorf("got %d failures after a quiet period, want 1", failures)
+		}
+
+		check(t, c.BlockLogin(ctx, "boots@example.com", now.Add(time.Hour)))
+		check(t, c.DeleteStaleLoginAttempts(ctx, now.Add(time.Minute)))
+		attempt, err := c.GetLoginAttempt(ctx, "boots@example.com")
+		check(t, err)
+		if attempt.Failures != 1 || attempt.BlockedUntil == nil {
+			t.Errorf("got %+v, a blocked key isn't stale", attempt)
+		}
+
+		check(t, c.ClearLoginAttempts(ctx, "boots@example.com"))
+		attempt, err = c.GetLoginAttempt(ctx, "boots@example.com")
+		check(t, err)
+		if attempt.Key != "" {
+			t.Errorf("got %+v after clearing it", attempt)
+		}
+	})
+}
+
+func TestVideosConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		user := createTestUser(t, c, "boots@example.com")
+		video := createTestVideo(t, c, user.ID, nil)
+		if video.UserID != user.ID || video.Visibility != VisibilityPrivate || video.WorkspaceID != nil || video.VideoURL != nil {
+			t.Errorf("created %+v", video)
+		}
+
+		videoURL, audioURL := "https://cdn.example/boots.mp4", "https://cdn.example/boots.m4a"
+		duration := 12.5
+		video.VideoURL, video.AudioURL, video.Duration = &videoURL, &audioURL, &duration
+		video.Visibility = VisibilityPublic
+		check(t, c.UpdateVideo(ctx, video))
+		got, err := c.GetVideo(ctx, video.ID)
+		check(t, err)
+		if got.VideoURL == nil || *got.VideoURL != videoURL || got.AudioURL == nil || *got.AudioURL != audioURL ||
+			got.Duration == nil || *got.Duration != duration || got.Visibility != VisibilityPublic {
+			t.Errorf("got %+v after updating it", got)
+		}
+		createTestVideo(t, c, user.ID, nil)
+
+		for name, list := range map[string]func() ([]Video, error){
+			"GetVideos":       func() ([]Video, error) { return c.GetVideos(ctx, user.ID) },
+			"GetAllVideos":    func() ([]Video, error) { return c.GetAllVideos(ctx) },
+			"GetPublicVideos": func() ([]Video, error) { return c.GetPublicVideos(ctx) },
+		} {
+			videos, err := list()
+			check(t, err)
+			want := 2
+			if name == "GetPublicVideos" {
+				want = 1
+			}
+			if len(videos) != want {
+				t.Errorf("%s returned %d videos, want %d", name, len(videos), want)
+			}
+		}
+		if missing, err := c.GetVideo(ctx, uuid.New()); err != nil || missing.ID != uuid.Nil {
+			t.Errorf("got %+v, %v for an unknown ID", missing, err)
+		}
+	})
+}
+
+func TestVideoChildrenConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		owner := createTestUser(t, c, "boots@example.com")
+		viewer := createTestUser(t, c, "lane@example.com")
+		video := createTestVideo(t, c, owner.ID, nil)
+
+		intro, err := c.CreateChapter(ctx, CreateChapterParams{VideoID: video.ID, StartTime: 0, Title: "Intro"})
+		check(t, err)
+		_, err = c.CreateChapter(ctx, CreateChapterParams{VideoID: video.ID, StartTime: 42.5, Title: "Outro"})
+		check(t, err)
+		intro.Title = "Hello"
+		check(t, c.UpdateChapter(ctx, intro))
+		chapters, err := c.GetChapters(ctx, video.ID)
+		check(t, err)
+		if len(chapters) != 2 || chapters[0].Title != "Hello" || chapters[1].StartTime != 42.5 {
+			t.Errorf("got chapters %+v", chapters)
+		}
+		check(t, c.DeleteChapter(ctx, intro.ID))
+		if deleted, err := c.GetChapter(ctx, intro.ID); err != nil || deleted.ID != uuid.Nil {
+			t.Errorf("got %+v, %v after deleting the chapter", deleted, err)
+		}
+
+		frameURL := "https://cdn.example/frame.jpg"
+		cut, err := c.CreateSceneCut(ctx, CreateSceneCutParams{VideoID: video.ID, Time: 3.25, Score: 0.8, FrameURL: &frameURL})
+		check(t, err)
+		if got, err := c.GetSceneCut(ctx, cut.ID); err != nil || got.Time != 3.25 || got.FrameURL == nil {
+			t.Errorf("got %+v, %v", got, err)
+		}
+		cuts, err := c.GetSceneCuts(ctx, video.ID)
+		check(t, err)
+		if len(cuts) != 1 {
+			t.Errorf("got %d scene cuts, want 1", len(cuts))
+		}
+		cuts, err = c.ReplaceSceneCuts(ctx, video.ID, []CreateSceneCutParams{
+			{Time: 1, Score: 0.4},
+			{Time: 2, Score: 0.6, FrameURL: &frameURL},
+		})
+		check(t, err)
+		if len(cuts) != 2 || cuts[0].ID == cut.ID || cuts[0].VideoID != video.ID {
+			t.Errorf("got scene cuts %+v after replacing them", cuts)
+		}
+
+		check(t, c.SetVideoShare(ctx, video.ID, viewer.ID, SharePermissionView))
+		check(t, c.SetVideoShare(ctx, video.ID, viewer.ID, SharePermissionEdit))
+		permission, err := c.GetVideoShare(ctx, video.ID, viewer.ID)
+		check(t, err)
+		if permission != SharePermissionEdit {
+			t.Errorf("got permission %q, want %q", permission, SharePermissionEdit)
+		}
+		shares, err := c.GetVideoShares(ctx, video.ID)
+		check(t, err)
+		if len(shares) != 1 || shares[0].Email != "lane@example.com" {
+			t.Errorf("got shares %+v", shares)
+		}
+		shared, err := c.GetSharedVideos(ctx, viewer.ID)
+		check(t, err)
+		if len(shared) != 1 || shared[0].ID != video.ID || shared[0].Permission != SharePermissionEdit {
+			t.Errorf("got shared videos %+v", shared)
+		}
+		deleted, err := c.DeleteVideoShare(ctx, video.ID, owner.ID)
+		checkReported(t, "unsharing with a user it isn't shared with", deleted, err, false)
+
+		maxViews := 1
+		link, err := c.CreateShareLink(ctx, CreateShareLinkParams{VideoID: video.ID, ExpiresAt: time.Now().Add(time.Hour), MaxViews: &maxViews})
+		check(t, err)
+		if link.MaxViews == nil || *link.MaxViews != 1 || link.HasPassword() {
+			t.Errorf("created %+v", link)
+		}
+		counted, err := c.CountShareLinkView(ctx, link.ID)
+		checkReported(t, "the first view", counted, err, true)
+		counted, err = c.CountShareLinkView(ctx, link.ID)
+		checkReported(t, "a view over the limit", counted, err, false)
+		if got, err := c.GetShareLink(ctx, link.ID); err != nil || got.Views != 1 {
+			t.Errorf("got %+v, %v after two views, want one counted", got, err)
+		}
+		other, err := c.CreateShareLink(ctx, CreateShareLinkParams{VideoID: video.ID, ExpiresAt: time.Now().Add(time.Hour), PasswordHash: "hash"})
+		check(t, err)
+		revoked, err := c.RevokeShareLink(ctx, video.ID, other.ID)
+		checkReported(t, "revoking the link", revoked, err, true)
+		links, err := c.GetShareLinks(ctx, video.ID)
+		check(t, err)
+		if len(links) != 2 {
+			t.Errorf("got %d share links, want 2", len(links))
+		}
+
+		check(t, c.DeleteVideo(ctx, video.ID))
+		checkVideoChildrenDeleted(t, c, video.ID)
+
+		// the handlers clear the children of a video one kind at a time too
+		video = createTestVideo(t, c, owner.ID, nil)
+		_, err = c.CreateChapter(ctx, CreateChapterParams{VideoID: video.ID, Title: "Intro"})
+		check(t, err)
+		_, err = c.CreateSceneCut(ctx, CreateSceneCutParams{VideoID: video.ID, Time: 1, Score: 0.5})
+		check(t, err)
+		check(t, c.SetVideoShare(ctx, video.ID, viewer.ID, SharePermissionView))
+		_, err = c.CreateShareLink(ctx, CreateShareLinkParams{VideoID: video.ID, ExpiresAt: time.Now().Add(time.Hour)})
+		check(t, err)
+		check(t, c.DeleteChapters(ctx, video.ID))
+		check(t, c.DeleteSceneCuts(ctx, video.ID))
+		check(t, c.DeleteVideoShares(ctx, video.ID))
+		check(t, c.DeleteShareLinks(ctx, video.ID))
+		checkVideoChildrenDeleted(t, c, video.ID)
+	})
+}
+
+func checkVideoChildrenDeleted(t *testing.T, c Client, videoID uuid.UUID) {
+	t.Helper()
+	ctx := context.Background()
+	chapters, err := c.GetChapters(ctx, videoID)
+	check(t, err)
+	cuts, err := c.GetSceneCuts(ctx, videoID)
+	check(t, err)
+	shares, err := c.GetVideoShares(ctx, videoID)
+	check(t, err)
+	links, err := c.GetShareLinks(ctx, videoID)
+	check(t, err)
+	if len(chapters)+len(cuts)+len(shares)+len(links) != 0 {
+		t.Error("the chapters, scene cuts, shares and share links of the video are still there")
+	}
+}
+
+func TestWorkspacesConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		admin := createTestUser(t, c, "boots@example.com")
+		member := createTestUser(t, c, "lane@example.com")
+		workspace, err := c.CreateWorkspace(ctx, "Bears", admin.ID)
+		check(t, err)
+		if got, err := c.GetWorkspace(ctx, workspace.ID); err != nil || got.Name != "Bears" {
+			t.Errorf("got %+v, %v", got, err)
+		}
+
+		inv, err := c.CreateWorkspaceInvitation(ctx, CreateWorkspaceInvitationParams{
+			WorkspaceID: workspace.ID,
+			Email:       "lane@example.com",
+			Role:        WorkspaceRoleEditor,
+			InvitedBy:   admin.ID,
+			ExpiresAt:   time.Now().Add(time.Hour),
+		})
+		check(t, err)
+		if inv.WorkspaceName != "Bears" || inv.InvitedBy != admin.ID {
+			t.Errorf("created %+v", inv)
+		}
+		open, err := c.GetInvitationsForEmail(ctx, "lane@example.com")
+		check(t, err)
+		if len(open) != 1 {
+			t.Errorf("got %d invitations for the email, want 1", len(open))
+		}
+		accepted, err := c.AcceptWorkspaceInvitation(ctx, inv, member.ID)
+		checkReported(t, "accepting the invitation", accepted, err, true)
+		accepted, err = c.AcceptWorkspaceInvitation(ctx, inv, member.ID)
+		checkReported(t, "accepting it again", accepted, err, false)
+		if got, err := c.GetWorkspaceInvitation(ctx, inv.ID); err != nil || got.AcceptedAt == nil {
+			t.Errorf("got %+v, %v after accepting it", got, err)
+		}
+
+		another, err := c.CreateWorkspaceInvitation(ctx, CreateWorkspaceInvitationParams{
+			WorkspaceID: workspace.ID,
+			Email:       "mallory@example.com",
+			Role:        WorkspaceRoleViewer,
+			InvitedBy:   admin.ID,
+			ExpiresAt:   time.Now().Add(time.Hour),
+		})
+		check(t, err)
+		revoked, err := c.RevokeWorkspaceInvitation(ctx, workspace.ID, another.ID)
+		checkReported(t, "revoking the invitation", revoked, err, true)
+		open, err = c.GetWorkspaceInvitations(ctx, workspace.ID)
+		check(t, err)
+		if len(open) != 0 {
+			t.Errorf("got %d open invitations, want none", len(open))
+		}
+
+		role, err := c.GetWorkspaceRole(ctx, workspace.ID, member.ID)
+		check(t, err)
+		if role != WorkspaceRoleEditor {
+			t.Errorf("got role %q, want %q", role, WorkspaceRoleEditor)
+		}
+		members, err := c.GetWorkspaceMembers(ctx, workspace.ID)
+		check(t, err)
+		if len(members) != 2 {
+			t.Errorf("got %d members, want 2", len(members))
+		}
+		workspaces, err := c.GetUserWorkspaces(ctx, member.ID)
+		check(t, err)
+		if len(workspaces) != 1 || workspaces[0].Role != WorkspaceRoleEditor {
+			t.Errorf("got workspaces %+v", workspaces)
+		}
+
+		if _, err := c.SetWorkspaceMemberRole(ctx, workspace.ID, admin.ID, WorkspaceRoleViewer); !errors.Is(err, ErrLastWorkspaceAdmin) {
+			t.Errorf("demoting the last admin returned %v, want %v", err, ErrLastWorkspaceAdmin)
+		}
+		changed, err := c.SetWorkspaceMemberRole(ctx, workspace.ID, member.ID, WorkspaceRoleAdmin)
+		checkReported(t, "promoting the member", changed, err, true)
+		removed, err := c.RemoveWorkspaceMember(ctx, workspace.ID, admin.ID)
+		checkReported(t, "removing the first admin", removed, err, true)
+		removed, err = c.RemoveWorkspaceMember(ctx, workspace.ID, admin.ID)
+		checkReported(t, "removing them again", removed, err, false)
+
+		video := createTestVideo(t, c, admin.ID, &workspace.ID)
+		videos, err := c.GetWorkspaceVideos(ctx, workspace.ID)
+		check(t, err)
+		if len(videos) != 1 || videos[0].WorkspaceID == nil || *videos[0].WorkspaceID != workspace.ID {
+			t.Errorf("got workspace videos %+v", videos)
+		}
+		personal, err := c.GetVideos(ctx, admin.ID)
+		check(t, err)
+		if len(personal) != 0 {
+			t.Errorf("the workspace video %s is listed with the uploader's own", video.ID)
+		}
+	})
+}
+
+func TestAccountDeletionConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		user := createTestUser(t, c, "boots@example.com")
+		token := createTestRefreshToken(t, c, user.ID, uuid.New())
+		key, err := c.CreateAPIKey(ctx, CreateAPIKeyParams{UserID: user.ID, Name: "ci", Prefix: "tk_abc", KeyHash: "hash", Scopes: []string{"videos:read"}})
+		check(t, err)
+		check(t, c.SetPendingTOTP(ctx, user.ID, "secret"))
+		check(t, c.EnableTOTP(ctx, user.ID, 1, []string{"code"}))
+		workspace, err := c.CreateWorkspace(ctx, "Bears", user.ID)
+		check(t, err)
+
+		deletion, err := c.StartAccountDeletion(ctx, user.ID, user.Email)
+		check(t, err)
+		if deletion.UserID != user.ID || deletion.Attempts != 0 {
+			t.Errorf("started %+v", deletion)
+		}
+		again, err := c.StartAccountDeletion(ctx, user.ID, user.Email)
+		check(t, err)
+		if !again.RequestedAt.Equal(deletion.RequestedAt) {
+			t.Error("starting the deletion again should return the pending one")
+		}
+		locked, err := c.GetUser(ctx, user.ID)
+		check(t, err)
+		token, err = c.GetRefreshToken(ctx, token.Token)
+		check(t, err)
+		key, err = c.GetAPIKey(ctx, key.ID)
+		check(t, err)
+		if locked.Password != "" || token.RevokedAt == nil || key.RevokedAt == nil {
+			t.Error("starting the deletion should clear the password and revoke sessions and API keys")
+		}
+
+		check(t, c.RecordAccountDeletionFailure(ctx, user.ID, "bucket unreachable"))
+		deletions, err := c.GetAccountDeletions(ctx)
+		check(t, err)
+		if len(deletions) != 1 || deletions[0].Attempts != 1 || deletions[0].LastError == nil {
+			t.Errorf("got deletions %+v", deletions)
+		}
+
+		// Postgres enforces foreign keys, so this fails there if anything
+		// still references the user
+		check(t, c.FinishAccountDeletion(ctx, user.ID))
+		if deleted, err := c.GetUser(ctx, user.ID); err != nil || deleted != nil {
+			t.Errorf("got %+v, %v after finishing the deletion", deleted, err)
+		}
+		if got, err := c.GetWorkspace(ctx, workspace.ID); err != nil || got.ID != uuid.Nil {
+			t.Errorf("the workspace without members is still there: %+v, %v", got, err)
+		}
+		if pending, err := c.GetAccountDeletion(ctx, user.ID); err != nil || pending.UserID != uuid.Nil {
+			t.Errorf("got %+v, %v after finishing the deletion", pending, err)
+		}
+	})
+}
+
+func TestAuditEventsConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		actor := uuid.New()
+		start := time.Now()
+		for _, params := range []CreateAuditEventParams{
+			{ActorID: &actor, Action: "login", TargetType: "user", TargetID: "boots@example.com", Outcome: AuditOutcomeFailure, Detail: "wrong password"},
+			{ActorID: &actor, Action: "login", TargetType: "user", TargetID: "boots@example.com", Outcome: AuditOutcomeSuccess},
+			{Action: "login", TargetType: "user", TargetID: "lane@example.com", Outcome: AuditOutcomeFailure},
+		} {
+			params.IP, params.UserAgent = "192.0.2.1", "curl/8.0"
+			check(t, c.CreateAuditEvent(ctx, params))
+		}
+
+		events, err := c.GetAuditEvents(ctx, AuditEventFilter{ActorID: &actor}, 10)
+		check(t, err)
+		if len(events) != 2 || events[0].Outcome != AuditOutcomeSuccess || events[0].ActorID == nil || *events[0].ActorID != actor {
+			t.Errorf("got events %+v, want the actor's two, newest first", events)
+		}
+		events, err = c.GetAuditEvents(ctx, AuditEventFilter{Outcome: AuditOutcomeFailure}, 1)
+		check(t, err)
+		if len(events) != 1 || events[0].ActorID != nil {
+			t.Errorf("got events %+v, want only the anonymous failure", events)
+		}
+		until := time.Now().Add(time.Hour)
+		events, err = c.GetAuditEvents(ctx, AuditEventFilter{Since: &start, Until: &until, Action: "login", TargetType: "user", TargetID: "lane@example.com"}, 10)
+		check(t, err)
+		if len(events) != 1 {
+			t.Errorf("got %d events in the time range, want 1", len(events))
+		}
+
+		outcomes := []string{}
+		err = c.EachAuditEvent(ctx, AuditEventFilter{}, func(event AuditEvent) error {
+			outcomes = append(outcomes, event.Outcome)
+			return nil
+		})
+		check(t, err)
+		if strings.Join(outcomes, ",") != "failure,success,failure" {
+			t.Errorf("got outcomes %v, want them oldest first", outcomes)
+		}
+	})
+}
+
+func TestResetConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		user := createTestUser(t, c, "boots@example.com")
+		video := createTestVideo(t, c, user.ID, nil)
+		_, err := c.CreateChapter(ctx, CreateChapterParams{VideoID: video.ID, Title: "Intro"})
+		check(t, err)
+		createTestRefreshToken(t, c, user.ID, uuid.New())
+		_, err = c.CreateWorkspace(ctx, "Bears", user.ID)
+		check(t, err)
+		check(t, c.CreateAuditEvent(ctx, CreateAuditEventParams{Action: "reset", TargetType: "app", Outcome: AuditOutcomeSuccess}))
+
+		check(t, c.Reset(ctx))
+		users, err := c.GetUsers(ctx)
+		check(t, err)
+		videos, err := c.GetAllVideos(ctx)
+		check(t, err)
+		if len(users)+len(videos) != 0 {
+			t.Errorf("%d users and %d videos are left after the reset", len(users), len(videos))
+		}
+		events, err := c.GetAuditEvents(ctx, AuditEventFilter{}, 10)
+		check(t, err)
+		if len(events) != 1 {
+			t.Error("the reset should keep the audit log")
+		}
+	})
+}
+
+func TestDeleteUserWithVideosConformance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		ctx := context.Background()
+		user := createTestUser(t, c, "boots@example.com")
+		video := createTestVideo(t, c, user.ID, nil)
+
+		check(t, c.DeleteUser(ctx, user.ID))
+		got, err := c.GetVideo(ctx, video.ID)
+		check(t, err)
+		if got.ID != video.ID || got.UserID != uuid.Nil {
+			t.Errorf("got %+v after deleting its owner, want the video without one", got)
+		}
+	})
+}
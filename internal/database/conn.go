@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// conn runs the queries of a Client: it rebinds them for the dialect, gives
+// each one a deadline and logs the slow ones.
+type conn struct {
+	db      *sql.DB
+	dialect dialect
+	// queryTimeout bounds every query on top of the deadline of its context,
+	// 0 means no bound
+	queryTimeout time.Duration
+	// slowQuery is how long a query may take before it's logged, 0 turns
+	// the logging off
+	slowQuery time.Duration
+}
+
+// executor is what *sql.DB and *sql.Tx have in common.
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// start prepares a query for running. The returned context carries the query
+// deadline, and done releases it and logs the query if it was slow.
+func (c *conn) start(ctx context.Context, query string) (context.Context, string, func()) {
+	started := time.Now()
+	cancel := context.CancelFunc(func() {})
+	if c.queryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.queryTimeout)
+	}
+	return ctx, c.dialect.rebind(query), func() {
+		cancel()
+		elapsed := time.Since(started)
+		if c.slowQuery > 0 && elapsed >= c.slowQuery {
+			log.Printf("slow query took %s: %s", elapsed.Round(time.Millisecond), strings.Join(strings.Fields(query), " "))
+		}
+	}
+}
+
+func (c *conn) exec(e executor, ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, query, done := c.start(ctx, query)
+	defer done()
+	return e.ExecContext(ctx, query, args...)
+}
+
+func (c *conn) query(e executor, ctx context.Context, query string, args ...any) (*rows, error) {
+	ctx, query, done := c.start(ctx, query)
+	r, err := e.QueryContext(ctx, query, args...)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	return &rows{Rows: r, done: done}, nil
+}
+
+func (c *conn) queryRow(e executor, ctx context.Context, query string, args ...any) *row {
+	ctx, query, done := c.start(ctx, query)
+	return &row{Row: e.QueryRowContext(ctx, query, args...), done: done}
+}
+
+// rows keeps the deadline of its query until it's closed, the results are
+// read after QueryContext returns.
+type rows struct {
+	*sql.Rows
+	done func()
+}
+
+func (r *rows) Close() error {
+	err := r.Rows.Close()
+	if r.done != nil {
+		r.done()
+		r.done = nil
+	}
+	return err
+}
+
+// row keeps the deadline of its query until it's scanned.
+type row struct {
+	*sql.Row
+	done func()
+}
+
+func (r *row) Scan(dest ...any) error {
+	defer r.done()
+	return r.Row.Scan(dest...)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.exec(c.db, ctx, query, args...)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args ...any) (*rows, error) {
+	return c.query(c.db, ctx, query, args...)
+}
+
+func (c *conn) QueryRowContext(ctx context.Context, query string, args ...any) *row {
+	return c.queryRow(c.db, ctx, query, args...)
+}
+
+// BeginTx starts a transaction. Its statements get a deadline each, the
+// transaction as a whole only ends with ctx.
+func (c *conn) BeginTx(ctx context.Context) (*connTx, error) {
+	t, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &connTx{tx: t, conn: c}, nil
+}
+
+type connTx struct {
+	tx   *sql.Tx
+	conn *conn
+}
+
+func (t *connTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.conn.exec(t.tx, ctx, query, args...)
+}
+
+func (t *connTx) QueryContext(ctx context.Context, query string, args ...any) (*rows, error) {
+	return t.conn.query(t.tx, ctx, query, args...)
+}
+
+func (t *connTx) QueryRowContext(ctx context.Context, query string, args ...any) *row {
+	return t.conn.queryRow(t.tx, ctx, query, args...)
+}
+
+func (t *connTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *connTx) Rollback() error {
+	return t.tx.Rollback()
+}
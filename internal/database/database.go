@@ -1,89 +1,109 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Client struct {
-	db *sql.DB
+	db *conn
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
-	if err != nil {
-		return Client{}, err
+// NewClient opens the database named by dbURL: a postgres:// or
+// postgresql:// URL for PostgreSQL, anything else is the path of an SQLite
+// file, optionally prefixed with sqlite://. Every query is cut short after
+// queryTimeout and logged when it takes slowQuery or more; 0 turns either
+// off. It doesn't touch the schema, MigrateUp brings it up to date.
+//
+// SQLite only enforces foreign keys when asked to, on every connection, so
+// they're turned on like Postgres always has them.
+func NewClient(dbURL string, queryTimeout, slowQuery time.Duration) (Client, error) {
+	d, dataSource := dialectSQLite, strings.TrimPrefix(dbURL, "sqlite://")
+	if strings.HasPrefix(dbURL, "postgres://") || strings.HasPrefix(dbURL, "postgresql://") {
+		d, dataSource = dialectPostgres, dbURL
 	}
-	c := Client{db}
-	err = c.autoMigrate()
+	if d == dialectSQLite {
+		separator := "?"
+		if strings.Contains(dataSource, "?") {
+			separator = "&"
+		}
+		dataSource += separator + "_foreign_keys=on"
+	}
+	db, err := sql.Open(string(d), dataSource)
 	if err != nil {
 		return Client{}, err
 	}
-	return c, nil
-
+	return Client{&conn{
+		db:           db,
+		dialect:      d,
+		queryTimeout: queryTimeout,
+		slowQuery:    slowQuery,
+	}}, nil
 }
 
-func (c *Client) autoMigrate() error {
-	userTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
-	);
-	`
-	_, err := c.db.Exec(userTable)
-	if err != nil {
-		return err
-	}
-	refreshTokenTable := `
-	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		revoked_at TIMESTAMP,
-		user_id TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(refreshTokenTable)
-	if err != nil {
-		return err
+func (c Client) Reset(ctx context.Context) error {
+	// audit_events is kept, it records the reset too, and so is
+	// schema_migrations, the schema doesn't change. Tables go before the ones
+	// they reference, foreign keys are enforced.
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM account_deletions"); err != nil {
+		return fmt.Errorf("failed to reset table account_deletions: %w", err)
 	}
-
-	videoTable := `
-	CREATE TABLE IF NOT EXISTS videos (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		title TEXT NOT NULL,
-		description TEXT,
-		thumbnail_url TEXT,
-		video_url TEXT TEXT,
-		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(videoTable)
-	if err != nil {
-		return err
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM workspace_invitations"); err != nil {
+		return fmt.Errorf("failed to reset table workspace_invitations: %w", err)
 	}
-	return nil
-}
-
-func (c Client) Reset() error {
-	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM workspace_members"); err != nil {
+		return fmt.Errorf("failed to reset table workspace_members: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM share_links"); err != nil {
+		return fmt.Errorf("failed to reset table share_links: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM video_shares"); err != nil {
+		return fmt.Errorf("failed to reset table video_shares: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM login_attempts"); err != nil {
+		return fmt.Errorf("failed to reset table login_attempts: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM user_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table user_tokens: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM recovery_codes"); err != nil {
+		return fmt.Errorf("failed to reset table recovery_codes: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM totp_credentials"); err != nil {
+		return fmt.Errorf("failed to reset table totp_credentials: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM user_identities"); err != nil {
+		return fmt.Errorf("failed to reset table user_identities: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM oidc_logins"); err != nil {
+		return fmt.Errorf("failed to reset table oidc_logins: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
-	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
-		return fmt.Errorf("failed to reset table users: %w", err)
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM scene_cuts"); err != nil {
+		return fmt.Errorf("failed to reset table scene_cuts: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM chapters"); err != nil {
+		return fmt.Errorf("failed to reset table chapters: %w", err)
 	}
-	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM users"); err != nil {
+		return fmt.Errorf("failed to reset table users: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM workspaces"); err != nil {
+		return fmt.Errorf("failed to reset table workspaces: %w", err)
+	}
 	return nil
 }
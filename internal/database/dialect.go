@@ -0,0 +1,40 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// dialect is the kind of database behind a Client. Queries are written once,
+// with ? placeholders, in the SQL both dialects understand.
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite3"
+	dialectPostgres dialect = "postgres"
+)
+
+// rebind rewrites the ? placeholders of query to the $1, $2... Postgres
+// expects. Question marks in string literals are left alone.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
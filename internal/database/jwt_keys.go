@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// JWTKey is an asymmetric key for signing access tokens. The newest key that
+// isn't retired signs, retired keys keep verifying until RetiredAt so tokens
+// signed before a rotation stay valid.
+type JWTKey struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Algorithm  string     `json:"algorithm"`
+	PrivateKey string     `json:"-"`
+	RetiredAt  *time.Time `json:"retired_at"`
+}
+
+type CreateJWTKeyParams struct {
+	ID         string
+	Algorithm  string
+	PrivateKey string
+}
+
+// GetJWTKeys returns every stored key, newest first.
+func (c Client) GetJWTKeys(ctx context.Context) ([]JWTKey, error) {
+	query := `
+	SELECT id, created_at, algorithm, private_key, retired_at
+	FROM jwt_keys
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []JWTKey{}
+	for rows.Next() {
+		var key JWTKey
+		if err := rows.Scan(&key.ID, &key.CreatedAt, &key.Algorithm, &key.PrivateKey, &key.RetiredAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// RotateJWTKey stores a new signing key and retires every key that was still
+// active as of retireAt, in one transaction.
+func (c Client) RotateJWTKey(ctx context.Context, params CreateJWTKeyParams, retireAt time.Time) (JWTKey, error) {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return JWTKey{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE jwt_keys
+	SET retired_at = ?
+	WHERE retired_at IS NULL
+	`, retireAt.UTC())
+	if err != nil {
+		return JWTKey{}, err
+	}
+
+	createdAt := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO jwt_keys (
+		id,
+		created_at,
+		algorithm,
+		private_key
+	) VALUES (?, ?, ?, ?)
+	`, params.ID, createdAt, params.Algorithm, params.PrivateKey)
+	if err != nil {
+		return JWTKey{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return JWTKey{}, err
+	}
+
+	return JWTKey{
+		ID:         params.ID,
+		CreatedAt:  createdAt,
+		Algorithm:  params.Algorithm,
+		PrivateKey: params.PrivateKey,
+	}, nil
+}
+
+func (c Client) DeleteJWTKey(ctx context.Context, id string) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM jwt_keys WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginAttempt counts the recent failed logins of one throttling key, like an
+// email address or a client IP.
+type LoginAttempt struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  *time.Time
+}
+
+// GetLoginAttempt returns a zero LoginAttempt when the key has no failures.
+func (c Client) GetLoginAttempt(ctx context.Context, key string) (LoginAttempt, error) {
+	query := `
+	SELECT key, failures, last_failure_at, blocked_until
+	FROM login_attempts
+	WHERE key = ?
+	`
+	var attempt LoginAttempt
+	err := c.db.QueryRowContext(ctx, query, key).Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailureAt, &attempt.BlockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LoginAttempt{}, nil
+		}
+		return LoginAttempt{}, err
+	}
+	return attempt, nil
+}
+
+// RecordLoginFailure counts a failed login for the key and returns the number
+// of failures in a row. Failures before since are forgotten, so the count
+// starts over after a quiet period.
+func (c Client) RecordLoginFailure(ctx context.Context, key string, now, since time.Time) (int, error) {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var failures int
+	var lastFailureAt time.Time
+	err = tx.QueryRowContext(ctx, `
+	SELECT failures, last_failure_at FROM login_attempts WHERE key = ?
+	`, key).Scan(&failures, &lastFailureAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if lastFailureAt.Before(since) {
+		failures = 0
+	}
+	failures++
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO login_attempts (key, failures, last_failure_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT(key) DO UPDATE
+	SET failures = excluded.failures, last_failure_at = excluded.last_failure_at
+	`, key, failures, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return failures, tx.Commit()
+}
+
+// BlockLogin refuses logins for the key until the given time.
+func (c Client) BlockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := c.db.ExecContext(ctx, `
+	UPDATE login_attempts
+	SET blocked_until = ?
+	WHERE key = ?
+	`, until.UTC(), key)
+	return err
+}
+
+// ClearLoginAttempts forgets the failures of the key after a successful login.
+func (c Client) ClearLoginAttempts(ctx context.Context, key string) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE key = ?`, key)
+	return err
+}
+
+// DeleteStaleLoginAttempts removes keys without failures since before that
+// aren't blocked anymore.
+func (c Client) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) error {
+	_, err := c.db.ExecContext(ctx, `
+	DELETE FROM login_attempts
+	WHERE last_failure_at < ? AND (blocked_until IS NULL OR blocked_until < ?)
+	`, before.UTC(), time.Now().UTC())
+	return err
+}
@@ -0,0 +1,339 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is one step of the schema, read from a pair of files named
+// <version>_<name>.up.sql and <version>_<name>.down.sql in the migrations
+// directory of the dialect. Both dialects have the same versions, so a new
+// migration needs a pair of files in each.
+//
+// Databases created before migrations existed, by autoMigrate, may already
+// have some of the later tables and columns. Migrations create tables with IF
+// NOT EXISTS, Postgres adds columns with ADD COLUMN IF NOT EXISTS, and since
+// SQLite has no such thing its ADD COLUMN statements are skipped when the
+// column is already there.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and when it was applied, nil if it wasn't.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// statementEnd ends the statements of a SQLite migration, a semicolon at the
+// end of a line.
+var statementEnd = regexp.MustCompile(`;[ \t]*(\r?\n|$)`)
+
+var addColumn = regexp.MustCompile(`(?i)^ALTER\s+TABLE\s+(\w+)\s+ADD\s+COLUMN\s+(\w+)`)
+
+// migrationLockID is the Postgres advisory lock held while migrating, so
+// replicas starting together don't migrate at the same time.
+const migrationLockID = 7342658920
+
+// loadMigrations returns every migration of the dialect, oldest first.
+func loadMigrations(d dialect) ([]Migration, error) {
+	dir := "migrations/sqlite"
+	if d == dialectPostgres {
+		dir = "migrations/postgres"
+	}
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s isn't named <version>_<name>.<up|down>.sql", entry.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, match[2])
+		}
+		contents, err := fs.ReadFile(migrationFiles, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func (c Client) ensureMigrationsTable(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	);
+	`)
+	return err
+}
+
+// MigrationStatus lists every migration, oldest first, with when it was
+// applied.
+func (c Client) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := c.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		statuses[i] = MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies the pending migrations up to and including version
+// target, or all of them when target is 0. It returns the ones it applied.
+// Each migration runs in its own transaction, so a failing one leaves the
+// schema as the previous one left it.
+func (c Client) MigrateUp(ctx context.Context, target int) ([]Migration, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := c.lockMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	statuses, err := c.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+	for i, migration := range migrations {
+		if target != 0 && migration.Version > target {
+			break
+		}
+		if statuses[i].AppliedAt != nil {
+			continue
+		}
+		err := c.runMigration(ctx, migration.Up, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+			migration.Version, migration.Name, time.Now().UTC())
+		if err != nil {
+			return applied, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and
+// returns the ones it reverted.
+func (c Client) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := c.lockMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	statuses, err := c.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := []Migration{}
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration := migrations[i]
+		if statuses[i].AppliedAt == nil {
+			continue
+		}
+		err := c.runMigration(ctx, migration.Down, `DELETE FROM schema_migrations WHERE version = ?`, migration.Version)
+		if err != nil {
+			return reverted, fmt.Errorf("reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		reverted = append(reverted, migration)
+	}
+	return reverted, nil
+}
+
+// lockMigrations waits until no one else is migrating the database and
+// returns the function that lets them go on. SQLite is only used by one
+// server, so it's only done for Postgres.
+func (c Client) lockMigrations(ctx context.Context) (func(), error) {
+	if c.db.dialect != dialectPostgres {
+		return func() {}, nil
+	}
+	// advisory locks belong to a session, so they're taken and released on
+	// the same connection
+	session, err := c.db.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := session.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return func() {
+		// the connection goes back to the pool, so the lock has to be
+		// released even when ctx is done
+		session.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+		session.Close()
+	}, nil
+}
+
+// runMigration runs the statements of a migration and the bookkeeping query
+// that records it in one transaction.
+//
+// SQLite migrations rebuild tables by copying them and dropping the original,
+// which with foreign keys on would cascade to, or fail on, the rows that
+// reference it. They run with foreign keys off on a connection of their own,
+// since SQLite ignores the pragma inside a transaction.
+func (c Client) runMigration(ctx context.Context, statements, record string, args ...any) error {
+	var t *sql.Tx
+	var err error
+	if c.db.dialect == dialectPostgres {
+		t, err = c.db.db.BeginTx(ctx, nil)
+	} else {
+		var session *sql.Conn
+		session, err = c.db.db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			// the connection goes back to the pool, so foreign keys have to
+			// be back on even when ctx is done
+			session.ExecContext(context.WithoutCancel(ctx), `PRAGMA foreign_keys = ON`)
+			session.Close()
+		}()
+		if _, err := session.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+			return err
+		}
+		t, err = session.BeginTx(ctx, nil)
+	}
+	if err != nil {
+		return err
+	}
+	tx := &connTx{tx: t, conn: c.db}
+	defer tx.Rollback()
+
+	// migrations can rewrite whole tables, so they aren't held to the query
+	// timeout
+	if c.db.dialect == dialectPostgres {
+		_, err = tx.tx.ExecContext(ctx, statements)
+	} else {
+		err = execSQLiteMigration(ctx, tx.tx, statements)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// execSQLiteMigration runs the statements of a SQLite migration one by one,
+// skipping the ADD COLUMN statements whose column already exists.
+func execSQLiteMigration(ctx context.Context, tx *sql.Tx, migration string) error {
+	for _, statement := range sqliteStatements(migration) {
+		if match := addColumn.FindStringSubmatch(statement); match != nil {
+			var exists bool
+			err := tx.QueryRowContext(ctx, `SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?`, match[1], match[2]).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+		}
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("%w: %s", err, statement)
+		}
+	}
+	return nil
+}
+
+// sqliteStatements splits a SQLite migration into its statements, leaving out
+// comments.
+func sqliteStatements(migration string) []string {
+	statements := []string{}
+	for _, chunk := range statementEnd.Split(migration, -1) {
+		lines := []string{}
+		for _, line := range strings.Split(chunk, "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+				lines = append(lines, line)
+			}
+		}
+		if statement := strings.TrimSpace(strings.Join(lines, "\n")); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
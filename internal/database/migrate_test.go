@@ -0,0 +1,233 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newSQLiteClient(t *testing.T) Client {
+	t.Helper()
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"), 5*time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.db.db.Close() })
+	return c
+}
+
+// columnType returns the declared type of a column, "" when there's no such
+// column.
+func columnType(t *testing.T, c Client, table, column string) string {
+	t.Helper()
+	var columnType string
+	err := c.db.QueryRowContext(context.Background(), `SELECT COALESCE(MAX(type), '') FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&columnType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return columnType
+}
+
+func exec(t *testing.T, c Client, statements string) {
+	t.Helper()
+	if _, err := c.db.db.Exec(statements); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkLatestSchema checks the columns each migration after the first adds.
+func checkLatestSchema(t *testing.T, c Client) {
+	t.Helper()
+	for _, column := range [][2]string{
+		{"videos", "audio_url"},
+		{"videos", "duration"},
+		{"videos", "visibility"},
+		{"videos", "workspace_id"},
+		{"refresh_tokens", "family_id"},
+		{"refresh_tokens", "last_used_at"},
+		{"users", "email_verified_at"},
+		{"users", "role"},
+		{"audit_events", "detail"},
+	} {
+		if columnType(t, c, column[0], column[1]) == "" {
+			t.Errorf("%s.%s is missing", column[0], column[1])
+		}
+	}
+	if got := columnType(t, c, "videos", "user_id"); got != "TEXT" {
+		t.Errorf("videos.user_id is %s, want TEXT", got)
+	}
+}
+
+// baselineSchema is what autoMigrate created before any of the later tables
+// and columns existed.
+const baselineSchema = `
+CREATE TABLE users (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL
+);
+CREATE TABLE refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE videos (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT TEXT,
+	user_id INTEGER,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+INSERT INTO users (id, password, email) VALUES ('4f1e0c2a-8d3b-4a57-9a8e-2b1f6f0c9d11', 'hash', 'boots@example.com');
+INSERT INTO videos (id, title, description, user_id) VALUES ('a6c3f1d0-2b4e-4c8f-9d7a-5e1b3c2d4f60', 'Boots', 'A bear', '4f1e0c2a-8d3b-4a57-9a8e-2b1f6f0c9d11');
+`
+
+func TestMigrateUpAndDown(t *testing.T) {
+	c := newSQLiteClient(t)
+	ctx := context.Background()
+	migrations, err := loadMigrations(dialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := c.MigrateUp(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+	checkLatestSchema(t, c)
+
+	reverted, err := c.MigrateDown(ctx, len(migrations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != len(migrations) {
+		t.Fatalf("reverted %d migrations, want %d", len(reverted), len(migrations))
+	}
+	var tables int
+	if err := c.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name != 'schema_migrations'`).Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Errorf("%d tables are left after reverting every migration", tables)
+	}
+
+	if _, err := c.MigrateUp(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkLatestSchema(t, c)
+}
+
+func TestMigrateUpFromBaseline(t *testing.T) {
+	c := newSQLiteClient(t)
+	exec(t, c, baselineSchema)
+
+	if _, err := c.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	checkLatestSchema(t, c)
+	video, err := c.GetVideo(context.Background(), uuid.MustParse("a6c3f1d0-2b4e-4c8f-9d7a-5e1b3c2d4f60"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Title != "Boots" || video.Visibility != VisibilityPrivate {
+		t.Errorf("got video %+v after migrating", video)
+	}
+}
+
+func TestMigrateUpFromPartialAutoMigrate(t *testing.T) {
+	// autoMigrate added tables as features came, but a column only when it
+	// created the table, so a database can have any mix of the two
+	c := newSQLiteClient(t)
+	exec(t, c, baselineSchema+`
+	ALTER TABLE videos ADD COLUMN audio_url TEXT;
+	ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+	CREATE TABLE jwt_keys (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		algorithm TEXT NOT NULL,
+		private_key TEXT NOT NULL,
+		retired_at TIMESTAMP
+	);
+	`)
+
+	if _, err := c.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	checkLatestSchema(t, c)
+}
+
+func TestMigrateUpAgain(t *testing.T) {
+	// databases migrated before the schema was split into steps recorded the
+	// whole schema as the first two versions
+	c := newSQLiteClient(t)
+	ctx := context.Background()
+	if _, err := c.MigrateUp(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	exec(t, c, `DELETE FROM schema_migrations WHERE version > 2`)
+
+	if _, err := c.MigrateUp(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkLatestSchema(t, c)
+}
+
+func TestMigrateRebuildKeepsReferencingRows(t *testing.T) {
+	c := newSQLiteClient(t)
+	ctx := context.Background()
+	if _, err := c.MigrateUp(ctx, 18); err != nil {
+		t.Fatal(err)
+	}
+	// the second video's owner was deleted while foreign keys weren't
+	// enforced
+	exec(t, c, `
+	PRAGMA foreign_keys = OFF;
+	INSERT INTO users (id, password, email) VALUES ('4f1e0c2a-8d3b-4a57-9a8e-2b1f6f0c9d11', 'hash', 'boots@example.com');
+	INSERT INTO videos (id, title, description, user_id) VALUES ('a6c3f1d0-2b4e-4c8f-9d7a-5e1b3c2d4f60', 'Boots', '', '4f1e0c2a-8d3b-4a57-9a8e-2b1f6f0c9d11');
+	INSERT INTO videos (id, title, description, user_id) VALUES ('0b9e7c55-6f0d-4e33-8a41-7c2f5b8e1d02', 'Orphan', '', 'd3c1b2a0-0000-4000-8000-000000000000');
+	INSERT INTO chapters (id, video_id, start_time, title) VALUES ('7d2a9b14-3c5e-4f60-8b71-9e0a1c2b3d4e', 'a6c3f1d0-2b4e-4c8f-9d7a-5e1b3c2d4f60', 0, 'Intro');
+	PRAGMA foreign_keys = ON;
+	`)
+
+	if _, err := c.MigrateUp(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	chapters, err := c.GetChapters(ctx, uuid.MustParse("a6c3f1d0-2b4e-4c8f-9d7a-5e1b3c2d4f60"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chapters) != 1 {
+		t.Errorf("got %d chapters after rebuilding videos, want 1", len(chapters))
+	}
+	orphan, err := c.GetVideo(ctx, uuid.MustParse("0b9e7c55-6f0d-4e33-8a41-7c2f5b8e1d02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orphan.Title != "Orphan" || orphan.UserID != uuid.Nil {
+		t.Errorf("got %+v, want the video without its deleted owner", orphan)
+	}
+
+	var enabled bool
+	if err := c.db.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&enabled); err != nil {
+		t.Fatal(err)
+	}
+	if !enabled {
+		t.Error("foreign keys are off after migrating")
+	}
+}
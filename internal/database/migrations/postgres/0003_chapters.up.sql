@@ -0,0 +1,11 @@
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS duration DOUBLE PRECISION;
+
+CREATE TABLE IF NOT EXISTS chapters (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    video_id TEXT NOT NULL,
+    start_time DOUBLE PRECISION NOT NULL,
+    title TEXT NOT NULL,
+    FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS scene_cuts (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    video_id TEXT NOT NULL,
+    time DOUBLE PRECISION NOT NULL,
+    score DOUBLE PRECISION NOT NULL,
+    frame_url TEXT,
+    FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
@@ -0,0 +1,11 @@
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'private';
+
+CREATE TABLE IF NOT EXISTS video_shares (
+    video_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    permission TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(video_id, user_id),
+    FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
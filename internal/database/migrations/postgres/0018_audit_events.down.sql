@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS audit_events_created_at;
+DROP TABLE IF EXISTS audit_events;
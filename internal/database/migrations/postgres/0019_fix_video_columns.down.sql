@@ -0,0 +1,2 @@
+-- Nothing to do, 0001_initial already has the corrected video columns. The
+-- migration is here so versions mean the same in both dialects.
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS chapters;
+
+ALTER TABLE videos DROP COLUMN duration;
@@ -0,0 +1,11 @@
+ALTER TABLE videos ADD COLUMN duration REAL;
+
+CREATE TABLE IF NOT EXISTS chapters (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    video_id TEXT NOT NULL,
+    start_time REAL NOT NULL,
+    title TEXT NOT NULL,
+    FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
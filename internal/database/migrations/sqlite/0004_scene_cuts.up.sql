@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS scene_cuts (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    video_id TEXT NOT NULL,
+    time REAL NOT NULL,
+    score REAL NOT NULL,
+    frame_url TEXT,
+    FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS jwt_keys (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    algorithm TEXT NOT NULL,
+    private_key TEXT NOT NULL,
+    retired_at TIMESTAMP
+);
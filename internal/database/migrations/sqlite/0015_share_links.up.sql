@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS share_links (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    video_id TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    password_hash TEXT NOT NULL DEFAULT '',
+    max_views INTEGER,
+    views INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMP,
+    FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
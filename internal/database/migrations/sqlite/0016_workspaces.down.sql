@@ -0,0 +1,34 @@
+-- SQLite can't drop a column that's a foreign key, so videos is rebuilt
+-- without it.
+
+CREATE TABLE videos_old (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    title TEXT NOT NULL,
+    description TEXT,
+    thumbnail_url TEXT,
+    video_url TEXT TEXT,
+    user_id INTEGER,
+    audio_url TEXT,
+    duration REAL,
+    visibility TEXT NOT NULL DEFAULT 'private',
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO videos_old (
+    id, created_at, updated_at, title, description, thumbnail_url, video_url,
+    user_id, audio_url, duration, visibility
+)
+SELECT
+    id, created_at, updated_at, title, description, thumbnail_url, video_url,
+    user_id, audio_url, duration, visibility
+FROM videos;
+
+DROP TABLE videos;
+
+ALTER TABLE videos_old RENAME TO videos;
+
+DROP TABLE IF EXISTS workspace_invitations;
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
@@ -0,0 +1,25 @@
+CREATE TABLE videos_old (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    title TEXT NOT NULL,
+    description TEXT,
+    thumbnail_url TEXT,
+    video_url TEXT TEXT,
+    audio_url TEXT,
+    duration REAL,
+    user_id INTEGER,
+    visibility TEXT NOT NULL DEFAULT 'private',
+    workspace_id TEXT,
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id)
+);
+
+INSERT INTO videos_old SELECT
+    id, created_at, updated_at, title, description, thumbnail_url, video_url,
+    audio_url, duration, user_id, visibility, workspace_id
+FROM videos;
+
+DROP TABLE videos;
+
+ALTER TABLE videos_old RENAME TO videos;
@@ -0,0 +1,37 @@
+-- videos.user_id was declared INTEGER though it holds UUIDs, and video_url
+-- as "TEXT TEXT". SQLite can't change a column type, so the table is rebuilt
+-- the way https://www.sqlite.org/lang_altertable.html#otheralter describes.
+-- The owner is cleared when they're deleted, as in Postgres, and owners that
+-- were deleted before foreign keys were enforced are cleared now.
+
+CREATE TABLE videos_new (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    title TEXT NOT NULL,
+    description TEXT,
+    thumbnail_url TEXT,
+    video_url TEXT,
+    audio_url TEXT,
+    duration REAL,
+    user_id TEXT,
+    visibility TEXT NOT NULL DEFAULT 'private',
+    workspace_id TEXT,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id)
+);
+
+INSERT INTO videos_new (
+    id, created_at, updated_at, title, description, thumbnail_url, video_url,
+    audio_url, duration, user_id, visibility, workspace_id
+)
+SELECT
+    id, created_at, updated_at, title, description, thumbnail_url, video_url,
+    audio_url, duration,
+    (SELECT users.id FROM users WHERE users.id = CAST(videos.user_id AS TEXT)),
+    visibility, workspace_id
+FROM videos;
+
+DROP TABLE videos;
+
+ALTER TABLE videos_new RENAME TO videos;
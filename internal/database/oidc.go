@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OIDCLogin is a single sign-on login waiting for the provider's callback,
+// keyed by its state parameter.
+type OIDCLogin struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	DeviceLabel  string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+// UserIdentity links a user to the subject of an external identity provider.
+type UserIdentity struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateUserIdentityParams
+}
+
+type CreateUserIdentityParams struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Issuer  string    `json:"issuer"`
+	Subject string    `json:"subject"`
+	Email   string    `json:"email"`
+}
+
+func (c Client) CreateOIDCLogin(ctx context.Context, login OIDCLogin) error {
+	query := `
+	INSERT INTO oidc_logins (
+		state,
+		nonce,
+		code_verifier,
+		device_label,
+		created_at,
+		expires_at
+	) VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.ExecContext(ctx, query, login.State, login.Nonce, login.CodeVerifier, login.DeviceLabel, login.CreatedAt, login.ExpiresAt)
+	return err
+}
+
+// TakeOIDCLogin returns and deletes the pending login with the given state,
+// so a callback can only be used once. It returns nil when there is none.
+func (c Client) TakeOIDCLogin(ctx context.Context, state string) (*OIDCLogin, error) {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var login OIDCLogin
+	err = tx.QueryRowContext(ctx, `
+	SELECT state, nonce, code_verifier, device_label, created_at, expires_at
+	FROM oidc_logins
+	WHERE state = ?
+	`, state).Scan(&login.State, &login.Nonce, &login.CodeVerifier, &login.DeviceLabel, &login.CreatedAt, &login.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM oidc_logins WHERE state = ?`, state)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows != 1 {
+		// taken by a concurrent callback
+		return nil, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+// DeleteExpiredOIDCLogins removes logins that were started but never
+// finished.
+func (c Client) DeleteExpiredOIDCLogins(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expires_at < ?`, time.Now().UTC())
+	return err
+}
+
+func (c Client) GetUserIdentity(ctx context.Context, issuer, subject string) (UserIdentity, error) {
+	query := `
+	SELECT id, created_at, user_id, issuer, subject, email
+	FROM user_identities
+	WHERE issuer = ? AND subject = ?
+	`
+	var identity UserIdentity
+	var id, userID string
+	err := c.db.QueryRowContext(ctx, query, issuer, subject).
+		Scan(&id, &identity.CreatedAt, &userID, &identity.Issuer, &identity.Subject, &identity.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserIdentity{}, nil
+		}
+		return UserIdentity{}, err
+	}
+	identity.ID, err = uuid.Parse(id)
+	if err != nil {
+		return UserIdentity{}, err
+	}
+	identity.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return UserIdentity{}, err
+	}
+	return identity, nil
+}
+
+func (c Client) CreateUserIdentity(ctx context.Context, params CreateUserIdentityParams) (UserIdentity, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO user_identities (
+		id,
+		created_at,
+		user_id,
+		issuer,
+		subject,
+		email
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.ExecContext(ctx, query, id.String(), params.UserID.String(), params.Issuer, params.Subject, params.Email)
+	if err != nil {
+		return UserIdentity{}, err
+	}
+	return c.GetUserIdentity(ctx, params.Issuer, params.Subject)
+}
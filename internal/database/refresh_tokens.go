@@ -1,63 +1,165 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// ErrRefreshTokenRevoked is returned when rotating a token that was already
+// revoked or rotated, e.g. by a concurrent refresh with the same token.
+var ErrRefreshTokenRevoked = errors.New("refresh token already revoked")
+
 type RefreshToken struct {
 	CreateRefreshTokenParams
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	RevokedAt *time.Time `json:"revoked_at"`
+	// ReplacedBy is the token issued when this one was rotated
+	ReplacedBy *string `json:"-"`
 }
 
 type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
 	ExpiresAt time.Time `json:"expires_at"`
+	// FamilyID groups every token descending from the same login, and
+	// doubles as the ID of the session
+	FamilyID         uuid.UUID `json:"family_id"`
+	DeviceLabel      string    `json:"device_label"`
+	UserAgent        string    `json:"user_agent"`
+	IP               string    `json:"ip"`
+	SessionStartedAt time.Time `json:"session_started_at"`
+}
+
+// Session is the active refresh token of one login.
+type Session struct {
+	ID          uuid.UUID  `json:"id"`
+	DeviceLabel string     `json:"device_label"`
+	UserAgent   string     `json:"user_agent"`
+	IP          string     `json:"ip"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
 }
 
-func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+func (c Client) CreateRefreshToken(ctx context.Context, params CreateRefreshTokenParams) (RefreshToken, error) {
 	query := `
 		INSERT INTO refresh_tokens (
 			token,
 			created_at,
 			updated_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			expires_at,
+			family_id,
+			device_label,
+			user_agent,
+			ip,
+			session_started_at,
+			last_used_at
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	_, err := c.db.ExecContext(ctx, query, params.Token, params.UserID.String(), params.ExpiresAt, params.FamilyID.String(),
+		params.DeviceLabel, params.UserAgent, params.IP, params.SessionStartedAt, time.Now().UTC())
 	if err != nil {
 		return RefreshToken{}, err
 	}
 
-	return c.GetRefreshToken(params.Token)
+	return c.GetRefreshToken(ctx, params.Token)
 }
 
-func (c Client) RevokeRefreshToken(token string) error {
+// RotateRefreshToken revokes oldToken, records params.Token as its
+// replacement and stores the new token, all in one transaction. A token
+// issued before families existed joins params.FamilyID, so using it again
+// revokes everything that descends from it.
+func (c Client) RotateRefreshToken(ctx context.Context, oldToken string, params CreateRefreshTokenParams) (RefreshToken, error) {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP, replaced_by = ?,
+			family_id = COALESCE(family_id, ?)
+		WHERE token = ? AND revoked_at IS NULL
+	`, params.Token, params.FamilyID.String(), oldToken)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if rows != 1 {
+		return RefreshToken{}, ErrRefreshTokenRevoked
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO refresh_tokens (
+			token,
+			created_at,
+			updated_at,
+			user_id,
+			expires_at,
+			family_id,
+			device_label,
+			user_agent,
+			ip,
+			session_started_at,
+			last_used_at
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, params.Token, params.UserID.String(), params.ExpiresAt, params.FamilyID.String(),
+		params.DeviceLabel, params.UserAgent, params.IP, params.SessionStartedAt, time.Now().UTC())
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return RefreshToken{}, err
+	}
+
+	return c.GetRefreshToken(ctx, params.Token)
+}
+
+func (c Client) RevokeRefreshToken(ctx context.Context, token string) error {
 	query := `
 		UPDATE refresh_tokens
 		SET revoked_at = CURRENT_TIMESTAMP
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.db.ExecContext(ctx, query, token)
 	return err
 }
 
-func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
+// RevokeRefreshTokenFamily revokes every token descending from the same login.
+func (c Client) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE family_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.ExecContext(ctx, query, familyID.String())
+	return err
+}
+
+func (c Client) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
+	query := `
+		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by,
+			device_label, user_agent, ip, session_started_at
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
 	var userID string
-	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+	var familyID sql.NullString
+	var sessionStartedAt *time.Time
+	err := c.db.QueryRowContext(ctx, query, token).
+		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt, &familyID, &rt.ReplacedBy,
+			&rt.DeviceLabel, &rt.UserAgent, &rt.IP, &sessionStartedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -69,15 +171,117 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	if err != nil {
 		return RefreshToken{}, err
 	}
+	rt.SessionStartedAt = rt.CreatedAt
+	if sessionStartedAt != nil {
+		rt.SessionStartedAt = *sessionStartedAt
+	}
+	// tokens issued before families existed have none
+	if familyID.Valid {
+		rt.FamilyID, err = uuid.Parse(familyID.String)
+		if err != nil {
+			return RefreshToken{}, err
+		}
+	}
 
 	return rt, nil
 }
 
-func (c Client) DeleteRefreshToken(token string) error {
+func (c Client) DeleteRefreshToken(ctx context.Context, token string) error {
 	query := `
 		DELETE FROM refresh_tokens
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.db.ExecContext(ctx, query, token)
+	return err
+}
+
+// GetSessions returns the logins of a user that still hold an unrevoked,
+// unexpired refresh token.
+func (c Client) GetSessions(ctx context.Context, userID uuid.UUID) ([]Session, error) {
+	query := `
+		SELECT family_id, device_label, user_agent, ip, session_started_at, created_at, last_used_at, expires_at
+		FROM refresh_tokens
+		WHERE user_id = ? AND revoked_at IS NULL AND family_id IS NOT NULL
+		ORDER BY last_used_at DESC
+	`
+	rows, err := c.db.QueryContext(ctx, query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		var familyID string
+		var startedAt *time.Time
+		if err := rows.Scan(
+			&familyID,
+			&session.DeviceLabel,
+			&session.UserAgent,
+			&session.IP,
+			&startedAt,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		if session.ExpiresAt.Before(now) {
+			continue
+		}
+		session.ID, err = uuid.Parse(familyID)
+		if err != nil {
+			return nil, err
+		}
+		if startedAt != nil {
+			session.CreatedAt = *startedAt
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes the tokens of one login of a user. It reports false
+// when the user has no such session.
+func (c Client) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) (bool, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND family_id = ? AND revoked_at IS NULL
+	`
+	result, err := c.db.ExecContext(ctx, query, userID.String(), sessionID.String())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// RevokeAllSessions revokes every refresh token of a user.
+func (c Client) RevokeAllSessions(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.ExecContext(ctx, query, userID.String())
+	return err
+}
+
+// RevokeOtherSessions revokes every refresh token of a user except the ones of
+// the session to keep.
+func (c Client) RevokeOtherSessions(ctx context.Context, userID, keepSessionID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL AND (family_id IS NULL OR family_id != ?)
+	`
+	_, err := c.db.ExecContext(ctx, query, userID.String(), keepSessionID.String())
 	return err
 }
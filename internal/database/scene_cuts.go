@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type SceneCut struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateSceneCutParams
+}
+
+type CreateSceneCutParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// Time is the offset of the cut from the start of the video in seconds
+	Time     float64 `json:"time"`
+	Score    float64 `json:"score"`
+	FrameURL *string `json:"frame_url"`
+}
+
+func (c Client) GetSceneCuts(ctx context.Context, videoID uuid.UUID) ([]SceneCut, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		video_id,
+		time,
+		score,
+		frame_url
+	FROM scene_cuts
+	WHERE video_id = ?
+	ORDER BY time ASC
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cuts := []SceneCut{}
+	for rows.Next() {
+		var cut SceneCut
+		if err := rows.Scan(
+			&cut.ID,
+			&cut.CreatedAt,
+			&cut.VideoID,
+			&cut.Time,
+			&cut.Score,
+			&cut.FrameURL,
+		); err != nil {
+			return nil, err
+		}
+		cuts = append(cuts, cut)
+	}
+
+	return cuts, nil
+}
+
+func (c Client) CreateSceneCut(ctx context.Context, params CreateSceneCutParams) (SceneCut, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO scene_cuts (
+		id,
+		created_at,
+		video_id,
+		time,
+		score,
+		frame_url
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.ExecContext(ctx, query, id, params.VideoID, params.Time, params.Score, params.FrameURL)
+	if err != nil {
+		return SceneCut{}, err
+	}
+
+	return c.GetSceneCut(ctx, id)
+}
+
+func (c Client) GetSceneCut(ctx context.Context, id uuid.UUID) (SceneCut, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		video_id,
+		time,
+		score,
+		frame_url
+	FROM scene_cuts
+	WHERE id = ?
+	`
+
+	var cut SceneCut
+	err := c.db.QueryRowContext(ctx, query, id).Scan(
+		&cut.ID,
+		&cut.CreatedAt,
+		&cut.VideoID,
+		&cut.Time,
+		&cut.Score,
+		&cut.FrameURL)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return SceneCut{}, nil
+		}
+		return SceneCut{}, err
+	}
+
+	return cut, nil
+}
+
+func (c Client) DeleteSceneCuts(ctx context.Context, videoID uuid.UUID) error {
+	query := `
+	DELETE FROM scene_cuts
+	WHERE video_id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, videoID)
+	return err
+}
+
+// ReplaceSceneCuts swaps the scene cuts of a video for new ones in a single
+// transaction, so readers see either the old list or the complete new one.
+func (c Client) ReplaceSceneCuts(ctx context.Context, videoID uuid.UUID, cuts []CreateSceneCutParams) ([]SceneCut, error) {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM scene_cuts WHERE video_id = ?`, videoID)
+	if err != nil {
+		return nil, err
+	}
+	for _, cut := range cuts {
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO scene_cuts (
+			id,
+			created_at,
+			video_id,
+			time,
+			score,
+			frame_url
+		) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+		`, uuid.New(), videoID, cut.Time, cut.Score, cut.FrameURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return c.GetSceneCuts(ctx, videoID)
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareLink lets anyone with its token watch a video without an account,
+// until it expires, is revoked or runs out of views.
+type ShareLink struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Views     int        `json:"views"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreateShareLinkParams
+}
+
+type CreateShareLinkParams struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// PasswordHash is empty for links without a password
+	PasswordHash string `json:"-"`
+	MaxViews     *int   `json:"max_views"`
+}
+
+func (l ShareLink) HasPassword() bool {
+	return l.PasswordHash != ""
+}
+
+func (c Client) CreateShareLink(ctx context.Context, params CreateShareLinkParams) (ShareLink, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO share_links (
+		id,
+		created_at,
+		video_id,
+		expires_at,
+		password_hash,
+		max_views
+	) VALUES (?, ?, ?, ?, ?, ?)
+	`
+	// the token carries the expiry in whole seconds
+	expiresAt := params.ExpiresAt.UTC().Truncate(time.Second)
+	_, err := c.db.ExecContext(ctx, query, id.String(), time.Now().UTC(), params.VideoID.String(), expiresAt, params.PasswordHash, params.MaxViews)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	return c.GetShareLink(ctx, id)
+}
+
+const shareLinkColumns = `id, created_at, video_id, expires_at, password_hash, max_views, views, revoked_at`
+
+func scanShareLink(row rowScanner) (ShareLink, error) {
+	var link ShareLink
+	var id, videoID string
+	err := row.Scan(&id, &link.CreatedAt, &videoID, &link.ExpiresAt, &link.PasswordHash,
+		&link.MaxViews, &link.Views, &link.RevokedAt)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	if link.ID, err = uuid.Parse(id); err != nil {
+		return ShareLink{}, err
+	}
+	if link.VideoID, err = uuid.Parse(videoID); err != nil {
+		return ShareLink{}, err
+	}
+	return link, nil
+}
+
+// GetShareLink returns a zero ShareLink when there is no link with the ID.
+func (c Client) GetShareLink(ctx context.Context, id uuid.UUID) (ShareLink, error) {
+	row := c.db.QueryRowContext(ctx, `SELECT `+shareLinkColumns+` FROM share_links WHERE id = ?`, id.String())
+	link, err := scanShareLink(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShareLink{}, nil
+	}
+	return link, err
+}
+
+// GetShareLinks returns the links of the video, newest first.
+func (c Client) GetShareLinks(ctx context.Context, videoID uuid.UUID) ([]ShareLink, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT `+shareLinkColumns+` FROM share_links WHERE video_id = ? ORDER BY created_at DESC`, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// CountShareLinkView uses up one view of the link. It reports false when the
+// link is revoked or has no views left, so concurrent requests can't go over
+// the limit.
+func (c Client) CountShareLinkView(ctx context.Context, id uuid.UUID) (bool, error) {
+	result, err := c.db.ExecContext(ctx, `
+	UPDATE share_links
+	SET views = views + 1
+	WHERE id = ? AND revoked_at IS NULL AND (max_views IS NULL OR views < max_views)
+	`, id.String())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// RevokeShareLink reports false when the video has no such link.
+func (c Client) RevokeShareLink(ctx context.Context, videoID, id uuid.UUID) (bool, error) {
+	result, err := c.db.ExecContext(ctx, `
+	UPDATE share_links
+	SET revoked_at = ?
+	WHERE id = ? AND video_id = ? AND revoked_at IS NULL
+	`, time.Now().UTC(), id.String(), videoID.String())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (c Client) DeleteShareLinks(ctx context.Context, videoID uuid.UUID) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM share_links WHERE video_id = ?`, videoID.String())
+	return err
+}
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TOTPCredential is the authenticator secret of a user. It is pending until
+// the user confirms it with a first code, and only enforced at login once
+// EnabledAt is set.
+type TOTPCredential struct {
+	UserID    uuid.UUID
+	Secret    string
+	CreatedAt time.Time
+	EnabledAt *time.Time
+	// LastStep is the TOTP period of the last accepted code
+	LastStep    int64
+	Failures    int
+	LockedUntil *time.Time
+}
+
+func (c Client) GetTOTPCredential(ctx context.Context, userID uuid.UUID) (TOTPCredential, error) {
+	query := `
+	SELECT user_id, secret, created_at, enabled_at, last_step, failures, locked_until
+	FROM totp_credentials
+	WHERE user_id = ?
+	`
+	var cred TOTPCredential
+	var id string
+	err := c.db.QueryRowContext(ctx, query, userID.String()).
+		Scan(&id, &cred.Secret, &cred.CreatedAt, &cred.EnabledAt, &cred.LastStep, &cred.Failures, &cred.LockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TOTPCredential{}, nil
+		}
+		return TOTPCredential{}, err
+	}
+	cred.UserID, err = uuid.Parse(id)
+	if err != nil {
+		return TOTPCredential{}, err
+	}
+	return cred, nil
+}
+
+// SetPendingTOTP stores a new, not yet confirmed secret for the user,
+// replacing any earlier pending one.
+func (c Client) SetPendingTOTP(ctx context.Context, userID uuid.UUID, secret string) error {
+	query := `
+	INSERT INTO totp_credentials (user_id, secret, created_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT(user_id) DO UPDATE
+	SET secret = excluded.secret, created_at = excluded.created_at, enabled_at = NULL,
+		last_step = 0, failures = 0, locked_until = NULL
+	`
+	_, err := c.db.ExecContext(ctx, query, userID.String(), secret, time.Now().UTC())
+	return err
+}
+
+// EnableTOTP turns on two-factor login for the user and replaces their
+// recovery codes.
+func (c Client) EnableTOTP(ctx context.Context, userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `
+	UPDATE totp_credentials
+	SET enabled_at = ?, last_step = ?, failures = 0, locked_until = NULL
+	WHERE user_id = ?
+	`, now, step, userID.String())
+	if err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *connTx, userID uuid.UUID, hashes []string, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID.String())
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err := tx.ExecContext(ctx, `
+		INSERT INTO recovery_codes (id, user_id, code_hash, created_at)
+		VALUES (?, ?, ?, ?)
+		`, uuid.New().String(), userID.String(), hash, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteTOTP turns off two-factor login and removes the recovery codes.
+func (c Client) DeleteTOTP(ctx context.Context, userID uuid.UUID) error {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM totp_credentials WHERE user_id = ?`, userID.String()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID.String()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordTOTPSuccess remembers the period of an accepted code. It reports
+// false when a code of that period or a later one was already used.
+func (c Client) RecordTOTPSuccess(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	result, err := c.db.ExecContext(ctx, `
+	UPDATE totp_credentials
+	SET last_step = ?, failures = 0, locked_until = NULL
+	WHERE user_id = ? AND last_step < ?
+	`, step, userID.String(), step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// RecordTOTPFailure counts a wrong code, and locks out further codes until
+// lockedUntil once maxFailures is reached.
+func (c Client) RecordTOTPFailure(ctx context.Context, userID uuid.UUID, maxFailures int, lockedUntil time.Time) error {
+	cred, err := c.GetTOTPCredential(ctx, userID)
+	if err != nil {
+		return err
+	}
+	failures := cred.Failures + 1
+	var lock *time.Time
+	if failures >= maxFailures {
+		failures = 0
+		lock = &lockedUntil
+	}
+	_, err = c.db.ExecContext(ctx, `
+	UPDATE totp_credentials
+	SET failures = ?, locked_until = ?
+	WHERE user_id = ?
+	`, failures, lock, userID.String())
+	return err
+}
+
+// UseRecoveryCode marks the user's recovery code with the given hash as used
+// and clears their failed attempts. It reports false when there is no such
+// unused code.
+func (c Client) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+	UPDATE recovery_codes
+	SET used_at = ?
+	WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, time.Now().UTC(), userID.String(), codeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE totp_credentials
+	SET failures = 0, locked_until = NULL
+	WHERE user_id = ?
+	`, userID.String())
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserToken backs a link emailed to a user, like an email verification or a
+// password reset. The token itself isn't stored, only what it was signed for.
+type UserToken struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UsedAt    *time.Time
+	CreateUserTokenParams
+}
+
+type CreateUserTokenParams struct {
+	UserID  uuid.UUID
+	Purpose string
+	// Email is the address the token was sent to
+	Email     string
+	ExpiresAt time.Time
+}
+
+func (c Client) CreateUserToken(ctx context.Context, params CreateUserTokenParams) (UserToken, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO user_tokens (
+		id,
+		created_at,
+		user_id,
+		purpose,
+		email,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	// stored truncated to the second, the precision the token is signed with
+	params.ExpiresAt = params.ExpiresAt.UTC().Truncate(time.Second)
+	_, err := c.db.ExecContext(ctx, query, id.String(), params.UserID.String(), params.Purpose, params.Email, params.ExpiresAt)
+	if err != nil {
+		return UserToken{}, err
+	}
+	return c.GetUserToken(ctx, id)
+}
+
+func (c Client) GetUserToken(ctx context.Context, id uuid.UUID) (UserToken, error) {
+	query := `
+	SELECT id, created_at, used_at, user_id, purpose, email, expires_at
+	FROM user_tokens
+	WHERE id = ?
+	`
+	var token UserToken
+	var tokenID, userID string
+	err := c.db.QueryRowContext(ctx, query, id.String()).
+		Scan(&tokenID, &token.CreatedAt, &token.UsedAt, &userID, &token.Purpose, &token.Email, &token.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserToken{}, nil
+		}
+		return UserToken{}, err
+	}
+	token.ID, err = uuid.Parse(tokenID)
+	if err != nil {
+		return UserToken{}, err
+	}
+	token.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return UserToken{}, err
+	}
+	return token, nil
+}
+
+// ConsumeUserToken marks the token used, along with every other unused token
+// the user has for the same purpose. It reports false when the token was
+// already used.
+func (c Client) ConsumeUserToken(ctx context.Context, token UserToken) (bool, error) {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	result, err := tx.ExecContext(ctx, `
+	UPDATE user_tokens
+	SET used_at = ?
+	WHERE id = ? AND used_at IS NULL
+	`, now, token.ID.String())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE user_tokens
+	SET used_at = ?
+	WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`, now, token.UserID.String(), token.Purpose)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,20 @@
+package database
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUserJSONLeavesOutPassword(t *testing.T) {
+	user := User{
+		CreateUserParams: CreateUserParams{Email: "boots@example.com", Password: "$argon2id$v=19$m=65536,t=1,p=2$salt$hash"},
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "password") || strings.Contains(string(data), "argon2id") {
+		t.Errorf("the password hash is in %s", data)
+	}
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Share permissions. Editors can also view, neither can delete the video or
+// change who it's shared with.
+const (
+	SharePermissionView = "view"
+	SharePermissionEdit = "edit"
+)
+
+func ValidSharePermission(permission string) bool {
+	return permission == SharePermissionView || permission == SharePermissionEdit
+}
+
+// VideoShare grants a user other than the owner access to a private video.
+type VideoShare struct {
+	VideoID    uuid.UUID `json:"video_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Email      string    `json:"email"`
+	Permission string    `json:"permission"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// SharedVideo is a video shared with the user, and how.
+type SharedVideo struct {
+	Video
+	Permission string `json:"permission"`
+}
+
+// SetVideoShare shares the video with the user, or changes the permission of
+// an existing share.
+func (c Client) SetVideoShare(ctx context.Context, videoID, userID uuid.UUID, permission string) error {
+	query := `
+	INSERT INTO video_shares (video_id, user_id, permission, created_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(video_id, user_id) DO UPDATE
+	SET permission = excluded.permission
+	`
+	_, err := c.db.ExecContext(ctx, query, videoID.String(), userID.String(), permission, time.Now().UTC())
+	return err
+}
+
+// GetVideoShare returns the permission the user was given on the video, or ""
+// when it isn't shared with them.
+func (c Client) GetVideoShare(ctx context.Context, videoID, userID uuid.UUID) (string, error) {
+	query := `
+	SELECT permission
+	FROM video_shares
+	WHERE video_id = ? AND user_id = ?
+	`
+	var permission string
+	err := c.db.QueryRowContext(ctx, query, videoID.String(), userID.String()).Scan(&permission)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return permission, err
+}
+
+func (c Client) GetVideoShares(ctx context.Context, videoID uuid.UUID) ([]VideoShare, error) {
+	query := `
+	SELECT s.video_id, s.user_id, u.email, s.permission, s.created_at
+	FROM video_shares s
+	JOIN users u ON u.id = s.user_id
+	WHERE s.video_id = ?
+	ORDER BY s.created_at
+	`
+	rows, err := c.db.QueryContext(ctx, query, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []VideoShare{}
+	for rows.Next() {
+		var share VideoShare
+		var videoIDStr, userIDStr string
+		if err := rows.Scan(&videoIDStr, &userIDStr, &share.Email, &share.Permission, &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		share.VideoID, err = uuid.Parse(videoIDStr)
+		if err != nil {
+			return nil, err
+		}
+		share.UserID, err = uuid.Parse(userIDStr)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// GetSharedVideos returns the videos shared with the user, newest first.
+func (c Client) GetSharedVideos(ctx context.Context, userID uuid.UUID) ([]SharedVideo, error) {
+	query := `
+	SELECT
+		v.id,
+		v.created_at,
+		v.updated_at,
+		v.title,
+		v.description,
+		v.thumbnail_url,
+		v.video_url,
+		v.audio_url,
+		v.duration,
+		v.user_id,
+		v.visibility,
+		v.workspace_id,
+		s.permission
+	FROM video_shares s
+	JOIN videos v ON v.id = s.video_id
+	WHERE s.user_id = ?
+	ORDER BY v.created_at DESC
+	`
+	rows, err := c.db.QueryContext(ctx, query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []SharedVideo{}
+	for rows.Next() {
+		var video SharedVideo
+		if err := rows.Scan(
+			&video.ID,
+			&video.CreatedAt,
+			&video.UpdatedAt,
+			&video.Title,
+			&video.Description,
+			&video.ThumbnailURL,
+			&video.VideoURL,
+			&video.AudioURL,
+			&video.Duration,
+			&video.UserID,
+			&video.Visibility,
+			&video.WorkspaceID,
+			&video.Permission,
+		); err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// DeleteVideoShare stops sharing the video with the user. It reports false
+// when it wasn't shared with them.
+func (c Client) DeleteVideoShare(ctx context.Context, videoID, userID uuid.UUID) (bool, error) {
+	result, err := c.db.ExecContext(ctx, `DELETE FROM video_shares WHERE video_id = ? AND user_id = ?`, videoID.String(), userID.String())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (c Client) DeleteVideoShares(ctx context.Context, videoID uuid.UUID) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM video_shares WHERE video_id = ?`, videoID.String())
+	return err
+}
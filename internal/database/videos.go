@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
@@ -14,16 +15,105 @@ type Video struct {
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
 	VideoURL     *string   `json:"video_url"`
+	AudioURL     *string   `json:"audio_url"`
+	Duration     *float64  `json:"duration"`
 	CreateVideoParams
 }
 
+// Visibility says who may watch a video besides its owner and the users it's
+// shared with. Unlisted videos can be watched by anyone who has the link, but
+// only public ones are listed.
+const (
+	VisibilityPrivate  = "private"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPublic   = "public"
+)
+
+func ValidVisibility(visibility string) bool {
+	return visibility == VisibilityPrivate || visibility == VisibilityUnlisted || visibility == VisibilityPublic
+}
+
 type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
+	Visibility  string    `json:"visibility"`
+	// WorkspaceID is set for videos owned by a workspace rather than by
+	// UserID, who only uploaded them
+	WorkspaceID *uuid.UUID `json:"workspace_id"`
+}
+
+// GetVideos returns the personal videos of the user, newest first. Videos they
+// uploaded to a workspace are listed with the workspace.
+func (c Client) GetVideos(ctx context.Context, userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		thumbnail_url,
+		video_url,
+		audio_url,
+		duration,
+		user_id,
+		visibility,
+		workspace_id
+	FROM videos
+	WHERE user_id = ? AND workspace_id IS NULL
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(ctx, query, userID)
+}
+
+// GetWorkspaceVideos returns the videos owned by the workspace, newest first.
+func (c Client) GetWorkspaceVideos(ctx context.Context, workspaceID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		thumbnail_url,
+		video_url,
+		audio_url,
+		duration,
+		user_id,
+		visibility,
+		workspace_id
+	FROM videos
+	WHERE workspace_id = ?
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(ctx, query, workspaceID.String())
+}
+
+// GetAllVideos returns the videos of every user, newest first.
+func (c Client) GetAllVideos(ctx context.Context) ([]Video, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		thumbnail_url,
+		video_url,
+		audio_url,
+		duration,
+		user_id,
+		visibility,
+		workspace_id
+	FROM videos
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(ctx, query)
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+// GetPublicVideos returns the public videos of every user, newest first.
+func (c Client) GetPublicVideos(ctx context.Context) ([]Video, error) {
 	query := `
 	SELECT
 		id,
@@ -33,13 +123,20 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		audio_url,
+		duration,
+		user_id,
+		visibility,
+		workspace_id
 	FROM videos
-	WHERE user_id = ?
+	WHERE visibility = ?
 	ORDER BY created_at DESC
 	`
+	return c.queryVideos(ctx, query, VisibilityPublic)
+}
 
-	rows, err := c.db.Query(query, userID)
+func (c Client) queryVideos(ctx context.Context, query string, args ...any) ([]Video, error) {
+	rows, err := c.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -56,17 +153,21 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 			&video.Description,
 			&video.ThumbnailURL,
 			&video.VideoURL,
+			&video.AudioURL,
+			&video.Duration,
 			&video.UserID,
+			&video.Visibility,
+			&video.WorkspaceID,
 		); err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
 }
 
-func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
+func (c Client) CreateVideo(ctx context.Context, params CreateVideoParams) (Video, error) {
 	id := uuid.New()
 	query := `
 	INSERT INTO videos (
@@ -75,18 +176,24 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		visibility,
+		workspace_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	visibility := params.Visibility
+	if visibility == "" {
+		visibility = VisibilityPrivate
+	}
+	_, err := c.db.ExecContext(ctx, query, id, params.Title, params.Description, params.UserID, visibility, params.WorkspaceID)
 	if err != nil {
 		return Video{}, err
 	}
 
-	return c.GetVideo(id)
+	return c.GetVideo(ctx, id)
 }
 
-func (c Client) GetVideo(id uuid.UUID) (Video, error) {
+func (c Client) GetVideo(ctx context.Context, id uuid.UUID) (Video, error) {
 	query := `
 	SELECT
 		id,
@@ -96,13 +203,17 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		audio_url,
+		duration,
+		user_id,
+		visibility,
+		workspace_id
 	FROM videos
 	WHERE id = ?
 	`
 
 	var video Video
-	err := c.db.QueryRow(query, id).Scan(
+	err := c.db.QueryRowContext(ctx, query, id).Scan(
 		&video.ID,
 		&video.CreatedAt,
 		&video.UpdatedAt,
@@ -110,7 +221,11 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		&video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
-		&video.UserID)
+		&video.AudioURL,
+		&video.Duration,
+		&video.UserID,
+		&video.Visibility,
+		&video.WorkspaceID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,7 +236,7 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
-func (c Client) UpdateVideo(video Video) error {
+func (c Client) UpdateVideo(ctx context.Context, video Video) error {
 	query := `
 	UPDATE videos
 	SET
@@ -129,27 +244,51 @@ func (c Client) UpdateVideo(video Video) error {
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
+		audio_url = ?,
+		duration = ?,
+		user_id = ?,
+		visibility = ?,
+		workspace_id = ?
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(
+	_, err := c.db.ExecContext(ctx,
 		query,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
+		&video.AudioURL,
+		video.Duration,
 		video.UserID,
+		video.Visibility,
+		video.WorkspaceID,
 		video.ID,
 	)
 	return err
 }
 
-func (c Client) DeleteVideo(id uuid.UUID) error {
+func (c Client) DeleteVideo(ctx context.Context, id uuid.UUID) error {
+	err := c.DeleteChapters(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = c.DeleteSceneCuts(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = c.DeleteVideoShares(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = c.DeleteShareLinks(ctx, id)
+	if err != nil {
+		return err
+	}
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err = c.db.ExecContext(ctx, query, id)
 	return err
 }
@@ -0,0 +1,403 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Workspace roles. Viewers can watch the workspace's videos, editors can also
+// upload, change and delete them, and admins can also manage the members.
+const (
+	WorkspaceRoleViewer = "viewer"
+	WorkspaceRoleEditor = "editor"
+	WorkspaceRoleAdmin  = "admin"
+)
+
+func ValidWorkspaceRole(role string) bool {
+	return role == WorkspaceRoleViewer || role == WorkspaceRoleEditor || role == WorkspaceRoleAdmin
+}
+
+// Workspace owns a video library shared by its members, so videos outlive the
+// membership of whoever uploaded them.
+type Workspace struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+}
+
+// UserWorkspace is a workspace the user is a member of, and their role there.
+type UserWorkspace struct {
+	Workspace
+	Role string `json:"role"`
+}
+
+type WorkspaceMember struct {
+	WorkspaceID uuid.UUID `json:"workspace_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Email       string    `json:"email"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WorkspaceInvitation asks whoever owns Email to join the workspace.
+type WorkspaceInvitation struct {
+	ID            uuid.UUID  `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	WorkspaceName string     `json:"workspace_name"`
+	AcceptedAt    *time.Time `json:"accepted_at"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+	CreateWorkspaceInvitationParams
+}
+
+type CreateWorkspaceInvitationParams struct {
+	WorkspaceID uuid.UUID `json:"workspace_id"`
+	Email       string    `json:"email"`
+	Role        string    `json:"role"`
+	InvitedBy   uuid.UUID `json:"invited_by"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// CreateWorkspace creates the workspace with its creator as the first admin.
+func (c Client) CreateWorkspace(ctx context.Context, name string, creatorID uuid.UUID) (Workspace, error) {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return Workspace{}, err
+	}
+	defer tx.Rollback()
+
+	workspace := Workspace{
+		ID:        uuid.New(),
+		CreatedAt: time.Now().UTC(),
+		Name:      name,
+	}
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO workspaces (id, created_at, name)
+	VALUES (?, ?, ?)
+	`, workspace.ID.String(), workspace.CreatedAt, workspace.Name)
+	if err != nil {
+		return Workspace{}, err
+	}
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO workspace_members (workspace_id, user_id, role, created_at)
+	VALUES (?, ?, ?, ?)
+	`, workspace.ID.String(), creatorID.String(), WorkspaceRoleAdmin, workspace.CreatedAt)
+	if err != nil {
+		return Workspace{}, err
+	}
+	return workspace, tx.Commit()
+}
+
+// GetWorkspace returns a zero Workspace when there is none with the ID.
+func (c Client) GetWorkspace(ctx context.Context, id uuid.UUID) (Workspace, error) {
+	var workspace Workspace
+	var idStr string
+	err := c.db.QueryRowContext(ctx, `
+	SELECT id, created_at, name FROM workspaces WHERE id = ?
+	`, id.String()).Scan(&idStr, &workspace.CreatedAt, &workspace.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Workspace{}, nil
+		}
+		return Workspace{}, err
+	}
+	workspace.ID, err = uuid.Parse(idStr)
+	if err != nil {
+		return Workspace{}, err
+	}
+	return workspace, nil
+}
+
+// GetUserWorkspaces returns the workspaces the user is a member of.
+func (c Client) GetUserWorkspaces(ctx context.Context, userID uuid.UUID) ([]UserWorkspace, error) {
+	rows, err := c.db.QueryContext(ctx, `
+	SELECT w.id, w.created_at, w.name, m.role
+	FROM workspace_members m
+	JOIN workspaces w ON w.id = m.workspace_id
+	WHERE m.user_id = ?
+	ORDER BY w.name
+	`, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []UserWorkspace{}
+	for rows.Next() {
+		var workspace UserWorkspace
+		var id string
+		if err := rows.Scan(&id, &workspace.CreatedAt, &workspace.Name, &workspace.Role); err != nil {
+			return nil, err
+		}
+		workspace.ID, err = uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces, rows.Err()
+}
+
+// GetWorkspaceRole returns the role of the user in the workspace, or "" when
+// they aren't a member.
+func (c Client) GetWorkspaceRole(ctx context.Context, workspaceID, userID uuid.UUID) (string, error) {
+	var role string
+	err := c.db.QueryRowContext(ctx, `
+	SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?
+	`, workspaceID.String(), userID.String()).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+func (c Client) GetWorkspaceMembers(ctx context.Context, workspaceID uuid.UUID) ([]WorkspaceMember, error) {
+	rows, err := c.db.QueryContext(ctx, `
+	SELECT m.workspace_id, m.user_id, u.email, m.role, m.created_at
+	FROM workspace_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.workspace_id = ?
+	ORDER BY m.created_at
+	`, workspaceID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []WorkspaceMember{}
+	for rows.Next() {
+		var member WorkspaceMember
+		var workspaceIDStr, userIDStr string
+		if err := rows.Scan(&workspaceIDStr, &userIDStr, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		member.WorkspaceID, err = uuid.Parse(workspaceIDStr)
+		if err != nil {
+			return nil, err
+		}
+		member.UserID, err = uuid.Parse(userIDStr)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (c Client) countWorkspaceAdmins(ctx context.Context, tx *connTx, workspaceID uuid.UUID) (int, error) {
+	var admins int
+	err := tx.QueryRowContext(ctx, `
+	SELECT COUNT(*) FROM workspace_members WHERE workspace_id = ? AND role = ?
+	`, workspaceID.String(), WorkspaceRoleAdmin).Scan(&admins)
+	return admins, err
+}
+
+// ErrLastWorkspaceAdmin is returned instead of leaving a workspace without an
+// admin to manage it.
+var ErrLastWorkspaceAdmin = errors.New("a workspace needs at least one admin")
+
+// SetWorkspaceMemberRole reports false when the user isn't a member.
+func (c Client) SetWorkspaceMemberRole(ctx context.Context, workspaceID, userID uuid.UUID, role string) (bool, error) {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+	UPDATE workspace_members SET role = ? WHERE workspace_id = ? AND user_id = ?
+	`, role, workspaceID.String(), userID.String())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+	admins, err := c.countWorkspaceAdmins(ctx, tx, workspaceID)
+	if err != nil {
+		return false, err
+	}
+	if admins == 0 {
+		return false, ErrLastWorkspaceAdmin
+	}
+	return true, tx.Commit()
+}
+
+// RemoveWorkspaceMember reports false when the user isn't a member. The
+// videos they uploaded stay in the workspace.
+func (c Client) RemoveWorkspaceMember(ctx context.Context, workspaceID, userID uuid.UUID) (bool, error) {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+	DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?
+	`, workspaceID.String(), userID.String())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+	admins, err := c.countWorkspaceAdmins(ctx, tx, workspaceID)
+	if err != nil {
+		return false, err
+	}
+	if admins == 0 {
+		return false, ErrLastWorkspaceAdmin
+	}
+	return true, tx.Commit()
+}
+
+func (c Client) CreateWorkspaceInvitation(ctx context.Context, params CreateWorkspaceInvitationParams) (WorkspaceInvitation, error) {
+	id := uuid.New()
+	_, err := c.db.ExecContext(ctx, `
+	INSERT INTO workspace_invitations (id, created_at, workspace_id, email, role, invited_by, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, id.String(), time.Now().UTC(), params.WorkspaceID.String(), params.Email, params.Role,
+		params.InvitedBy.String(), params.ExpiresAt.UTC())
+	if err != nil {
+		return WorkspaceInvitation{}, err
+	}
+	return c.GetWorkspaceInvitation(ctx, id)
+}
+
+const workspaceInvitationColumns = `
+	i.id, i.created_at, w.name, i.accepted_at, i.revoked_at,
+	i.workspace_id, i.email, i.role, i.invited_by, i.expires_at
+	FROM workspace_invitations i
+	JOIN workspaces w ON w.id = i.workspace_id`
+
+func scanWorkspaceInvitation(row rowScanner) (WorkspaceInvitation, error) {
+	var inv WorkspaceInvitation
+	var id, workspaceID, invitedBy string
+	err := row.Scan(&id, &inv.CreatedAt, &inv.WorkspaceName, &inv.AcceptedAt, &inv.RevokedAt,
+		&workspaceID, &inv.Email, &inv.Role, &invitedBy, &inv.ExpiresAt)
+	if err != nil {
+		return WorkspaceInvitation{}, err
+	}
+	if inv.ID, err = uuid.Parse(id); err != nil {
+		return WorkspaceInvitation{}, err
+	}
+	if inv.WorkspaceID, err = uuid.Parse(workspaceID); err != nil {
+		return WorkspaceInvitation{}, err
+	}
+	if inv.InvitedBy, err = uuid.Parse(invitedBy); err != nil {
+		return WorkspaceInvitation{}, err
+	}
+	return inv, nil
+}
+
+func (c Client) queryWorkspaceInvitations(ctx context.Context, query string, args ...any) ([]WorkspaceInvitation, error) {
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []WorkspaceInvitation{}
+	for rows.Next() {
+		inv, err := scanWorkspaceInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// GetWorkspaceInvitation returns a zero WorkspaceInvitation when there is none
+// with the ID.
+func (c Client) GetWorkspaceInvitation(ctx context.Context, id uuid.UUID) (WorkspaceInvitation, error) {
+	row := c.db.QueryRowContext(ctx, `SELECT `+workspaceInvitationColumns+` WHERE i.id = ?`, id.String())
+	inv, err := scanWorkspaceInvitation(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return WorkspaceInvitation{}, nil
+	}
+	return inv, err
+}
+
+// GetWorkspaceInvitations returns the open invitations of the workspace.
+func (c Client) GetWorkspaceInvitations(ctx context.Context, workspaceID uuid.UUID) ([]WorkspaceInvitation, error) {
+	return c.queryWorkspaceInvitations(ctx, `SELECT `+workspaceInvitationColumns+`
+	WHERE i.workspace_id = ? AND i.accepted_at IS NULL AND i.revoked_at IS NULL AND i.expires_at > ?
+	ORDER BY i.created_at DESC
+	`, workspaceID.String(), time.Now().UTC())
+}
+
+// GetInvitationsForEmail returns the open invitations sent to the email.
+func (c Client) GetInvitationsForEmail(ctx context.Context, email string) ([]WorkspaceInvitation, error) {
+	return c.queryWorkspaceInvitations(ctx, `SELECT `+workspaceInvitationColumns+`
+	WHERE i.email = ? AND i.accepted_at IS NULL AND i.revoked_at IS NULL AND i.expires_at > ?
+	ORDER BY i.created_at DESC
+	`, email, time.Now().UTC())
+}
+
+// AcceptWorkspaceInvitation makes the user a member with the invited role.
+// It reports false when the invitation was already used or revoked. A user
+// who is already a member keeps the role they have.
+func (c Client) AcceptWorkspaceInvitation(ctx context.Context, inv WorkspaceInvitation, userID uuid.UUID) (bool, error) {
+	tx, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	result, err := tx.ExecContext(ctx, `
+	UPDATE workspace_invitations
+	SET accepted_at = ?
+	WHERE id = ? AND accepted_at IS NULL AND revoked_at IS NULL
+	`, now, inv.ID.String())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO workspace_members (workspace_id, user_id, role, created_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(workspace_id, user_id) DO NOTHING
+	`, inv.WorkspaceID.String(), userID.String(), inv.Role, now)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RevokeWorkspaceInvitation reports false when the workspace has no such open
+// invitation.
+func (c Client) RevokeWorkspaceInvitation(ctx context.Context, workspaceID, id uuid.UUID) (bool, error) {
+	result, err := c.db.ExecContext(ctx, `
+	UPDATE workspace_invitations
+	SET revoked_at = ?
+	WHERE id = ? AND workspace_id = ? AND accepted_at IS NULL AND revoked_at IS NULL
+	`, time.Now().UTC(), id.String(), workspaceID.String())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
@@ -0,0 +1,221 @@
+// Package mp4 reads and rewrites the box structure of ISO-BMFF (MP4) files
+// without shelling out to ffmpeg.
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrTruncated means a box claims to extend past the end of its parent
+	// or of the file, which is what an interrupted upload looks like.
+	ErrTruncated = errors.New("mp4: box extends past end of data")
+	// ErrInvalidBox means a box header or payload can't be decoded.
+	ErrInvalidBox = errors.New("mp4: invalid box")
+	// ErrNoMoov means the file has no movie box, so nothing can be played.
+	ErrNoMoov = errors.New("mp4: no moov box")
+	// ErrNoMdat means the file has no media data box.
+	ErrNoMdat = errors.New("mp4: no mdat box")
+)
+
+// maxDepth bounds how deep nested boxes are followed
+const maxDepth = 16
+
+// containers are the boxes that hold nothing but other boxes
+var containers = map[string]bool{
+	"moov": true,
+	"trak": true,
+	"mdia": true,
+	"minf": true,
+	"stbl": true,
+	"edts": true,
+	"dinf": true,
+	"udta": true,
+	"mvex": true,
+}
+
+// Box is one node of the box tree.
+type Box struct {
+	Type string
+	// Offset is the position of the box header in the data it was read from
+	Offset     int64
+	Size       int64
+	HeaderSize int64
+	Children   []*Box
+	// Data is the payload of a leaf box. It is only loaded for boxes parsed
+	// from memory with ParseBoxes.
+	Data []byte
+}
+
+// End is the offset right after the box.
+func (b *Box) End() int64 {
+	return b.Offset + b.Size
+}
+
+// Child returns the first direct child of the given type.
+func (b *Box) Child(boxType string) *Box {
+	for _, child := range b.Children {
+		if child.Type == boxType {
+			return child
+		}
+	}
+	return nil
+}
+
+// Find follows a path of box types like "mdia", "minf", "stbl" down the tree.
+func (b *Box) Find(path ...string) *Box {
+	current := b
+	for _, boxType := range path {
+		current = current.Child(boxType)
+		if current == nil {
+			return nil
+		}
+	}
+	return current
+}
+
+// readHeader decodes the box header at the start of buf. remaining is how
+// many bytes are left in the parent, used for boxes with size 0.
+func readHeader(buf []byte, remaining int64) (boxType string, size, headerSize int64, err error) {
+	if len(buf) < 8 {
+		return "", 0, 0, ErrTruncated
+	}
+	size = int64(binary.BigEndian.Uint32(buf[0:4]))
+	boxType = string(buf[4:8])
+	headerSize = 8
+	switch size {
+	case 0:
+		// the box extends to the end of its parent
+		size = remaining
+	case 1:
+		if len(buf) < 16 {
+			return "", 0, 0, ErrTruncated
+		}
+		large := binary.BigEndian.Uint64(buf[8:16])
+		if large > 1<<62 {
+			return "", 0, 0, fmt.Errorf("%w: %q has size %d", ErrInvalidBox, boxType, large)
+		}
+		size = int64(large)
+		headerSize = 16
+	}
+	if size < headerSize {
+		return "", 0, 0, fmt.Errorf("%w: %q has size %d", ErrInvalidBox, boxType, size)
+	}
+	return boxType, size, headerSize, nil
+}
+
+// ScanBoxes reads the top level box headers of a file of the given size. When
+// the last box runs past the end of the file it is still returned, with its
+// declared size, together with ErrTruncated.
+func ScanBoxes(r io.ReaderAt, size int64) ([]*Box, error) {
+	boxes := []*Box{}
+	var offset int64
+	header := make([]byte, 16)
+	for offset < size {
+		n, err := r.ReadAt(header, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return boxes, err
+		}
+		boxType, boxSize, headerSize, err := readHeader(header[:n], size-offset)
+		if err != nil {
+			return boxes, err
+		}
+		box := &Box{Type: boxType, Offset: offset, Size: boxSize, HeaderSize: headerSize}
+		boxes = append(boxes, box)
+		if box.End() > size {
+			return boxes, fmt.Errorf("%w: %q at %d needs %d bytes, file has %d", ErrTruncated, boxType, offset, boxSize, size-offset)
+		}
+		offset = box.End()
+	}
+	return boxes, nil
+}
+
+// ParseBoxes decodes the boxes in data, descending into container boxes.
+// base is the file offset of data[0] so that Box.Offset stays absolute.
+func ParseBoxes(data []byte, base int64) ([]*Box, error) {
+	return parseBoxes(data, base, 0)
+}
+
+func parseBoxes(data []byte, base int64, depth int) ([]*Box, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w: boxes nested too deep", ErrInvalidBox)
+	}
+	boxes := []*Box{}
+	var pos int64
+	for pos < int64(len(data)) {
+		// QuickTime ends some containers, udta in particular, with a 32 bit
+		// zero. Fewer bytes than a box header inside a container are padding
+		if depth > 0 && int64(len(data))-pos < 8 {
+			break
+		}
+		boxType, size, headerSize, err := readHeader(data[pos:], int64(len(data))-pos)
+		if err != nil {
+			return nil, err
+		}
+		if pos+size > int64(len(data)) {
+			return nil, fmt.Errorf("%w: %q at %d", ErrTruncated, boxType, base+pos)
+		}
+		box := &Box{Type: boxType, Offset: base + pos, Size: size, HeaderSize: headerSize}
+		payload := data[pos+headerSize : pos+size]
+		if containers[boxType] {
+			box.Children, err = parseBoxes(payload, box.Offset+headerSize, depth+1)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			box.Data = payload
+		}
+		boxes = append(boxes, box)
+		pos += size
+	}
+	return boxes, nil
+}
+
+// ReadBox loads a whole box found by ScanBoxes into memory and parses its children.
+func ReadBox(r io.ReaderAt, box *Box) (*Box, error) {
+	data := make([]byte, box.Size)
+	_, err := r.ReadAt(data, box.Offset)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: %q at %d", ErrTruncated, box.Type, box.Offset)
+		}
+		return nil, err
+	}
+	boxes, err := ParseBoxes(data, box.Offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(boxes) != 1 {
+		return nil, fmt.Errorf("%w: expected a single %q box", ErrInvalidBox, box.Type)
+	}
+	return boxes[0], nil
+}
+
+// Encode serializes the box, recomputing the sizes of containers from their
+// children. The header grows to 16 bytes when the box needs a 64 bit size.
+func (b *Box) Encode() []byte {
+	var payload []byte
+	if containers[b.Type] {
+		for _, child := range b.Children {
+			payload = append(payload, child.Encode()...)
+		}
+	} else {
+		payload = b.Data
+	}
+	size := int64(len(payload)) + 8
+	var header []byte
+	if size <= 0xffffffff {
+		header = make([]byte, 8)
+		binary.BigEndian.PutUint32(header[0:4], uint32(size))
+	} else {
+		size += 8
+		header = make([]byte, 16)
+		binary.BigEndian.PutUint32(header[0:4], 1)
+		binary.BigEndian.PutUint64(header[8:16], uint64(size))
+	}
+	copy(header[4:8], b.Type)
+	return append(header, payload...)
+}
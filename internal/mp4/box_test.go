@@ -0,0 +1,78 @@
+package mp4
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// seedFiles are the starting points of the fuzz targets: a movie before and
+// after faststart, and broken copies of it.
+func seedFiles() [][]byte {
+	ftyp := leaf("ftyp", []byte("isom\x00\x00\x02\x00isomavc1")).Encode()
+	mdat := leaf("mdat", []byte("chunk-one.chunk-two.")).Encode()
+	offsets := []uint32{uint32(len(ftyp) + 8), uint32(len(ftyp) + 18)}
+
+	moovLast := append(append([]byte{}, ftyp...), mdat...)
+	moovLast = append(moovLast, testMoov(identity, offsets).Encode()...)
+
+	moovFirst := append([]byte{}, ftyp...)
+	moovFirst = append(moovFirst, testMoov(identity, offsets).Encode()...)
+	moovFirst = append(moovFirst, mdat...)
+
+	largeMoov := append(append([]byte{}, ftyp...), mdat...)
+	largeMoov = append(largeMoov, largeHeader(testMoov(identity, offsets).Encode())...)
+
+	return [][]byte{
+		moovLast,
+		moovFirst,
+		largeMoov,
+		moovLast[:len(moovLast)-10],
+		moovFirst[:len(ftyp)+20],
+		mdat,
+		{0, 0, 0},
+		{},
+	}
+}
+
+func FuzzScanBoxes(f *testing.F) {
+	for _, seed := range seedFiles() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		size := int64(len(data))
+		boxes, err := ScanBoxes(bytes.NewReader(data), size)
+		var offset int64
+		for i, box := range boxes {
+			if box.Offset != offset {
+				t.Fatalf("box %d starts at %d, want %d", i, box.Offset, offset)
+			}
+			if box.Size < box.HeaderSize {
+				t.Fatalf("box %d is smaller than its header", i)
+			}
+			if box.End() > size && (i != len(boxes)-1 || !errors.Is(err, ErrTruncated)) {
+				t.Fatalf("box %d ends at %d past %d without ErrTruncated", i, box.End(), size)
+			}
+			offset = box.End()
+		}
+		if err == nil && offset != size {
+			t.Fatalf("boxes end at %d, file has %d bytes", offset, size)
+		}
+
+		parsed, err := ParseBoxes(data, 0)
+		if err != nil {
+			return
+		}
+		var encoded []byte
+		for _, box := range parsed {
+			encoded = append(encoded, box.Encode()...)
+		}
+		reparsed, err := ParseBoxes(encoded, 0)
+		if err != nil {
+			t.Fatalf("encoded boxes don't parse: %v", err)
+		}
+		if len(reparsed) != len(parsed) {
+			t.Fatalf("got %d boxes back, want %d", len(reparsed), len(parsed))
+		}
+	})
+}
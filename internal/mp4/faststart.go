@@ -0,0 +1,171 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// FastStart copies the MP4 file of the given size from r to w with the moov
+// box moved in front of the media data, so playback can begin before the
+// whole file is downloaded. The chunk offsets in every stco and co64 box are
+// shifted to match, and stco tables are widened to co64 when the move pushes
+// an offset past 4 GiB. A file that is already laid out this way is copied
+// unchanged.
+func FastStart(r io.ReaderAt, size int64, w io.Writer) error {
+	boxes, err := ScanBoxes(r, size)
+	if err != nil {
+		return err
+	}
+	moovIndex, mdatIndex := -1, -1
+	for i, box := range boxes {
+		if box.Type == "moov" && moovIndex < 0 {
+			moovIndex = i
+		}
+		if box.Type == "mdat" && mdatIndex < 0 {
+			mdatIndex = i
+		}
+	}
+	if moovIndex < 0 {
+		return ErrNoMoov
+	}
+	if mdatIndex < 0 {
+		return ErrNoMdat
+	}
+	if moovIndex < mdatIndex {
+		_, err = io.Copy(w, io.NewSectionReader(r, 0, size))
+		return err
+	}
+
+	moov, err := ReadBox(r, boxes[moovIndex])
+	if err != nil {
+		return err
+	}
+	oldMoov := boxes[moovIndex]
+	insertAt := boxes[mdatIndex].Offset
+
+	// Everything between the insertion point and the old moov position moves
+	// back by the size of the new moov, and everything after the old moov by
+	// how much the moov grew or shrank. Widening stco to co64 grows the moov,
+	// so repeat until the offsets fit the tables.
+	move := func(moovSize int64) func(int64) int64 {
+		return func(offset int64) int64 {
+			switch {
+			case offset >= insertAt && offset < oldMoov.Offset:
+				return offset + moovSize
+			case offset >= oldMoov.End():
+				return offset + moovSize - oldMoov.Size
+			}
+			return offset
+		}
+	}
+	var encoded []byte
+	for {
+		encoded = moov.Encode()
+		widened, err := shiftChunkOffsets(moov, move(int64(len(encoded))), false)
+		if err != nil {
+			return err
+		}
+		if !widened {
+			break
+		}
+	}
+	moovSize := int64(len(encoded))
+	if _, err := shiftChunkOffsets(moov, move(moovSize), true); err != nil {
+		return err
+	}
+	encoded = moov.Encode()
+	if int64(len(encoded)) != moovSize {
+		return fmt.Errorf("%w: moov size changed while rewriting offsets", ErrInvalidBox)
+	}
+
+	for i, box := range boxes {
+		if i == mdatIndex {
+			if _, err := w.Write(encoded); err != nil {
+				return err
+			}
+		}
+		if i == moovIndex {
+			continue
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(r, box.Offset, box.Size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shiftChunkOffsets visits every chunk offset table under box and replaces
+// each offset with move(offset). Without apply nothing is changed except that
+// stco tables whose moved offsets would overflow are converted to co64, and
+// the return value reports whether that happened.
+func shiftChunkOffsets(box *Box, move func(int64) int64, apply bool) (bool, error) {
+	widened := false
+	for _, child := range box.Children {
+		w, err := shiftChunkOffsets(child, move, apply)
+		if err != nil {
+			return false, err
+		}
+		widened = widened || w
+	}
+	switch box.Type {
+	case "stco":
+		entries, err := tableEntries(box.Data, 4)
+		if err != nil {
+			return false, err
+		}
+		for i := 0; i < entries; i++ {
+			pos := 8 + i*4
+			offset := move(int64(binary.BigEndian.Uint32(box.Data[pos:])))
+			if offset > math.MaxUint32 {
+				if apply {
+					return false, fmt.Errorf("%w: chunk offset %d overflows stco", ErrInvalidBox, offset)
+				}
+				widenToCo64(box, entries)
+				return true, nil
+			}
+			if apply {
+				binary.BigEndian.PutUint32(box.Data[pos:], uint32(offset))
+			}
+		}
+	case "co64":
+		entries, err := tableEntries(box.Data, 8)
+		if err != nil {
+			return false, err
+		}
+		if !apply {
+			return widened, nil
+		}
+		for i := 0; i < entries; i++ {
+			pos := 8 + i*8
+			offset := move(int64(binary.BigEndian.Uint64(box.Data[pos:])))
+			binary.BigEndian.PutUint64(box.Data[pos:], uint64(offset))
+		}
+	}
+	return widened, nil
+}
+
+// tableEntries validates the entry count of a full box table against its payload.
+func tableEntries(data []byte, entrySize int) (int, error) {
+	if len(data) < 8 {
+		return 0, fmt.Errorf("%w: short chunk offset table", ErrInvalidBox)
+	}
+	entries := int64(binary.BigEndian.Uint32(data[4:8]))
+	if 8+entries*int64(entrySize) > int64(len(data)) {
+		return 0, fmt.Errorf("%w: chunk offset table has %d entries but only %d bytes", ErrTruncated, entries, len(data))
+	}
+	return int(entries), nil
+}
+
+// widenToCo64 turns an stco box into a co64 box holding the same offsets.
+func widenToCo64(box *Box, entries int) {
+	data := make([]byte, 8+entries*8)
+	copy(data[0:8], box.Data[0:8])
+	for i := 0; i < entries; i++ {
+		offset := binary.BigEndian.Uint32(box.Data[8+i*4:])
+		binary.BigEndian.PutUint64(data[8+i*8:], uint64(offset))
+	}
+	box.Type = "co64"
+	box.Data = data
+}
@@ -0,0 +1,205 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func leaf(boxType string, data []byte) *Box {
+	return &Box{Type: boxType, Data: data}
+}
+
+func parent(boxType string, children ...*Box) *Box {
+	return &Box{Type: boxType, Children: children}
+}
+
+// testMoov builds the moov box of a movie with a single 1920x1080 video track
+// lasting ten seconds, rotated by the given matrix, whose chunks start at
+// offsets.
+func testMoov(matrix [9]int32, offsets []uint32) *Box {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 10000)
+
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[12:], 1)
+	for i, value := range matrix {
+		binary.BigEndian.PutUint32(tkhd[40+i*4:], uint32(value))
+	}
+	binary.BigEndian.PutUint32(tkhd[76:], 1920<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], 1080<<16)
+
+	mdhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(mdhd[12:], 90000)
+	binary.BigEndian.PutUint32(mdhd[16:], 900000)
+
+	hdlr := make([]byte, 25)
+	copy(hdlr[8:], "vide")
+
+	stsd := make([]byte, 24)
+	binary.BigEndian.PutUint32(stsd[4:], 1)
+	binary.BigEndian.PutUint32(stsd[8:], 16)
+	copy(stsd[12:], "avc1")
+
+	stco := make([]byte, 8+4*len(offsets))
+	binary.BigEndian.PutUint32(stco[4:], uint32(len(offsets)))
+	for i, offset := range offsets {
+		binary.BigEndian.PutUint32(stco[8+i*4:], offset)
+	}
+
+	return parent("moov",
+		leaf("mvhd", mvhd),
+		parent("trak",
+			leaf("tkhd", tkhd),
+			parent("mdia",
+				leaf("mdhd", mdhd),
+				leaf("hdlr", hdlr),
+				parent("minf",
+					parent("stbl", leaf("stsd", stsd), leaf("stco", stco)),
+				),
+			),
+		),
+	)
+}
+
+// identity is the transformation matrix of a track that isn't rotated.
+var identity = [9]int32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000}
+
+// largeHeader rewrites an encoded box with a 64 bit size header.
+func largeHeader(encoded []byte) []byte {
+	out := make([]byte, 16, len(encoded)+8)
+	binary.BigEndian.PutUint32(out[0:4], 1)
+	copy(out[4:8], encoded[4:8])
+	binary.BigEndian.PutUint64(out[8:16], uint64(len(encoded)+8))
+	return append(out, encoded[8:]...)
+}
+
+// chunkOffsets reads the stco or co64 table of the first track of a file.
+func chunkOffsets(t *testing.T, file []byte) []int64 {
+	t.Helper()
+	boxes, err := ParseBoxes(file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, box := range boxes {
+		if box.Type != "moov" {
+			continue
+		}
+		stbl := box.Find("trak", "mdia", "minf", "stbl")
+		offsets := []int64{}
+		if stco := stbl.Child("stco"); stco != nil {
+			for i := 0; i < int(binary.BigEndian.Uint32(stco.Data[4:])); i++ {
+				offsets = append(offsets, int64(binary.BigEndian.Uint32(stco.Data[8+i*4:])))
+			}
+		}
+		if co64 := stbl.Child("co64"); co64 != nil {
+			for i := 0; i < int(binary.BigEndian.Uint32(co64.Data[4:])); i++ {
+				offsets = append(offsets, int64(binary.BigEndian.Uint64(co64.Data[8+i*8:])))
+			}
+		}
+		return offsets
+	}
+	t.Fatal("no moov box")
+	return nil
+}
+
+func TestFastStartMovesChunkOffsets(t *testing.T) {
+	ftyp := leaf("ftyp", []byte("isom\x00\x00\x02\x00isomavc1")).Encode()
+	before := leaf("mdat", []byte("chunk-one.chunk-two.")).Encode()
+	after := leaf("mdat", []byte("chunk-three.")).Encode()
+
+	build := func(moovHeader func([]byte) []byte) []byte {
+		// the moov size doesn't depend on the offsets, so it's known up front
+		size := len(moovHeader(testMoov(identity, []uint32{0, 0, 0}).Encode()))
+		beforeAt := len(ftyp)
+		afterAt := beforeAt + len(before) + size
+		offsets := []uint32{
+			uint32(beforeAt + 8),
+			uint32(beforeAt + 8 + len("chunk-one.")),
+			uint32(afterAt + 8),
+		}
+		file := append([]byte{}, ftyp...)
+		file = append(file, before...)
+		file = append(file, moovHeader(testMoov(identity, offsets).Encode())...)
+		return append(file, after...)
+	}
+
+	for name, moovHeader := range map[string]func([]byte) []byte{
+		"same size":   func(encoded []byte) []byte { return encoded },
+		"64 bit size": largeHeader,
+	} {
+		t.Run(name, func(t *testing.T) {
+			input := build(moovHeader)
+			var out bytes.Buffer
+			if err := FastStart(bytes.NewReader(input), int64(len(input)), &out); err != nil {
+				t.Fatal(err)
+			}
+			output := out.Bytes()
+
+			info, err := Probe(bytes.NewReader(output), int64(len(output)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.FastStart {
+				t.Error("moov should come before mdat")
+			}
+			inOffsets := chunkOffsets(t, input)
+			outOffsets := chunkOffsets(t, output)
+			for i, chunk := range []string{"chunk-one.", "chunk-two.", "chunk-three."} {
+				if got := string(input[inOffsets[i] : inOffsets[i]+int64(len(chunk))]); got != chunk {
+					t.Fatalf("input offset %d points at %q, the test file is wrong", i, got)
+				}
+				if got := string(output[outOffsets[i] : outOffsets[i]+int64(len(chunk))]); got != chunk {
+					t.Errorf("chunk %d: offset %d points at %q, want %q", i, outOffsets[i], got, chunk)
+				}
+			}
+		})
+	}
+}
+
+func TestFastStartKeepsFastStartFiles(t *testing.T) {
+	file := leaf("ftyp", []byte("isom")).Encode()
+	file = append(file, testMoov(identity, []uint32{0}).Encode()...)
+	file = append(file, leaf("mdat", []byte("chunk")).Encode()...)
+
+	var out bytes.Buffer
+	if err := FastStart(bytes.NewReader(file), int64(len(file)), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), file) {
+		t.Error("a file that is already fast start should be copied unchanged")
+	}
+}
+
+func FuzzFastStart(f *testing.F) {
+	for _, seed := range seedFiles() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		before, err := Probe(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		var out bytes.Buffer
+		if err := FastStart(bytes.NewReader(data), int64(len(data)), &out); err != nil {
+			return
+		}
+		output := out.Bytes()
+		after, err := Probe(bytes.NewReader(output), int64(len(output)))
+		if err != nil {
+			t.Fatalf("the output doesn't probe: %v", err)
+		}
+		if !after.FastStart {
+			t.Fatal("moov should come before mdat")
+		}
+		if after.Duration != before.Duration || len(after.Tracks) != len(before.Tracks) {
+			t.Fatalf("got %+v, want %+v", after, before)
+		}
+		for i := range before.Tracks {
+			if after.Tracks[i] != before.Tracks[i] {
+				t.Fatalf("track %d is %+v, want %+v", i, after.Tracks[i], before.Tracks[i])
+			}
+		}
+	})
+}
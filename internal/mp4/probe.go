@@ -0,0 +1,160 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Info describes a movie and its tracks.
+type Info struct {
+	// Duration is in seconds
+	Duration float64
+	Tracks   []Track
+	// FastStart is true when the moov box comes before the media data
+	FastStart bool
+}
+
+// Track describes one trak box.
+type Track struct {
+	ID int
+	// Handler is "vide" for video and "soun" for audio tracks
+	Handler string
+	// Codec is the FourCC of the first sample entry, like "avc1" or "mp4a"
+	Codec string
+	// Width and Height are the presentation size from the track header
+	Width    int
+	Height   int
+	Rotation int
+	// Duration is in seconds
+	Duration float64
+}
+
+// Probe reads the structure of the MP4 file of the given size.
+func Probe(r io.ReaderAt, size int64) (Info, error) {
+	boxes, err := ScanBoxes(r, size)
+	if err != nil {
+		return Info{}, err
+	}
+	moovIndex, mdatIndex := -1, -1
+	for i, box := range boxes {
+		if box.Type == "moov" && moovIndex < 0 {
+			moovIndex = i
+		}
+		if box.Type == "mdat" && mdatIndex < 0 {
+			mdatIndex = i
+		}
+	}
+	if moovIndex < 0 {
+		return Info{}, ErrNoMoov
+	}
+	moov, err := ReadBox(r, boxes[moovIndex])
+	if err != nil {
+		return Info{}, err
+	}
+	info, err := ParseMoov(moov)
+	if err != nil {
+		return Info{}, err
+	}
+	info.FastStart = mdatIndex < 0 || moovIndex < mdatIndex
+	return info, nil
+}
+
+// ParseMoov reads the movie and track headers from a parsed moov box.
+func ParseMoov(moov *Box) (Info, error) {
+	info := Info{}
+	mvhd := moov.Child("mvhd")
+	if mvhd == nil {
+		return Info{}, fmt.Errorf("%w: moov has no mvhd", ErrInvalidBox)
+	}
+	timescale, duration, err := readTimes(mvhd.Data)
+	if err != nil {
+		return Info{}, err
+	}
+	info.Duration = seconds(duration, timescale)
+
+	for _, trak := range moov.Children {
+		if trak.Type != "trak" {
+			continue
+		}
+		track, err := parseTrack(trak)
+		if err != nil {
+			return Info{}, err
+		}
+		info.Tracks = append(info.Tracks, track)
+	}
+	return info, nil
+}
+
+func parseTrack(trak *Box) (Track, error) {
+	track := Track{}
+	tkhd := trak.Child("tkhd")
+	if tkhd == nil {
+		return Track{}, fmt.Errorf("%w: trak has no tkhd", ErrInvalidBox)
+	}
+	// version 1 headers use 64 bit times, moving every later field by 12 bytes
+	idOffset, matrixOffset := 12, 40
+	if len(tkhd.Data) > 0 && tkhd.Data[0] == 1 {
+		idOffset, matrixOffset = 20, 52
+	}
+	if len(tkhd.Data) < matrixOffset+44 {
+		return Track{}, fmt.Errorf("%w: short tkhd", ErrInvalidBox)
+	}
+	track.ID = int(binary.BigEndian.Uint32(tkhd.Data[idOffset:]))
+	matrix := tkhd.Data[matrixOffset : matrixOffset+36]
+	track.Rotation = rotation(matrix)
+	// width and height are 16.16 fixed point numbers
+	track.Width = int(binary.BigEndian.Uint32(tkhd.Data[matrixOffset+36:]) >> 16)
+	track.Height = int(binary.BigEndian.Uint32(tkhd.Data[matrixOffset+40:]) >> 16)
+
+	if mdhd := trak.Find("mdia", "mdhd"); mdhd != nil {
+		timescale, duration, err := readTimes(mdhd.Data)
+		if err != nil {
+			return Track{}, err
+		}
+		track.Duration = seconds(duration, timescale)
+	}
+	if hdlr := trak.Find("mdia", "hdlr"); hdlr != nil && len(hdlr.Data) >= 12 {
+		track.Handler = string(hdlr.Data[8:12])
+	}
+	if stsd := trak.Find("mdia", "minf", "stbl", "stsd"); stsd != nil && len(stsd.Data) >= 16 {
+		// full box header and entry count, then the first sample entry
+		track.Codec = string(stsd.Data[12:16])
+	}
+	return track, nil
+}
+
+// readTimes decodes the timescale and duration of an mvhd or mdhd payload.
+func readTimes(data []byte) (timescale uint32, duration uint64, err error) {
+	if len(data) < 4 {
+		return 0, 0, fmt.Errorf("%w: short header", ErrInvalidBox)
+	}
+	if data[0] == 1 {
+		if len(data) < 32 {
+			return 0, 0, fmt.Errorf("%w: short header", ErrInvalidBox)
+		}
+		return binary.BigEndian.Uint32(data[20:24]), binary.BigEndian.Uint64(data[24:32]), nil
+	}
+	if len(data) < 20 {
+		return 0, 0, fmt.Errorf("%w: short header", ErrInvalidBox)
+	}
+	return binary.BigEndian.Uint32(data[12:16]), uint64(binary.BigEndian.Uint32(data[16:20])), nil
+}
+
+func seconds(duration uint64, timescale uint32) float64 {
+	if timescale == 0 || duration == math.MaxUint32 || duration == math.MaxUint64 {
+		return 0
+	}
+	return float64(duration) / float64(timescale)
+}
+
+// rotation turns the transformation matrix of a track header into clockwise
+// degrees, rounded to the nearest quarter turn.
+func rotation(matrix []byte) int {
+	a := float64(int32(binary.BigEndian.Uint32(matrix[0:4])))
+	b := float64(int32(binary.BigEndian.Uint32(matrix[4:8])))
+	degrees := math.Atan2(b, a) * 180 / math.Pi
+	quarter := int(math.Round(degrees/90)) * 90
+	return (quarter%360 + 360) % 360
+}
@@ -0,0 +1,64 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	quarter := [9]int32{0, 0x10000, 0, -0x10000, 0, 0, 0, 0, 0x40000000}
+	file := leaf("ftyp", []byte("isom")).Encode()
+	file = append(file, testMoov(quarter, []uint32{0}).Encode()...)
+	file = append(file, leaf("mdat", []byte("chunk")).Encode()...)
+
+	info, err := Probe(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 10 || !info.FastStart || len(info.Tracks) != 1 {
+		t.Fatalf("info = %+v, want one track lasting 10s in a fast start file", info)
+	}
+	track := info.Tracks[0]
+	want := Track{ID: 1, Handler: "vide", Codec: "avc1", Width: 1920, Height: 1080, Rotation: 90, Duration: 10}
+	if track != want {
+		t.Errorf("track = %+v, want %+v", track, want)
+	}
+}
+
+func TestProbeUdtaTerminator(t *testing.T) {
+	// QuickTime writes udta with a 32 bit zero after the last child
+	name := leaf("\xa9nam", []byte("Boots")).Encode()
+	udta := append(binary.BigEndian.AppendUint32(nil, uint32(8+len(name)+4)), "udta"...)
+	udta = append(append(udta, name...), 0, 0, 0, 0)
+	moov := append(testMoov(identity, []uint32{0}).Encode(), udta...)
+	binary.BigEndian.PutUint32(moov, uint32(len(moov)))
+	file := leaf("ftyp", []byte("isom")).Encode()
+	file = append(file, moov...)
+	file = append(file, leaf("mdat", []byte("chunk")).Encode()...)
+
+	info, err := Probe(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Tracks) != 1 {
+		t.Errorf("info = %+v, want one track", info)
+	}
+}
+
+func FuzzProbe(f *testing.F) {
+	for _, seed := range seedFiles() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, err := Probe(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		for _, track := range info.Tracks {
+			if track.Rotation%90 != 0 || track.Rotation < 0 || track.Rotation >= 360 {
+				t.Fatalf("rotation %d isn't a quarter turn", track.Rotation)
+			}
+		}
+	})
+}
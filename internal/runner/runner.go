@@ -0,0 +1,201 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stderrTail is how much of a failed command's stderr is kept in its Error
+const stderrTail = 4096
+
+// Runner executes external media tools like ffmpeg and ffprobe. It bounds how
+// many of them run at once and how long each operation may take.
+type Runner struct {
+	sem            chan struct{}
+	defaultTimeout time.Duration
+	timeouts       map[string]time.Duration
+}
+
+// Command describes one invocation of an external tool.
+type Command struct {
+	// Op names the operation, e.g. "probe" or "faststart". It selects the
+	// timeout and shows up in errors.
+	Op   string
+	Name string
+	Args []string
+	// Duration is the length of the input in seconds. Together with Progress
+	// it turns ffmpeg's -progress output into percentages.
+	Duration float64
+	Progress func(percent float64)
+	// Stderr, when set, receives the complete stderr of the command even
+	// when it succeeds
+	Stderr io.Writer
+}
+
+// Error is returned when a command fails, times out or is cancelled.
+type Error struct {
+	Op       string
+	Cmd      string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s: %s failed: %v", e.Op, e.Cmd, e.Err)
+	if e.Stderr != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Stderr)
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New creates a Runner allowing concurrency commands at once. Operations
+// without an entry in timeouts use defaultTimeout.
+func New(concurrency int, defaultTimeout time.Duration, timeouts map[string]time.Duration) *Runner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Runner{
+		sem:            make(chan struct{}, concurrency),
+		defaultTimeout: defaultTimeout,
+		timeouts:       timeouts,
+	}
+}
+
+func (r *Runner) timeout(op string) time.Duration {
+	if t, ok := r.timeouts[op]; ok {
+		return t
+	}
+	return r.defaultTimeout
+}
+
+// Run waits for a free slot, runs the command and returns its stdout. The
+// process is killed when ctx is done or the operation's timeout passes.
+func (r *Runner) Run(ctx context.Context, cmd Command) ([]byte, error) {
+	select {
+	case r.sem <- struct{}{}:
+		defer func() { <-r.sem }()
+	case <-ctx.Done():
+		return nil, &Error{Op: cmd.Op, Cmd: cmd.Name, ExitCode: -1, Err: ctx.Err()}
+	}
+
+	if t := r.timeout(cmd.Op); t > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
+	}
+
+	args := cmd.Args
+	var progressReader, progressWriter *os.File
+	if cmd.Progress != nil {
+		var err error
+		progressReader, progressWriter, err = os.Pipe()
+		if err != nil {
+			return nil, &Error{Op: cmd.Op, Cmd: cmd.Name, ExitCode: -1, Err: err}
+		}
+		defer progressReader.Close()
+		// the first extra file is fd 3 in the child
+		args = append([]string{"-nostats", "-progress", "pipe:3"}, args...)
+	}
+
+	var stdout, stderr bytes.Buffer
+	execCmd := exec.CommandContext(ctx, cmd.Name, args...)
+	execCmd.Stdout = &stdout
+	execCmd.Stderr = &stderr
+	if cmd.Stderr != nil {
+		execCmd.Stderr = io.MultiWriter(&stderr, cmd.Stderr)
+	}
+	execCmd.WaitDelay = 5 * time.Second
+	if progressWriter != nil {
+		execCmd.ExtraFiles = []*os.File{progressWriter}
+	}
+
+	err := execCmd.Start()
+	if progressWriter != nil {
+		progressWriter.Close()
+	}
+	if err != nil {
+		return nil, &Error{Op: cmd.Op, Cmd: cmd.Name, ExitCode: -1, Err: err}
+	}
+
+	done := make(chan struct{})
+	if progressReader != nil {
+		go func() {
+			defer close(done)
+			readProgress(progressReader, cmd.Duration, cmd.Progress)
+		}()
+	} else {
+		close(done)
+	}
+
+	err = execCmd.Wait()
+	<-done
+	if err != nil {
+		runErr := &Error{
+			Op:       cmd.Op,
+			Cmd:      cmd.Name,
+			ExitCode: execCmd.ProcessState.ExitCode(),
+			Stderr:   tail(stderr.String(), stderrTail),
+			Err:      err,
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			runErr.Err = ctxErr
+		}
+		return nil, runErr
+	}
+	return stdout.Bytes(), nil
+}
+
+// readProgress parses the key=value blocks ffmpeg writes for -progress and
+// reports the share of duration processed so far.
+func readProgress(rd io.Reader, duration float64, progress func(percent float64)) {
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us":
+			if duration <= 0 {
+				continue
+			}
+			us, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || us < 0 {
+				continue
+			}
+			percent := float64(us) / 1e6 / duration * 100
+			progress(min(percent, 100))
+		case "progress":
+			if value == "end" {
+				progress(100)
+			}
+		}
+	}
+}
+
+func tail(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
+
+// IsTimeout reports whether err comes from a command that ran out of time.
+func IsTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,179 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProgress(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		output   string
+		duration float64
+		want     []float64
+	}{
+		{
+			name:     "out_time_us",
+			output:   "frame=10\nout_time_us=2500000\nprogress=continue\nout_time_us=5000000\nprogress=continue\n",
+			duration: 10,
+			want:     []float64{25, 50},
+		},
+		{
+			name:     "end",
+			output:   "out_time_us=9000000\nprogress=end\n",
+			duration: 10,
+			want:     []float64{90, 100},
+		},
+		{
+			name:     "past the duration",
+			output:   "out_time_us=12000000\n",
+			duration: 10,
+			want:     []float64{100},
+		},
+		{
+			name:     "zero duration",
+			output:   "out_time_us=2500000\nprogress=end\n",
+			duration: 0,
+			want:     []float64{100},
+		},
+		{
+			name:     "unknown and broken lines",
+			output:   "out_time_us=N/A\nout_time_us=-1\nnot a key value pair\nbitrate=1kbits/s\n",
+			duration: 10,
+			want:     nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []float64
+			readProgress(strings.NewReader(tc.output), tc.duration, func(percent float64) {
+				got = append(got, percent)
+			})
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTail(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"  padded\n\n", 10, "padded"},
+		{"0123456789", 4, "6789"},
+		{"", 4, ""},
+	} {
+		if got := tail(tc.s, tc.n); got != tc.want {
+			t.Errorf("tail(%q, %d) = %q, want %q", tc.s, tc.n, got, tc.want)
+		}
+	}
+}
+
+func requireShell(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"sh", "sleep"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s isn't available: %v", name, err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	requireShell(t)
+	r := New(1, time.Minute, nil)
+
+	out, err := r.Run(context.Background(), Command{Op: "test", Name: "sh", Args: []string{"-c", "echo hello"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hello\n" {
+		t.Errorf("got stdout %q, want %q", out, "hello\n")
+	}
+
+	_, err = r.Run(context.Background(), Command{Op: "test", Name: "sh", Args: []string{"-c", "echo noise >&2; echo broken >&2; exit 3"}})
+	var runErr *Error
+	if !errors.As(err, &runErr) {
+		t.Fatalf("got %v, want an *Error", err)
+	}
+	if runErr.Op != "test" || runErr.ExitCode != 3 || runErr.Stderr != "noise\nbroken" {
+		t.Errorf("got %+v", runErr)
+	}
+	if IsTimeout(err) {
+		t.Error("a failed command isn't a timeout")
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	requireShell(t)
+	r := New(1, time.Minute, map[string]time.Duration{"slow": 50 * time.Millisecond})
+
+	start := time.Now()
+	_, err := r.Run(context.Background(), Command{Op: "slow", Name: "sleep", Args: []string{"10"}})
+	if !IsTimeout(err) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the command was killed after %v", elapsed)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	requireShell(t)
+	r := New(1, time.Minute, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := r.Run(ctx, Command{Op: "test", Name: "sleep", Args: []string{"10"}})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if IsTimeout(err) {
+		t.Error("a cancelled command isn't a timeout")
+	}
+}
+
+func TestRunConcurrencyLimit(t *testing.T) {
+	requireShell(t)
+	r := New(2, time.Minute, nil)
+	busy, release := context.WithCancel(context.Background())
+	defer release()
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	for range 2 {
+		go func() {
+			started <- struct{}{}
+			r.Run(busy, Command{Op: "test", Name: "sleep", Args: []string{"10"}})
+			finished <- struct{}{}
+		}()
+	}
+	<-started
+	<-started
+	// both slots are taken once the commands are running
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.sem) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := r.Run(ctx, Command{Op: "test", Name: "sh", Args: []string{"-c", "true"}})
+	var runErr *Error
+	if !errors.As(err, &runErr) || runErr.ExitCode != -1 || !IsTimeout(err) {
+		t.Fatalf("got %v, want the third command to time out waiting for a slot", err)
+	}
+
+	release()
+	<-finished
+	<-finished
+	if _, err := r.Run(context.Background(), Command{Op: "test", Name: "sh", Args: []string{"-c", "true"}}); err != nil {
+		t.Errorf("a slot should be free again: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// keyRingReloadInterval is how soon the server picks up keys rotated by the
+// rotate-keys command.
+const keyRingReloadInterval = time.Minute
+
+// keyRing returns the keys access tokens are signed and verified with. Until
+// loadKeyRing has run, that's just HS256 with the shared secret.
+func (cfg *apiConfig) keyRing() *auth.KeyRing {
+	if kr := cfg.jwtKeys.Load(); kr != nil {
+		return kr
+	}
+	kr, _ := auth.NewKeyRing(cfg.jwtSecret, nil, "")
+	return kr
+}
+
+// loadKeyRing reads the signing keys from the database. The newest active key
+// of the configured algorithm signs, every key that isn't past its retirement
+// verifies.
+func (cfg *apiConfig) loadKeyRing(ctx context.Context) error {
+	stored, err := cfg.db.GetJWTKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	keys := make([]auth.SigningKey, 0, len(stored))
+	signingKID := ""
+	for _, row := range stored {
+		if row.RetiredAt != nil && row.RetiredAt.Before(now) {
+			continue
+		}
+		key, err := auth.ParseSigningKey(row.ID, row.Algorithm, row.PrivateKey)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		if signingKID == "" && row.RetiredAt == nil && row.Algorithm == cfg.jwtSigningAlg {
+			signingKID = row.ID
+		}
+	}
+	if cfg.jwtSigningAlg != auth.AlgHS256 && signingKID == "" {
+		return fmt.Errorf("no active %s signing key, run the rotate-keys command", cfg.jwtSigningAlg)
+	}
+
+	kr, err := auth.NewKeyRing(cfg.jwtSecret, keys, signingKID)
+	if err != nil {
+		return err
+	}
+	cfg.jwtKeys.Store(kr)
+	return nil
+}
+
+// initKeyRing loads the signing keys, creating the first one when the server
+// is switched to an asymmetric algorithm.
+func (cfg *apiConfig) initKeyRing(ctx context.Context) error {
+	if cfg.jwtSigningAlg != auth.AlgHS256 {
+		stored, err := cfg.db.GetJWTKeys(ctx)
+		if err != nil {
+			return err
+		}
+		hasKey := false
+		for _, key := range stored {
+			if key.RetiredAt == nil && key.Algorithm == cfg.jwtSigningAlg {
+				hasKey = true
+			}
+		}
+		if !hasKey {
+			key, err := rotateJWTKeys(ctx, cfg.db, cfg.jwtSigningAlg, cfg.accessTokenTTL)
+			if err != nil {
+				return err
+			}
+			log.Printf("initKeyRing() created %s signing key %s", key.Algorithm, key.ID)
+		}
+	}
+	return cfg.loadKeyRing(ctx)
+}
+
+func (cfg *apiConfig) watchKeyRing() {
+	for range time.Tick(keyRingReloadInterval) {
+		if err := cfg.loadKeyRing(context.Background()); err != nil {
+			log.Println("watchKeyRing() unable to reload signing keys", err)
+		}
+	}
+}
+
+// rotateJWTKeys creates a new signing key. The keys it replaces keep
+// verifying for overlap, which should be at least the access token lifetime,
+// and keys retired before that are deleted.
+func rotateJWTKeys(ctx context.Context, db database.Client, algorithm string, overlap time.Duration) (database.JWTKey, error) {
+	key, err := auth.GenerateSigningKey(algorithm)
+	if err != nil {
+		return database.JWTKey{}, err
+	}
+	privateKey, err := key.MarshalPrivateKey()
+	if err != nil {
+		return database.JWTKey{}, err
+	}
+
+	stored, err := db.GetJWTKeys(ctx)
+	if err != nil {
+		return database.JWTKey{}, err
+	}
+	now := time.Now()
+	for _, old := range stored {
+		if old.RetiredAt != nil && old.RetiredAt.Before(now) {
+			if err := db.DeleteJWTKey(ctx, old.ID); err != nil {
+				return database.JWTKey{}, err
+			}
+		}
+	}
+
+	return db.RotateJWTKey(ctx, database.CreateJWTKeyParams{
+		ID:         key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: privateKey,
+	}, now.Add(overlap))
+}
+
+// handlerJWKS publishes the public signing keys so other services can verify
+// access tokens without the shared secret.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(keyRingReloadInterval.Seconds())))
+	respondWithJSON(w, http.StatusOK, cfg.keyRing().JWKS())
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// loginBackoffBase is the wait after the first failed login that isn't free,
+// it doubles with every further failure
+const loginBackoffBase = time.Second
+
+// throttlePolicy decides how long a key is blocked after a number of failed
+// logins in a row.
+type throttlePolicy struct {
+	// failures allowed before any wait, so a typo costs nothing
+	freeFailures int
+	// failures after which the key is locked out
+	maxFailures int
+	lockout     time.Duration
+}
+
+func (p throttlePolicy) blockFor(failures int) time.Duration {
+	if failures >= p.maxFailures {
+		return p.lockout
+	}
+	if failures <= p.freeFailures {
+		return 0
+	}
+	shift := failures - p.freeFailures - 1
+	if shift > 30 {
+		return p.lockout
+	}
+	return min(loginBackoffBase<<shift, p.lockout)
+}
+
+// loginThrottle limits password guessing per account and per client IP. The
+// IP limit is much higher since offices and mobile carriers share addresses.
+type loginThrottle struct {
+	account throttlePolicy
+	ip      throttlePolicy
+}
+
+func newLoginThrottle(maxFailures, maxIPFailures int, lockout time.Duration) loginThrottle {
+	return loginThrottle{
+		account: throttlePolicy{freeFailures: 2, maxFailures: maxFailures, lockout: lockout},
+		ip:      throttlePolicy{freeFailures: maxIPFailures / 2, maxFailures: maxIPFailures, lockout: lockout},
+	}
+}
+
+func accountThrottleKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// checkDummyPassword does the work of checking a password when there is no
+// real hash, so a login for an unknown email takes as long as one with a
+// wrong password.
+func (cfg *apiConfig) checkDummyPassword(password string) {
+	auth.CheckPasswordHash(password, cfg.dummyPasswordHash)
+}
+
+// checkLoginThrottle refuses the login with a 429 while the account or the
+// client IP is blocked. It writes the error response itself and reports
+// whether to go on.
+func (cfg *apiConfig) checkLoginThrottle(w http.ResponseWriter, r *http.Request, email string) bool {
+	return cfg.checkThrottle(r.Context(), w, "Too many failed logins, try again later", accountThrottleKey(email), ipThrottleKey(r))
+}
+
+// checkThrottle responds with a 429 while any of the keys is blocked, and
+// reports whether to go on.
+func (cfg *apiConfig) checkThrottle(ctx context.Context, w http.ResponseWriter, msg string, keys ...string) bool {
+	now := time.Now()
+	var blockedUntil time.Time
+	for _, key := range keys {
+		attempt, err := cfg.db.GetLoginAttempt(ctx, key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check failed attempts", err)
+			return false
+		}
+		if attempt.BlockedUntil != nil && attempt.BlockedUntil.After(blockedUntil) {
+			blockedUntil = *attempt.BlockedUntil
+		}
+	}
+	if blockedUntil.After(now) {
+		w.Header().Set("Retry-After", fmt.Sprint(int(blockedUntil.Sub(now).Seconds())+1))
+		respondWithError(w, http.StatusTooManyRequests, msg, nil)
+		return false
+	}
+	return true
+}
+
+// recordLoginFailure counts a wrong password against the account and the
+// client IP, and blocks them for as long as the policy says.
+func (cfg *apiConfig) recordLoginFailure(r *http.Request, email string) {
+	cfg.recordThrottledFailure(r.Context(), accountThrottleKey(email), cfg.loginThrottle.account)
+	cfg.recordThrottledFailure(r.Context(), ipThrottleKey(r), cfg.loginThrottle.ip)
+}
+
+func (cfg *apiConfig) recordThrottledFailure(ctx context.Context, key string, policy throttlePolicy) {
+	now := time.Now()
+	// failures older than a lockout are forgotten
+	failures, err := cfg.db.RecordLoginFailure(ctx, key, now, now.Add(-policy.lockout))
+	if err != nil {
+		log.Println("recordThrottledFailure() unable to record failure", err)
+		return
+	}
+	if wait := policy.blockFor(failures); wait > 0 {
+		if err := cfg.db.BlockLogin(ctx, key, now.Add(wait)); err != nil {
+			log.Println("recordThrottledFailure() unable to block", err)
+		}
+	}
+}
+
+// recordLoginSuccess clears the failures of the account. The IP keeps its
+// count, a correct password for one account says nothing about the others
+// tried from there.
+func (cfg *apiConfig) recordLoginSuccess(ctx context.Context, email string) {
+	if err := cfg.db.ClearLoginAttempts(ctx, accountThrottleKey(email)); err != nil {
+		log.Println("recordLoginSuccess() unable to clear login attempts", err)
+	}
+	if err := cfg.db.DeleteStaleLoginAttempts(ctx, time.Now().Add(-cfg.loginThrottle.account.lockout)); err != nil {
+		log.Println("recordLoginSuccess() unable to delete stale login attempts", err)
+	}
+}
@@ -6,14 +6,22 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/runner"
 	"github.com/google/uuid"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
@@ -25,8 +33,27 @@ type apiConfig struct {
 	s3Bucket         string
 	s3Region         string
 	s3CfDistribution string
-	s3Client         *s3.Client
+	uploader         Uploader
 	port             string
+	audioFormats     []string
+	prober           Prober
+	transcoder       Transcoder
+	accessTokenTTL   time.Duration
+	jwtSigningAlg    string
+	jwtKeys          atomic.Pointer[auth.KeyRing]
+	oidc             *oidc.Client
+	mailer           mailer.Mailer
+	publicURL        string
+	loginThrottle    loginThrottle
+	argon2Params     auth.Argon2Params
+	// dummyPasswordHash is checked against when there is no real hash
+	dummyPasswordHash string
+	passwordPolicy    auth.PasswordPolicy
+	// sceneAnalysis tracks the scene detections still running after uploads
+	sceneAnalysis sync.WaitGroup
+	// backgroundMail tracks the emails still being sent after their request
+	// was answered
+	backgroundMail sync.WaitGroup
 }
 
 type thumbnail struct {
@@ -45,23 +72,112 @@ func (cfg *apiConfig) initS3() error {
 	if client == nil {
 		return fmt.Errorf("failed to initialize s3 client")
 	}
-	cfg.s3Client = client
+	cfg.uploader = newS3Uploader(client, cfg.s3Bucket)
 	return nil
 }
 
+func (cfg *apiConfig) initMediaToolchain(kind string, concurrency int, timeout time.Duration) error {
+	if kind == "mp4" {
+		cfg.useMP4Toolchain()
+		return nil
+	}
+	if kind != "" && kind != "ffmpeg" {
+		return fmt.Errorf("unknown media toolchain %q", kind)
+	}
+
+	r := runner.New(concurrency, timeout, map[string]time.Duration{
+		"probe": time.Minute,
+		"frame": time.Minute,
+	})
+	toolchain, err := newFFmpegToolchain(context.Background(), r)
+	if err != nil {
+		if kind == "" {
+			log.Println("ffmpeg isn't usable, falling back to the built in mp4 toolchain:", err)
+			cfg.useMP4Toolchain()
+			return nil
+		}
+		return err
+	}
+	for _, name := range cfg.audioFormats {
+		if encoder := audioFormats[name].encoder; !toolchain.hasEncoder(encoder) {
+			return fmt.Errorf("ffmpeg has no %s encoder for %s audio renditions", encoder, name)
+		}
+	}
+	log.Printf("Using %s", toolchain.version)
+	cfg.prober = toolchain
+	cfg.transcoder = toolchain
+	return nil
+}
+
+// useMP4Toolchain switches to the pure Go toolchain, which can only probe and
+// remux MP4 files, so audio renditions are turned off.
+func (cfg *apiConfig) useMP4Toolchain() {
+	if len(cfg.audioFormats) > 0 {
+		log.Println("Audio renditions need ffmpeg and are disabled")
+		cfg.audioFormats = nil
+	}
+	cfg.prober = mp4Toolchain{}
+	cfg.transcoder = mp4Toolchain{}
+}
+
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
+	// DB_URL is a postgres:// URL or the path of an SQLite file, DB_PATH is
+	// its older name
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		dbURL = os.Getenv("DB_PATH")
+	}
+	if dbURL == "" {
 		log.Fatal("DB_URL must be set")
 	}
 
-	db, err := database.NewClient(pathToDB)
+	// every query gets at most DB_QUERY_TIMEOUT, and the ones taking longer
+	// than DB_SLOW_QUERY are logged. 0 turns either off
+	queryTimeout := 5 * time.Second
+	if value := os.Getenv("DB_QUERY_TIMEOUT"); value != "" {
+		var err error
+		queryTimeout, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid DB_QUERY_TIMEOUT: %v", err)
+		}
+	}
+
+	slowQuery := 200 * time.Millisecond
+	if value := os.Getenv("DB_SLOW_QUERY"); value != "" {
+		var err error
+		slowQuery, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid DB_SLOW_QUERY: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	db, err := database.NewClient(dbURL, queryTimeout, slowQuery)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
+	// the migrate command moves the schema itself, everything else needs it
+	// up to date
+	if len(os.Args) < 2 || os.Args[1] != "migrate" {
+		applied, err := db.MigrateUp(ctx, 0)
+		if err != nil {
+			log.Fatalf("Couldn't migrate database: %v", err)
+		}
+		for _, migration := range applied {
+			log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+		}
+	}
+
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, db, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
 		log.Fatal("JWT_SECRET environment variable is not set")
@@ -102,16 +218,188 @@ func main() {
 		log.Fatal("PORT environment variable is not set")
 	}
 
+	audioFormats, err := parseAudioFormats(os.Getenv("AUDIO_RENDITIONS"))
+	if err != nil {
+		log.Fatalf("Invalid AUDIO_RENDITIONS: %v", err)
+	}
+
+	ffmpegConcurrency := 2
+	if value := os.Getenv("FFMPEG_CONCURRENCY"); value != "" {
+		ffmpegConcurrency, err = strconv.Atoi(value)
+		if err != nil {
+			log.Fatalf("Invalid FFMPEG_CONCURRENCY: %v", err)
+		}
+	}
+
+	ffmpegTimeout := 30 * time.Minute
+	if value := os.Getenv("FFMPEG_TIMEOUT"); value != "" {
+		ffmpegTimeout, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid FFMPEG_TIMEOUT: %v", err)
+		}
+	}
+
+	// access tokens can't be revoked, so keep them short lived and let
+	// clients use their refresh token
+	accessTokenTTL := 15 * time.Minute
+	if value := os.Getenv("ACCESS_TOKEN_TTL"); value != "" {
+		accessTokenTTL, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid ACCESS_TOKEN_TTL: %v", err)
+		}
+	}
+
+	loginMaxFailures := 10
+	if value := os.Getenv("LOGIN_MAX_FAILURES"); value != "" {
+		loginMaxFailures, err = strconv.Atoi(value)
+		if err != nil {
+			log.Fatalf("Invalid LOGIN_MAX_FAILURES: %v", err)
+		}
+	}
+
+	loginMaxIPFailures := 100
+	if value := os.Getenv("LOGIN_MAX_IP_FAILURES"); value != "" {
+		loginMaxIPFailures, err = strconv.Atoi(value)
+		if err != nil {
+			log.Fatalf("Invalid LOGIN_MAX_IP_FAILURES: %v", err)
+		}
+	}
+
+	loginLockout := 15 * time.Minute
+	if value := os.Getenv("LOGIN_LOCKOUT"); value != "" {
+		loginLockout, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid LOGIN_LOCKOUT: %v", err)
+		}
+	}
+
+	argon2Params := auth.DefaultArgon2Params
+	if value := os.Getenv("ARGON2_MEMORY"); value != "" {
+		memory, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			log.Fatalf("Invalid ARGON2_MEMORY: %v", err)
+		}
+		argon2Params.Memory = uint32(memory)
+	}
+	if value := os.Getenv("ARGON2_ITERATIONS"); value != "" {
+		iterations, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || iterations == 0 {
+			log.Fatalf("Invalid ARGON2_ITERATIONS: %q", value)
+		}
+		argon2Params.Iterations = uint32(iterations)
+	}
+	if value := os.Getenv("ARGON2_PARALLELISM"); value != "" {
+		parallelism, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || parallelism == 0 {
+			log.Fatalf("Invalid ARGON2_PARALLELISM: %q", value)
+		}
+		argon2Params.Parallelism = uint8(parallelism)
+	}
+	if argon2Params.Memory < 8*uint32(argon2Params.Parallelism) {
+		log.Fatalf("ARGON2_MEMORY must be at least 8 KiB per thread")
+	}
+	// a login for an unknown email is checked against this, so it takes as
+	// long as one with a wrong password
+	dummyPasswordHash, err := auth.HashPassword("tubely-dummy-password", argon2Params)
+	if err != nil {
+		log.Fatalf("Couldn't hash dummy password: %v", err)
+	}
+
+	passwordMinLength := 8
+	if value := os.Getenv("PASSWORD_MIN_LENGTH"); value != "" {
+		passwordMinLength, err = strconv.Atoi(value)
+		if err != nil {
+			log.Fatalf("Invalid PASSWORD_MIN_LENGTH: %v", err)
+		}
+	}
+	passwordPolicy, err := auth.LoadPasswordPolicy(passwordMinLength, os.Getenv("BREACHED_PASSWORDS_FILE"))
+	if err != nil {
+		log.Fatalf("Couldn't load BREACHED_PASSWORDS_FILE: %v", err)
+	}
+
+	jwtSigningAlg := os.Getenv("JWT_SIGNING_ALG")
+	switch jwtSigningAlg {
+	case "":
+		jwtSigningAlg = auth.AlgHS256
+	case auth.AlgHS256, auth.AlgRS256, auth.AlgEdDSA:
+	default:
+		log.Fatalf("Invalid JWT_SIGNING_ALG: %q", jwtSigningAlg)
+	}
+
+	// where links in emails point to
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:" + port
+	}
+
+	// without an SMTP relay, emails are written to an outbox directory
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "Tubely <no-reply@localhost>"
+	}
+	var mail mailer.Mailer = mailer.Outbox{Dir: "./outbox", From: mailFrom}
+	if dir := os.Getenv("MAIL_OUTBOX_DIR"); dir != "" {
+		mail = mailer.Outbox{Dir: dir, From: mailFrom}
+	}
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		mail = mailer.SMTP{
+			Addr:     addr,
+			From:     mailFrom,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	}
+
+	// single sign-on is optional, the provider is contacted on first use
+	var oidcClient *oidc.Client
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+		if redirectURL == "" {
+			redirectURL = fmt.Sprintf("http://localhost:%s/api/oidc/callback", port)
+		}
+		oidcClient = &oidc.Client{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"email", "profile"},
+		}
+		if oidcClient.ClientID == "" {
+			log.Fatal("OIDC_CLIENT_ID environment variable is not set")
+		}
+	}
+
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
+		db:                db,
+		jwtSecret:         jwtSecret,
+		platform:          platform,
+		filepathRoot:      filepathRoot,
+		assetsRoot:        assetsRoot,
+		s3Bucket:          s3Bucket,
+		s3Region:          s3Region,
+		s3CfDistribution:  s3CfDistribution,
+		port:              port,
+		audioFormats:      audioFormats,
+		accessTokenTTL:    accessTokenTTL,
+		jwtSigningAlg:     jwtSigningAlg,
+		oidc:              oidcClient,
+		mailer:            mail,
+		publicURL:         strings.TrimSuffix(publicURL, "/"),
+		loginThrottle:     newLoginThrottle(loginMaxFailures, loginMaxIPFailures, loginLockout),
+		argon2Params:      argon2Params,
+		dummyPasswordHash: dummyPasswordHash,
+		passwordPolicy:    passwordPolicy,
+	}
+
+	err = cfg.initKeyRing(ctx)
+	if err != nil {
+		log.Fatalf("Couldn't load JWT signing keys: %v", err)
+	}
+	go cfg.watchKeyRing()
+
+	err = cfg.initMediaToolchain(os.Getenv("MEDIA_TOOLCHAIN"), ffmpegConcurrency, ffmpegTimeout)
+	if err != nil {
+		log.Fatalf("Couldn't initialize media toolchain: %v", err)
 	}
 
 	err = cfg.initS3()
@@ -123,28 +411,81 @@ func main() {
 	if err != nil {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
+	go cfg.resumeAccountDeletions(context.Background())
 
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", cacheMiddleware(assetsHandler))
+	mux.HandleFunc("GET /assets/{name}", cfg.handlerAsset)
+
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("GET /api/oidc/login", cfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/callback", cfg.handlerOIDCCallback)
+	mux.HandleFunc("POST /api/login/2fa", cfg.handlerLoginTwoFactor)
+	mux.HandleFunc("POST /api/password_reset", cfg.handlerPasswordResetRequest)
+	mux.HandleFunc("POST /api/password_reset/confirm", cfg.handlerPasswordReset)
+	mux.HandleFunc("POST /api/email_verification", cfg.handlerEmailVerificationSend)
+	mux.HandleFunc("POST /api/email_verification/confirm", cfg.handlerEmailVerify)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("GET /api/sessions", cfg.handlerSessionsList)
+	mux.HandleFunc("DELETE /api/sessions", cfg.handlerSessionsRevokeAll)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.handlerSessionRevoke)
+	mux.HandleFunc("POST /api/2fa/totp", cfg.handlerTOTPEnroll)
+	mux.HandleFunc("POST /api/2fa/totp/confirm", cfg.handlerTOTPConfirm)
+	mux.HandleFunc("DELETE /api/2fa/totp", cfg.handlerTOTPDisable)
+	mux.HandleFunc("POST /api/api_keys", cfg.handlerAPIKeyCreate)
+	mux.HandleFunc("GET /api/api_keys", cfg.handlerAPIKeysList)
+	mux.HandleFunc("DELETE /api/api_keys/{keyID}", cfg.handlerAPIKeyRevoke)
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("PUT /api/account/password", cfg.handlerAccountPasswordUpdate)
+	mux.HandleFunc("PUT /api/account/email", cfg.handlerAccountEmailUpdate)
+	mux.HandleFunc("DELETE /api/account", cfg.handlerAccountDelete)
 
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
 	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
 	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("GET /api/videos/shared", cfg.handlerVideosShared)
+	mux.HandleFunc("GET /api/videos/public", cfg.handlerVideosPublic)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
 	mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("PUT /api/videos/{videoID}/visibility", cfg.handlerVideoVisibilityUpdate)
+	mux.HandleFunc("GET /api/videos/{videoID}/shares", cfg.handlerVideoSharesList)
+	mux.HandleFunc("PUT /api/videos/{videoID}/shares", cfg.handlerVideoShareSet)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/shares/{userID}", cfg.handlerVideoShareDelete)
+	mux.HandleFunc("POST /api/videos/{videoID}/share_links", cfg.handlerShareLinkCreate)
+	mux.HandleFunc("GET /api/videos/{videoID}/share_links", cfg.handlerShareLinksList)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/share_links/{linkID}", cfg.handlerShareLinkRevoke)
+	mux.HandleFunc("POST /api/share_links/{token}", cfg.handlerShareLinkResolve)
+	mux.HandleFunc("POST /api/workspaces", cfg.handlerWorkspaceCreate)
+	mux.HandleFunc("GET /api/workspaces", cfg.handlerWorkspacesList)
+	mux.HandleFunc("GET /api/workspaces/{workspaceID}/members", cfg.handlerWorkspaceMembersList)
+	mux.HandleFunc("PUT /api/workspaces/{workspaceID}/members/{userID}", cfg.handlerWorkspaceMemberUpdate)
+	mux.HandleFunc("DELETE /api/workspaces/{workspaceID}/members/{userID}", cfg.handlerWorkspaceMemberRemove)
+	mux.HandleFunc("POST /api/workspaces/{workspaceID}/invitations", cfg.handlerWorkspaceInvitationCreate)
+	mux.HandleFunc("GET /api/workspaces/{workspaceID}/invitations", cfg.handlerWorkspaceInvitationsList)
+	mux.HandleFunc("DELETE /api/workspaces/{workspaceID}/invitations/{invitationID}", cfg.handlerWorkspaceInvitationRevoke)
+	mux.HandleFunc("GET /api/workspace_invitations", cfg.handlerMyWorkspaceInvitations)
+	mux.HandleFunc("POST /api/workspace_invitations/{invitationID}/accept", cfg.handlerWorkspaceInvitationAccept)
+	mux.HandleFunc("GET /api/videos/{videoID}/chapters", cfg.handlerChaptersGet)
+	mux.HandleFunc("POST /api/videos/{videoID}/chapters", cfg.handlerChapterCreate)
+	mux.HandleFunc("PUT /api/videos/{videoID}/chapters/{chapterID}", cfg.handlerChapterUpdate)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/chapters/{chapterID}", cfg.handlerChapterDelete)
+	mux.HandleFunc("GET /api/videos/{videoID}/scenes", cfg.handlerScenesGet)
+	mux.HandleFunc("POST /api/videos/{videoID}/scenes/{sceneID}/thumbnail", cfg.handlerSceneUseAsThumbnail)
+	mux.HandleFunc("POST /api/videos/{videoID}/scenes/{sceneID}/chapter", cfg.handlerScenePromoteToChapter)
 
+	mux.HandleFunc("GET /admin/users", cfg.handlerAdminUsersList)
+	mux.HandleFunc("PUT /admin/users/{userID}/role", cfg.handlerAdminUserRoleUpdate)
+	mux.HandleFunc("GET /admin/videos", cfg.handlerAdminVideosList)
+	mux.HandleFunc("GET /admin/audit_events", cfg.handlerAdminAuditEvents)
+	mux.HandleFunc("GET /admin/audit_events/export", cfg.handlerAdminAuditExport)
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
 
 	srv := &http.Server{
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/runner"
+)
+
+// Prober reads stream and container information from a media file.
+type Prober interface {
+	Probe(ctx context.Context, filePath string) (ffprobeJSON, error)
+}
+
+// Transcoder produces derived files from an uploaded video. Output files are
+// written next to filePath and must be removed by the caller.
+type Transcoder interface {
+	// FastStart moves the moov atom to the front of the file. When
+	// metadataPath points to an ffmetadata file its chapters are embedded as well.
+	FastStart(ctx context.Context, filePath, metadataPath string, duration float64) (string, error)
+	ExtractAudio(ctx context.Context, filePath, artworkPath, title string, format audioFormat, duration float64) (string, error)
+	DetectScenes(ctx context.Context, filePath string, duration float64) ([]sceneCut, error)
+	ExtractFrame(ctx context.Context, filePath string, at float64) ([]byte, error)
+	// Decode decodes every frame of the file and returns what the decoder
+	// complained about. A clean file returns no messages.
+	Decode(ctx context.Context, filePath string, duration float64) ([]string, error)
+	// Repair writes a copy of the file keeping whatever can still be read.
+	// With reencode, damaged frames are dropped and the streams re-encoded.
+	Repair(ctx context.Context, filePath string, reencode bool, duration float64) (string, error)
+}
+
+// ffmpegToolchain implements Prober and Transcoder with the ffmpeg and
+// ffprobe binaries found in PATH.
+type ffmpegToolchain struct {
+	runner   *runner.Runner
+	version  string
+	encoders map[string]bool
+}
+
+// newFFmpegToolchain checks that ffmpeg and ffprobe can be run and records
+// the ffmpeg version and the encoders it was built with.
+func newFFmpegToolchain(ctx context.Context, r *runner.Runner) (*ffmpegToolchain, error) {
+	out, err := r.Run(ctx, runner.Command{Op: "probe", Name: "ffmpeg", Args: []string{"-hide_banner", "-version"}})
+	if err != nil {
+		return nil, err
+	}
+	version, _, _ := strings.Cut(string(out), "\n")
+
+	_, err = r.Run(ctx, runner.Command{Op: "probe", Name: "ffprobe", Args: []string{"-hide_banner", "-version"}})
+	if err != nil {
+		return nil, err
+	}
+
+	out, err = r.Run(ctx, runner.Command{Op: "probe", Name: "ffmpeg", Args: []string{"-hide_banner", "-encoders"}})
+	if err != nil {
+		return nil, err
+	}
+	return &ffmpegToolchain{
+		runner:   r,
+		version:  strings.TrimSpace(version),
+		encoders: parseEncoders(out),
+	}, nil
+}
+
+// parseEncoders reads the listing of "ffmpeg -encoders", where every encoder
+// is a line of capability flags followed by its name, after a "------" rule.
+func parseEncoders(output []byte) map[string]bool {
+	encoders := map[string]bool{}
+	listing := false
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if !listing {
+			listing = len(fields) == 1 && strings.HasPrefix(fields[0], "---")
+			continue
+		}
+		if len(fields) >= 2 {
+			encoders[fields[1]] = true
+		}
+	}
+	return encoders
+}
+
+func (t *ffmpegToolchain) hasEncoder(name string) bool {
+	return t.encoders[name]
+}
+
+func (t *ffmpegToolchain) Probe(ctx context.Context, filePath string) (ffprobeJSON, error) {
+	out, err := t.runner.Run(ctx, runner.Command{
+		Op:   "probe",
+		Name: "ffprobe",
+		Args: []string{"-v", "error", "-print_format", "json", "-show_streams", "-show_format", filePath},
+	})
+	if err != nil {
+		return ffprobeJSON{}, err
+	}
+	var ffprobeOut ffprobeJSON
+	err = json.Unmarshal(out, &ffprobeOut)
+	if err != nil {
+		return ffprobeJSON{}, err
+	}
+	if len(ffprobeOut.Streams) == 0 {
+		return ffprobeJSON{}, fmt.Errorf("no streams found in %s", filePath)
+	}
+	return ffprobeOut, nil
+}
+
+func (t *ffmpegToolchain) FastStart(ctx context.Context, filePath, metadataPath string, duration float64) (string, error) {
+	outputPath := fmt.Sprintf("%s.processing", filePath)
+	args := []string{"-y", "-i", filePath}
+	if metadataPath != "" {
+		args = append(args, "-i", metadataPath, "-map", "0", "-map_metadata", "1", "-map_chapters", "1")
+	}
+	args = append(args, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputPath)
+	_, err := t.runner.Run(ctx, runner.Command{
+		Op:       "faststart",
+		Name:     "ffmpeg",
+		Args:     args,
+		Duration: duration,
+		Progress: logProgress("FastStart()", filePath),
+	})
+	if err != nil {
+		return "", err
+	}
+	return outputPath, nil
+}
+
+func (t *ffmpegToolchain) ExtractAudio(ctx context.Context, filePath, artworkPath, title string, format audioFormat, duration float64) (string, error) {
+	outputPath := fmt.Sprintf("%s%s", filePath, format.extension)
+	args := []string{"-y", "-i", filePath}
+	if format.artwork && artworkPath != "" {
+		args = append(args, "-i", artworkPath, "-map", "0:a:0", "-map", "1:v:0", "-c:v", "copy", "-disposition:v:0", "attached_pic")
+	} else {
+		args = append(args, "-map", "0:a:0", "-vn")
+	}
+	args = append(args, format.codecArgs...)
+	args = append(args, "-metadata", "title="+title, outputPath)
+	_, err := t.runner.Run(ctx, runner.Command{
+		Op:       "audio",
+		Name:     "ffmpeg",
+		Args:     args,
+		Duration: duration,
+		Progress: logProgress("ExtractAudio()", outputPath),
+	})
+	if err != nil {
+		return "", err
+	}
+	return outputPath, nil
+}
+
+func (t *ffmpegToolchain) DetectScenes(ctx context.Context, filePath string, duration float64) ([]sceneCut, error) {
+	filter := fmt.Sprintf("select='gt(scene,%g)',metadata=print:file=-", sceneThreshold)
+	out, err := t.runner.Run(ctx, runner.Command{
+		Op:       "scenes",
+		Name:     "ffmpeg",
+		Args:     []string{"-i", filePath, "-vf", filter, "-an", "-f", "null", "-"},
+		Duration: duration,
+		Progress: logProgress("DetectScenes()", filePath),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseSceneCuts(out), nil
+}
+
+func (t *ffmpegToolchain) ExtractFrame(ctx context.Context, filePath string, at float64) ([]byte, error) {
+	outputPath := fmt.Sprintf("%s.%d.jpg", filePath, int64(at*1000))
+	_, err := t.runner.Run(ctx, runner.Command{
+		Op:   "frame",
+		Name: "ffmpeg",
+		Args: []string{"-y", "-ss", strconv.FormatFloat(at, 'f', 3, 64), "-i", filePath, "-frames:v", "1", "-q:v", "2", outputPath},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(outputPath)
+	return os.ReadFile(outputPath)
+}
+
+func (t *ffmpegToolchain) Decode(ctx context.Context, filePath string, duration float64) ([]string, error) {
+	var stderr bytes.Buffer
+	_, err := t.runner.Run(ctx, runner.Command{
+		Op:       "decode",
+		Name:     "ffmpeg",
+		Args:     []string{"-v", "error", "-i", filePath, "-f", "null", "-"},
+		Duration: duration,
+		Progress: logProgress("Decode()", filePath),
+		Stderr:   &stderr,
+	})
+	var runErr *runner.Error
+	if err != nil && (!errors.As(err, &runErr) || runErr.ExitCode < 0 || ctx.Err() != nil || runner.IsTimeout(err)) {
+		return nil, err
+	}
+	messages := []string{}
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			messages = append(messages, line)
+		}
+	}
+	if err != nil && len(messages) == 0 {
+		messages = append(messages, err.Error())
+	}
+	return messages, nil
+}
+
+func (t *ffmpegToolchain) Repair(ctx context.Context, filePath string, reencode bool, duration float64) (string, error) {
+	outputPath := fmt.Sprintf("%s.repaired", filePath)
+	args := []string{"-y", "-err_detect", "ignore_err", "-fflags", "+genpts+discardcorrupt", "-i", filePath, "-map", "0:v?", "-map", "0:a?"}
+	if reencode {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-c:a", "aac")
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	args = append(args, "-f", "mp4", outputPath)
+	_, err := t.runner.Run(ctx, runner.Command{
+		Op:       "repair",
+		Name:     "ffmpeg",
+		Args:     args,
+		Duration: duration,
+		Progress: logProgress("Repair()", filePath),
+	})
+	if err != nil {
+		return "", err
+	}
+	return outputPath, nil
+}
+
+// logProgress returns a progress callback that logs every tenth percent.
+func logProgress(caller, filePath string) func(percent float64) {
+	next := 10.0
+	return func(percent float64) {
+		for percent >= next {
+			log.Printf("%s %s: %.0f%% done", caller, filePath, next)
+			next += 10
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// fakeProbeJSON is what fakeToolchain reports for every file: a ten second
+// 1080p H.264 video with a stereo AAC track.
+const fakeProbeJSON = `{
+	"streams": [
+		{"index": 0, "codec_name": "h264", "codec_type": "video", "width": 1920, "height": 1080, "display_aspect_ratio": "16:9", "duration": "10.000000"},
+		{"index": 1, "codec_name": "aac", "codec_type": "audio", "sample_rate": "48000", "channels": 2, "duration": "10.000000"}
+	],
+	"format": {"nb_streams": 2, "format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "10.000000"}
+}`
+
+// fakeFrame is a 1x1 JPEG returned for every extracted frame.
+var fakeFrame = []byte{
+	0xff, 0xd8, 0xff, 0xdb, 0x00, 0x43, 0x00, 0x03, 0x02, 0x02, 0x02, 0x02, 0x02, 0x03, 0x02, 0x02,
+	0x02, 0x03, 0x03, 0x03, 0x03, 0x04, 0x06, 0x04, 0x04, 0x04, 0x04, 0x04, 0x08, 0x06, 0x06, 0x05,
+	0x06, 0x09, 0x08, 0x0a, 0x0a, 0x09, 0x08, 0x09, 0x09, 0x0a, 0x0c, 0x0f, 0x0c, 0x0a, 0x0b, 0x0e,
+	0x0b, 0x09, 0x09, 0x0d, 0x11, 0x0d, 0x0e, 0x0f, 0x10, 0x10, 0x11, 0x10, 0x0a, 0x0c, 0x12, 0x13,
+	0x12, 0x10, 0x13, 0x0f, 0x10, 0x10, 0x10, 0xff, 0xc9, 0x00, 0x0b, 0x08, 0x00, 0x01, 0x00, 0x01,
+	0x01, 0x01, 0x11, 0x00, 0xff, 0xcc, 0x00, 0x06, 0x00, 0x10, 0x10, 0x05, 0xff, 0xda, 0x00, 0x08,
+	0x01, 0x01, 0x00, 0x00, 0x3f, 0x00, 0xd2, 0xcf, 0x20, 0xff, 0xd9,
+}
+
+// fakeToolchain is a deterministic Prober and Transcoder for tests. It never
+// looks at the contents of the files it is given.
+type fakeToolchain struct {
+	Scenes []sceneCut
+	// DecodeErrors is returned by Decode until the file has been repaired
+	DecodeErrors []string
+}
+
+func newFakeToolchain() *fakeToolchain {
+	return &fakeToolchain{
+		Scenes: []sceneCut{{time: 2.5, score: 0.8}, {time: 6, score: 0.45}},
+	}
+}
+
+func (f *fakeToolchain) Probe(ctx context.Context, filePath string) (ffprobeJSON, error) {
+	var out ffprobeJSON
+	err := json.Unmarshal([]byte(fakeProbeJSON), &out)
+	return out, err
+}
+
+func (f *fakeToolchain) FastStart(ctx context.Context, filePath, metadataPath string, duration float64) (string, error) {
+	outputPath := fmt.Sprintf("%s.processing", filePath)
+	return outputPath, copyFile(filePath, outputPath)
+}
+
+func (f *fakeToolchain) ExtractAudio(ctx context.Context, filePath, artworkPath, title string, format audioFormat, duration float64) (string, error) {
+	outputPath := fmt.Sprintf("%s%s", filePath, format.extension)
+	return outputPath, os.WriteFile(outputPath, []byte("fake "+format.extension+" audio: "+title), 0600)
+}
+
+func (f *fakeToolchain) DetectScenes(ctx context.Context, filePath string, duration float64) ([]sceneCut, error) {
+	cuts := []sceneCut{}
+	for _, cut := range f.Scenes {
+		if cut.time < duration {
+			cuts = append(cuts, cut)
+		}
+	}
+	return cuts, nil
+}
+
+func (f *fakeToolchain) ExtractFrame(ctx context.Context, filePath string, at float64) ([]byte, error) {
+	return fakeFrame, nil
+}
+
+func (f *fakeToolchain) Decode(ctx context.Context, filePath string, duration float64) ([]string, error) {
+	if strings.HasSuffix(filePath, ".repaired") {
+		return []string{}, nil
+	}
+	return append([]string{}, f.DecodeErrors...), nil
+}
+
+func (f *fakeToolchain) Repair(ctx context.Context, filePath string, reencode bool, duration float64) (string, error) {
+	outputPath := fmt.Sprintf("%s.repaired", filePath)
+	return outputPath, copyFile(filePath, outputPath)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mp4"
+)
+
+var errNeedsFFmpeg = errors.New("this operation needs ffmpeg, which isn't available")
+
+// mp4Codecs maps sample entry FourCCs to the codec names ffprobe reports
+var mp4Codecs = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp09": "vp9",
+	"mp4a": "aac",
+	"Opus": "opus",
+	"ac-3": "ac3",
+}
+
+// mp4Toolchain reads and remuxes MP4 files in pure Go. It is used when ffmpeg
+// isn't installed and supports probing and faststart only.
+type mp4Toolchain struct{}
+
+func (t mp4Toolchain) Probe(ctx context.Context, filePath string) (ffprobeJSON, error) {
+	fp, err := os.Open(filePath)
+	if err != nil {
+		return ffprobeJSON{}, err
+	}
+	defer fp.Close()
+	stat, err := fp.Stat()
+	if err != nil {
+		return ffprobeJSON{}, err
+	}
+	info, err := mp4.Probe(fp, stat.Size())
+	if err != nil {
+		return ffprobeJSON{}, err
+	}
+	if len(info.Tracks) == 0 {
+		return ffprobeJSON{}, fmt.Errorf("no streams found in %s", filePath)
+	}
+
+	var out ffprobeJSON
+	out.Streams = slices.Grow(out.Streams, len(info.Tracks))[:len(info.Tracks)]
+	for i, track := range info.Tracks {
+		stream := &out.Streams[i]
+		stream.Index = i
+		stream.ID = fmt.Sprintf("0x%x", track.ID)
+		stream.CodecName = mp4Codecs[track.Codec]
+		stream.CodecTagString = track.Codec
+		stream.Duration = strconv.FormatFloat(track.Duration, 'f', 6, 64)
+		switch track.Handler {
+		case "vide":
+			stream.CodecType = "video"
+			// the track header has the size before rotation, so portrait
+			// phone videos show up as landscape frames turned a quarter
+			width, height := track.Width, track.Height
+			if track.Rotation == 90 || track.Rotation == 270 {
+				width, height = height, width
+			}
+			stream.Width = width
+			stream.Height = height
+			stream.DisplayAspectRatio = displayAspectRatio(width, height)
+		case "soun":
+			stream.CodecType = "audio"
+		default:
+			stream.CodecType = "data"
+		}
+	}
+	out.Format.Filename = filePath
+	out.Format.NbStreams = len(info.Tracks)
+	out.Format.FormatName = "mov,mp4,m4a,3gp,3g2,mj2"
+	out.Format.Duration = strconv.FormatFloat(info.Duration, 'f', 6, 64)
+	out.Format.Size = strconv.FormatInt(stat.Size(), 10)
+	return out, nil
+}
+
+func displayAspectRatio(width, height int) string {
+	if width == 0 || height == 0 {
+		return ""
+	}
+	a, b := width, height
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return fmt.Sprintf("%d:%d", width/a, height/a)
+}
+
+func (t mp4Toolchain) FastStart(ctx context.Context, filePath, metadataPath string, duration float64) (string, error) {
+	if metadataPath != "" {
+		log.Println("FastStart() chapters can't be embedded without ffmpeg, skipping", metadataPath)
+	}
+	in, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return "", err
+	}
+	outputPath := fmt.Sprintf("%s.processing", filePath)
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return "", err
+	}
+	err = mp4.FastStart(in, stat.Size(), out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return "", err
+	}
+	return outputPath, nil
+}
+
+func (t mp4Toolchain) ExtractAudio(ctx context.Context, filePath, artworkPath, title string, format audioFormat, duration float64) (string, error) {
+	return "", errNeedsFFmpeg
+}
+
+func (t mp4Toolchain) DetectScenes(ctx context.Context, filePath string, duration float64) ([]sceneCut, error) {
+	return nil, errNeedsFFmpeg
+}
+
+func (t mp4Toolchain) ExtractFrame(ctx context.Context, filePath string, at float64) ([]byte, error) {
+	return nil, errNeedsFFmpeg
+}
+
+func (t mp4Toolchain) Decode(ctx context.Context, filePath string, duration float64) ([]string, error) {
+	return nil, errNeedsFFmpeg
+}
+
+func (t mp4Toolchain) Repair(ctx context.Context, filePath string, reencode bool, duration float64) (string, error) {
+	return "", errNeedsFFmpeg
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMP4ToolchainProbeRotation(t *testing.T) {
+	tests := []struct {
+		name          string
+		matrix        [4]int32
+		width, height int
+		aspect        string
+	}{
+		{"none", [4]int32{0x10000, 0, 0, 0x10000}, 1920, 1080, "landscape"},
+		{"90", [4]int32{0, 0x10000, -0x10000, 0}, 1080, 1920, "portrait"},
+		{"180", [4]int32{-0x10000, 0, 0, -0x10000}, 1920, 1080, "landscape"},
+		{"270", [4]int32{0, -0x10000, 0x10000, 0}, 1080, 1920, "portrait"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "video.mp4")
+			if err := os.WriteFile(path, testMP4Rotated(tt.matrix), 0600); err != nil {
+				t.Fatal(err)
+			}
+			probe, err := mp4Toolchain{}.Probe(context.Background(), path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream := probe.Streams[0]
+			if stream.Width != tt.width || stream.Height != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", stream.Width, stream.Height, tt.width, tt.height)
+			}
+			if got := probe.aspectRatio(); got != tt.aspect {
+				t.Errorf("aspect ratio = %q, want %q", got, tt.aspect)
+			}
+		})
+	}
+}
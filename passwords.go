@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// checkPasswordPolicy refuses a new password the policy doesn't accept. It
+// writes the error response itself and reports whether to go on.
+func (cfg *apiConfig) checkPasswordPolicy(w http.ResponseWriter, password string) bool {
+	if err := cfg.passwordPolicy.Check(password); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return false
+	}
+	return true
+}
+
+// upgradePasswordHash replaces the hash of a password that was just checked
+// when it's a bcrypt hash or was made with other argon2id parameters. Failing
+// to is only logged, the old hash still works.
+func (cfg *apiConfig) upgradePasswordHash(ctx context.Context, userID uuid.UUID, password, hash string) {
+	if !auth.NeedsRehash(hash, cfg.argon2Params) {
+		return
+	}
+	newHash, err := auth.HashPassword(password, cfg.argon2Params)
+	if err != nil {
+		log.Println("upgradePasswordHash() unable to hash password", err)
+		return
+	}
+	if err := cfg.db.UpdateUserPassword(ctx, userID, newHash); err != nil {
+		log.Println("upgradePasswordHash() unable to store new hash", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// authorize authenticates the caller for scope and makes sure their role
+// grants permission. It writes the error response itself and reports whether
+// to go on.
+func (cfg *apiConfig) authorize(w http.ResponseWriter, r *http.Request, scope, permission string) (database.User, bool) {
+	userID, ok := cfg.authenticate(w, r, scope)
+	if !ok {
+		return database.User{}, false
+	}
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.User{}, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "User no longer exists", nil)
+		return database.User{}, false
+	}
+	if !auth.RoleHasPermission(user.Role, permission) {
+		cfg.audit(r, user.ID, auditPermissionDenied, auditTargetUser, user.ID.String(), database.AuditOutcomeDenied,
+			fmt.Sprintf("%s role lacks %s for %s %s", user.Role, permission, r.Method, r.URL.Path))
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("The %s role doesn't allow this", user.Role), nil)
+		return database.User{}, false
+	}
+	return *user, true
+}
+
+// userCan reports whether the role of the user grants permission.
+func (cfg *apiConfig) userCan(ctx context.Context, userID uuid.UUID, permission string) (bool, error) {
+	user, err := cfg.db.GetUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if user == nil {
+		return false, nil
+	}
+	return auth.RoleHasPermission(user.Role, permission), nil
+}
+
+// videoAction is something a caller wants to do with a video.
+type videoAction int
+
+const (
+	videoView videoAction = iota
+	videoEdit
+	// videoManage is changing who may see the video
+	videoManage
+	videoDelete
+)
+
+// anyVideoPermission is the role permission that allows an action on videos
+// of other users.
+var anyVideoPermission = map[videoAction]string{
+	videoView:   auth.PermissionReadAnyVideo,
+	videoEdit:   auth.PermissionEditAnyVideo,
+	videoManage: auth.PermissionEditAnyVideo,
+	videoDelete: auth.PermissionDeleteAnyVideo,
+}
+
+// canOnVideo reports whether the user may take action on the video. The owner
+// may do anything, others need a share, a visible video or a role that allows
+// it. Workspace videos are owned by the workspace: editors and admins may do
+// anything, viewers may watch. userID is uuid.Nil for anonymous callers.
+func (cfg *apiConfig) canOnVideo(ctx context.Context, userID uuid.UUID, video database.Video, action videoAction) (bool, error) {
+	if userID != uuid.Nil && video.WorkspaceID != nil {
+		role, err := cfg.db.GetWorkspaceRole(ctx, *video.WorkspaceID, userID)
+		if err != nil {
+			return false, err
+		}
+		if workspaceRoleAllows(role, action) {
+			return true, nil
+		}
+	} else if userID != uuid.Nil && video.UserID == userID {
+		return true, nil
+	}
+	if action == videoView && video.Visibility != database.VisibilityPrivate {
+		return true, nil
+	}
+	if userID == uuid.Nil {
+		return false, nil
+	}
+
+	if action == videoView || action == videoEdit {
+		permission, err := cfg.db.GetVideoShare(ctx, video.ID, userID)
+		if err != nil {
+			return false, err
+		}
+		if permission == database.SharePermissionEdit || (permission == database.SharePermissionView && action == videoView) {
+			return true, nil
+		}
+	}
+	return cfg.userCan(ctx, userID, anyVideoPermission[action])
+}
+
+// workspaceRoleAllows reports whether a workspace member with role may take
+// action on the videos of the workspace. role is "" for non-members.
+func workspaceRoleAllows(role string, action videoAction) bool {
+	switch role {
+	case database.WorkspaceRoleAdmin, database.WorkspaceRoleEditor:
+		return true
+	case database.WorkspaceRoleViewer:
+		return action == videoView
+	}
+	return false
+}
+
+// checkVideoAccess writes the error response when the user may not take
+// action on the video, and reports whether to go on.
+func (cfg *apiConfig) checkVideoAccess(ctx context.Context, w http.ResponseWriter, userID uuid.UUID, video database.Video, action videoAction) bool {
+	allowed, err := cfg.canOnVideo(ctx, userID, video, action)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check permissions", err)
+		return false
+	}
+	if allowed {
+		return true
+	}
+	// private videos look the same as missing ones to those who can't see them
+	if seen, err := cfg.canOnVideo(ctx, userID, video, videoView); err == nil && !seen {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return false
+	}
+	respondWithError(w, http.StatusForbidden, "You can't access this video", nil)
+	return false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	// sceneThreshold is the minimum ffmpeg scene score for a frame to count as a cut
+	sceneThreshold = 0.3
+	// sceneCandidates is how many of the strongest cuts get a candidate frame
+	sceneCandidates = 5
+)
+
+type sceneCut struct {
+	time  float64
+	score float64
+}
+
+// parseSceneCuts reads the output of ffmpeg's metadata=print filter, which
+// prints a "frame:N pts:N pts_time:T" line followed by the frame's tags.
+func parseSceneCuts(output []byte) []sceneCut {
+	cuts := []sceneCut{}
+	current := -1.0
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "frame:") {
+			current = -1
+			for _, field := range strings.Fields(line) {
+				if value, ok := strings.CutPrefix(field, "pts_time:"); ok {
+					if t, err := strconv.ParseFloat(value, 64); err == nil {
+						current = t
+					}
+				}
+			}
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "lavfi.scene_score="); ok && current >= 0 {
+			score, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			cuts = append(cuts, sceneCut{time: current, score: score})
+		}
+	}
+	return cuts
+}
+
+// analyzeScenes replaces the recorded scene cuts of a video with the ones
+// found in filePath, and saves a candidate frame for the strongest cuts. The
+// new cuts are swapped in only once all of them were made, and the frames of
+// the old ones are removed then, except the one used as the thumbnail.
+func (cfg *apiConfig) analyzeScenes(ctx context.Context, video database.Video, filePath string) ([]database.SceneCut, error) {
+	duration := 0.0
+	if video.Duration != nil {
+		duration = *video.Duration
+	}
+	cuts, err := cfg.transcoder.DetectScenes(ctx, filePath, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(cuts, func(i, j int) bool { return cuts[i].score > cuts[j].score })
+	params := []database.CreateSceneCutParams{}
+	removeFrames := func() {
+		for _, p := range params {
+			if err := cfg.removeAsset(p.FrameURL); err != nil {
+				log.Println("analyzeScenes() unable to remove frame", err)
+			}
+		}
+	}
+	for i, cut := range cuts {
+		p := database.CreateSceneCutParams{
+			VideoID: video.ID,
+			Time:    cut.time,
+			Score:   cut.score,
+		}
+		if i < sceneCandidates {
+			frame, err := cfg.transcoder.ExtractFrame(ctx, filePath, cut.time)
+			if err != nil {
+				removeFrames()
+				return nil, err
+			}
+			fName, err := cfg.saveFileToDisc(thumbnail{data: frame, mediaType: "image/jpeg"}, ".jpg")
+			if err != nil {
+				removeFrames()
+				return nil, err
+			}
+			frameURL := fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, fName)
+			p.FrameURL = &frameURL
+		}
+		params = append(params, p)
+	}
+
+	old, err := cfg.db.GetSceneCuts(ctx, video.ID)
+	if err != nil {
+		removeFrames()
+		return nil, err
+	}
+	saved, err := cfg.db.ReplaceSceneCuts(ctx, video.ID, params)
+	if err != nil {
+		removeFrames()
+		return nil, err
+	}
+
+	// the thumbnail may have been picked from the old frames in the meantime
+	current, err := cfg.db.GetVideo(ctx, video.ID)
+	if err != nil {
+		return saved, err
+	}
+	for _, cut := range old {
+		if cut.FrameURL == nil || (current.ThumbnailURL != nil && *current.ThumbnailURL == *cut.FrameURL) {
+			continue
+		}
+		if err := cfg.removeAsset(cut.FrameURL); err != nil {
+			log.Println("analyzeScenes() unable to remove old frame", err)
+		}
+	}
+	return saved, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Uploader keeps the uploaded media files in a bucket, by key.
+type Uploader interface {
+	Put(ctx context.Context, key, contentType string, body io.Reader) error
+	// Delete removes a file. Deleting a key that doesn't exist isn't an error.
+	Delete(ctx context.Context, key string) error
+	// PresignGet returns a URL that downloads the file until lifetime is up.
+	PresignGet(ctx context.Context, key string, lifetime time.Duration) (string, error)
+}
+
+// s3Uploader is the Uploader storing files in an S3 bucket.
+type s3Uploader struct {
+	client  *s3.Client
+	presign *s3.PresignClient
+	bucket  string
+}
+
+func newS3Uploader(client *s3.Client, bucket string) *s3Uploader {
+	return &s3Uploader{
+		client:  client,
+		presign: s3.NewPresignClient(client),
+		bucket:  bucket,
+	}
+}
+
+func (u *s3Uploader) Put(ctx context.Context, key, contentType string, body io.Reader) error {
+	_, err := u.client.PutObject(ctx, &s3.PutObjectInput{Bucket: &u.bucket, Key: &key, Body: body, ContentType: &contentType})
+	return err
+}
+
+func (u *s3Uploader) Delete(ctx context.Context, key string) error {
+	_, err := u.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &u.bucket, Key: &key})
+	return err
+}
+
+func (u *s3Uploader) PresignGet(ctx context.Context, key string, lifetime time.Duration) (string, error) {
+	presigned, err := u.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: &u.bucket,
+		Key:    &key,
+	}, s3.WithPresignExpires(lifetime))
+	if err != nil {
+		return "", err
+	}
+	return presigned.URL, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const (
+	purposeVerifyEmail   = "verify_email"
+	purposeResetPassword = "reset_password"
+
+	verifyEmailTokenLifetime   = 48 * time.Hour
+	resetPasswordTokenLifetime = time.Hour
+)
+
+var errInvalidUserToken = errors.New("the link is invalid, expired or was already used")
+
+// validateEmail accepts a bare address like "user@example.com", without a
+// display name.
+func validateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return err
+	}
+	if address.Address != email {
+		return fmt.Errorf("%q isn't a bare email address", email)
+	}
+	return nil
+}
+
+// issueUserToken creates a single-use token for purpose, bound to the user's
+// current email address.
+func (cfg *apiConfig) issueUserToken(ctx context.Context, user database.User, purpose string, lifetime time.Duration) (string, error) {
+	token, err := cfg.db.CreateUserToken(ctx, database.CreateUserTokenParams{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(lifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	return auth.SignUserToken(cfg.jwtSecret, userTokenClaims(token)), nil
+}
+
+// consumeUserToken checks a token made by issueUserToken and uses it up. The
+// token must have been sent to the user's current email address.
+func (cfg *apiConfig) consumeUserToken(ctx context.Context, tokenString, purpose string) (database.UserToken, error) {
+	id, err := auth.UserTokenID(tokenString)
+	if err != nil {
+		return database.UserToken{}, errInvalidUserToken
+	}
+	token, err := cfg.db.GetUserToken(ctx, id)
+	if err != nil {
+		return database.UserToken{}, err
+	}
+	if token.ID == uuid.Nil || token.Purpose != purpose || token.UsedAt != nil || token.ExpiresAt.Before(time.Now()) {
+		return database.UserToken{}, errInvalidUserToken
+	}
+	if auth.CheckUserToken(cfg.jwtSecret, tokenString, userTokenClaims(token)) != nil {
+		return database.UserToken{}, errInvalidUserToken
+	}
+	// a link sent to an address the user has since changed away from
+	user, err := cfg.db.GetUser(ctx, token.UserID)
+	if err != nil {
+		return database.UserToken{}, err
+	}
+	if user == nil || user.Email != token.Email {
+		return database.UserToken{}, errInvalidUserToken
+	}
+
+	consumed, err := cfg.db.ConsumeUserToken(ctx, token)
+	if err != nil {
+		return database.UserToken{}, err
+	}
+	if !consumed {
+		return database.UserToken{}, errInvalidUserToken
+	}
+	return token, nil
+}
+
+func userTokenClaims(token database.UserToken) auth.UserTokenClaims {
+	return auth.UserTokenClaims{
+		ID:        token.ID,
+		Purpose:   token.Purpose,
+		UserID:    token.UserID,
+		Email:     token.Email,
+		ExpiresAt: token.ExpiresAt,
+	}
+}
+
+// appLink is a link into the web app, which reads the token from the URL
+// fragment so it doesn't end up in server logs.
+func (cfg *apiConfig) appLink(action, token string) string {
+	fragment := url.Values{}
+	fragment.Set(action, token)
+	return cfg.publicURL + "/app/#" + fragment.Encode()
+}
+
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := cfg.issueUserToken(ctx, user, purposeVerifyEmail, verifyEmailTokenLifetime)
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your Tubely email address",
+		Body: fmt.Sprintf("Open this link to confirm that %s is your email address:\n\n%s\n\n"+
+			"The link expires in %s. If you didn't sign up for Tubely, you can ignore this email.\n",
+			user.Email, cfg.appLink(purposeVerifyEmail, token), verifyEmailTokenLifetime),
+	})
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(ctx context.Context, user database.User) error {
+	token, err := cfg.issueUserToken(ctx, user, purposeResetPassword, resetPasswordTokenLifetime)
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Tubely password",
+		Body: fmt.Sprintf("Open this link to choose a new password:\n\n%s\n\n"+
+			"The link expires in %s. If you didn't ask to reset your password, you can ignore this email.\n",
+			cfg.appLink(purposeResetPassword, token), resetPasswordTokenLifetime),
+	})
+}